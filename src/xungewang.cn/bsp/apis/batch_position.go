@@ -0,0 +1,135 @@
+package apis
+
+import (
+	"github.com/go-ozzo/ozzo-routing"
+	"github.com/go-ozzo/ozzo-validation"
+	"xungewang.cn/bsp/app"
+	"xungewang.cn/bsp/coord"
+	"xungewang.cn/bsp/errors"
+)
+
+type (
+	// BatchPositionRequest positions many devices in one call
+	BatchPositionRequest struct {
+		Items []BatchPositionItem `json:"items"`
+
+		// name of the positioning algorithm applied to all devices, see PositionRequest.Algorithm
+		Algorithm string `json:"algorithm"`
+
+		// coordinate type of results, see PositionRequest.CoordType
+		CoordType string `json:"coord_type"`
+	}
+
+	BatchPositionItem struct {
+		// whatever identifies the device, which is echoed back in the result
+		DeviceId string   `json:"device_id"`
+		Signals  []Signal `json:"signals"`
+	}
+
+	// BatchPositionItemResult is either the position of a device, or the error it fails with
+	BatchPositionItemResult struct {
+		DeviceId string `json:"device_id"`
+
+		// 200 if positioned, otherwise the HTTP status of the error
+		Code int `json:"code"`
+
+		*PositionResult
+
+		Error *errors.APIError `json:"error,omitempty"`
+	}
+
+	BatchPositionResult struct {
+		Code int `json:"code"`
+
+		// results of devices, in the order of request items
+		Results []*BatchPositionItemResult `json:"results"`
+	}
+)
+
+func (request *BatchPositionRequest) Validate() error {
+	return validation.ValidateStruct(request,
+		validation.Field(&request.Items, validation.Required, validation.Length(1, app.Config.MaxBatchSize)),
+		validation.Field(&request.CoordType, validation.In(coord.Types...)),
+	)
+}
+
+// Validate validates the item on its own, which doesn't fail the batch
+func (item *BatchPositionItem) Validate() error {
+	if err := validation.ValidateStruct(item,
+		validation.Field(&item.Signals, validation.Required),
+	); err != nil {
+		return err
+	}
+
+	// validate each signal in the item
+	for _, signal := range item.Signals {
+		if err := signal.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// NewBatchPositionItemResult creates result of a device positioned
+func NewBatchPositionItemResult(deviceId string, position *PositionResult) *BatchPositionItemResult {
+	return &BatchPositionItemResult{DeviceId: deviceId, Code: position.Code, PositionResult: position}
+}
+
+// NewBatchPositionItemError creates result of a device failed to be positioned
+func NewBatchPositionItemError(deviceId string, err error) *BatchPositionItemResult {
+	var apiError *errors.APIError
+	switch err.(type) {
+	case *errors.APIError:
+		apiError = err.(*errors.APIError)
+	case validation.Errors:
+		apiError = errors.InvalidData(err.(validation.Errors))
+	default:
+		apiError = errors.InternalServerError(err)
+	}
+
+	return &BatchPositionItemResult{DeviceId: deviceId, Code: apiError.Status, Error: apiError}
+}
+
+// computePositions positions devices in a batch. Items failing validation get their errors right away, while the
+// others are positioned all together.
+func (r *positionResource) computePositions(ctx *routing.Context) error {
+	request := &BatchPositionRequest{}
+	if err := ctx.Read(request); err != nil {
+		return errors.SimpleInvalidData("request not acceptable. pay special attention on fields type and value. For " +
+			"example, mcc, mnc, lac and cid should be of string type, and str double.")
+	}
+	if err := request.Validate(); err != nil {
+		return err
+	}
+
+	results := make([]*BatchPositionItemResult, len(request.Items))
+	valid := &BatchPositionRequest{Algorithm: request.Algorithm}
+	var indexes []int // indexes of valid items in the request
+	for i := range request.Items {
+		item := &request.Items[i]
+		applySignalDefaults(item.Signals, app.Config.DefaultMcc)
+
+		if err := item.Validate(); err != nil {
+			results[i] = NewBatchPositionItemError(item.DeviceId, err)
+			continue
+		}
+		valid.Items = append(valid.Items, *item)
+		indexes = append(indexes, i)
+	}
+
+	if len(valid.Items) > 0 {
+		positions, err := r.service.ComputePositions(app.GetRequestScope(ctx), valid)
+		if err != nil {
+			return err
+		}
+		for i, position := range positions {
+			if position.PositionResult != nil {
+				position.convertTo(request.CoordType)
+			}
+			results[indexes[i]] = position
+		}
+	}
+
+	return ctx.Write(&BatchPositionResult{Code: 200, Results: results})
+}
@@ -0,0 +1,56 @@
+package apis
+
+import (
+	"github.com/go-ozzo/ozzo-dbx"
+	"github.com/go-ozzo/ozzo-routing"
+	"github.com/go-ozzo/ozzo-routing/content"
+	"strings"
+	"xungewang.cn/bsp/app"
+	"xungewang.cn/bsp/models"
+)
+
+type (
+	// contract cell related behaviors
+	cellService interface {
+		Lookup(ctx app.RequestScope, signal Signal) (*models.Station, error)
+	}
+
+	// wrapper of cellService
+	cellResource struct {
+		service cellService
+	}
+)
+
+// SetupCellRouter sets up routes to look up single cells, e.g. '/cells?mcc=460&mnc=0&lac=32838&cid=60122'
+func SetupCellRouter(group *routing.RouteGroup, db *dbx.DB, service cellService) {
+	r := &cellResource{service}
+
+	group.Use(
+		content.TypeNegotiator(content.JSON),
+		app.DbAware(db),
+	)
+
+	group.Get("/cells", r.lookup)
+}
+
+// lookup finds the station of the cell given by radio, mcc, mnc, lac and cid, where radio and mcc are optional
+func (r *cellResource) lookup(ctx *routing.Context) error {
+	signals := []Signal{{
+		Radio: strings.ToLower(ctx.Query("radio")),
+		Mcc:   ctx.Query("mcc"),
+		Mnc:   ctx.Query("mnc"),
+		Lac:   ctx.Query("lac"),
+		Cid:   ctx.Query("cid"),
+	}}
+	applySignalDefaults(signals, app.Config.DefaultMcc)
+	if err := signals[0].Validate(); err != nil {
+		return err
+	}
+
+	station, err := r.service.Lookup(app.GetRequestScope(ctx), signals[0])
+	if err != nil {
+		return err
+	}
+
+	return ctx.Write(station)
+}
@@ -0,0 +1,277 @@
+package apis
+
+import (
+	"github.com/go-ozzo/ozzo-dbx"
+	"github.com/go-ozzo/ozzo-routing"
+	"github.com/go-ozzo/ozzo-routing/content"
+	"net/http"
+	"strconv"
+	"strings"
+	"xungewang.cn/bsp/app"
+	"xungewang.cn/bsp/errors"
+)
+
+type (
+	// GeolocateRequest is the request body of Google Geolocation API, see
+	// https://developers.google.com/maps/documentation/geolocation/overview, as well as the one of Mozilla
+	// Location Service (a.k.a. Ichnaea), see https://ichnaea.readthedocs.io/en/latest/api/geolocate.html
+	GeolocateRequest struct {
+		// nil if not given, which tells MNC 0 from a missing one
+		HomeMobileCountryCode *int   `json:"homeMobileCountryCode"`
+		HomeMobileNetworkCode *int   `json:"homeMobileNetworkCode"`
+		RadioType             string `json:"radioType"`
+		Carrier               string `json:"carrier"`
+		ConsiderIp            *bool  `json:"considerIp"`
+
+		CellTowers []CellTower `json:"cellTowers"`
+
+		// accepted for compatibility, but not used since there's no data of wifi access points
+		WifiAccessPoints []WifiAccessPoint `json:"wifiAccessPoints"`
+
+		// MLS only, which Google clients never send
+		Fallbacks *GeolocateFallbacks `json:"fallbacks"`
+	}
+
+	// GeolocateFallbacks tells what to fall back on when no stations are known, all enabled by default
+	GeolocateFallbacks struct {
+		// locate by the areas cells are in
+		Lacf *bool `json:"lacf"`
+		// locate by IP address, which is not supported
+		Ipf *bool `json:"ipf"`
+	}
+
+	CellTower struct {
+		CellId int64 `json:"cellId"`
+		// cell id of NR cells, which don't fit in cellId
+		NewRadioCellId   int64 `json:"newRadioCellId"`
+		LocationAreaCode int64 `json:"locationAreaCode"`
+		// nil if not given, in which case those of the request are taken
+		MobileCountryCode *int     `json:"mobileCountryCode"`
+		MobileNetworkCode *int     `json:"mobileNetworkCode"`
+		Age               int64    `json:"age"`
+		SignalStrength    *float64 `json:"signalStrength"`
+		TimingAdvance     int      `json:"timingAdvance"`
+
+		// MLS only, Arbitrary Strength Unit which signal strength is derived from if it's missing
+		Asu                   int `json:"asu"`
+		PrimaryScramblingCode int `json:"psc"`
+		Serving               int `json:"serving"`
+
+		// radio type of the cell, which overrides the one of the request
+		RadioType string `json:"radioType"`
+	}
+
+	WifiAccessPoint struct {
+		MacAddress         string  `json:"macAddress"`
+		SignalStrength     float64 `json:"signalStrength"`
+		Age                int64   `json:"age"`
+		Channel            int     `json:"channel"`
+		SignalToNoiseRatio float64 `json:"signalToNoiseRatio"`
+	}
+
+	GeolocateResult struct {
+		Location GeolocateLocation `json:"location"`
+		Accuracy float64           `json:"accuracy"`
+
+		// MLS only, the fallback the position comes from, e.g. "lacf"
+		Fallback string `json:"fallback,omitempty"`
+	}
+
+	GeolocateLocation struct {
+		Lat float64 `json:"lat"`
+		Lng float64 `json:"lng"`
+	}
+
+	// GeolocateError is the error body of Google APIs
+	GeolocateError struct {
+		Error GeolocateErrorDetail `json:"error"`
+	}
+
+	GeolocateErrorDetail struct {
+		Errors  []GeolocateErrorItem `json:"errors"`
+		Code    int                  `json:"code"`
+		Message string               `json:"message"`
+	}
+
+	GeolocateErrorItem struct {
+		Domain  string `json:"domain"`
+		Reason  string `json:"reason"`
+		Message string `json:"message"`
+	}
+
+	// wrapper of positionService, speaking Google Geolocation API and MLS
+	geolocateResource struct {
+		service positionService
+	}
+)
+
+// FallbackLacf is the fallback of locating by areas
+const FallbackLacf = "lacf"
+
+// radio types of Google Geolocation API and MLS, CDMA is not supported
+var geolocateRadios = map[string]string{
+	"gsm":   RadioGSM,
+	"wcdma": RadioUMTS,
+	"umts":  RadioUMTS,
+	"lte":   RadioLTE,
+	"nr":    RadioNR,
+}
+
+// dBm of ASU 0, from which dBm goes up by 1 (2 for GSM) per ASU, as 3GPP TS 27.007 defines
+var asuBaseOfRadio = map[string]float64{
+	RadioGSM:  -113,
+	RadioUMTS: -116,
+	RadioLTE:  -140,
+	RadioNR:   -156,
+}
+
+// SetupGeolocateRouter sets up routes compatible with Google Geolocation API and MLS, i.e., '/geolocate' under '/v1'
+func SetupGeolocateRouter(group *routing.RouteGroup, db *dbx.DB, service positionService) {
+	r := &geolocateResource{service}
+
+	group.Use(
+		content.TypeNegotiator(content.JSON),
+		app.DbAware(db),
+	)
+
+	group.Post("/geolocate", r.geolocate)
+}
+
+// toPositionRequest maps the request onto PositionRequest. Cells of unsupported radio types are left out.
+func (request *GeolocateRequest) toPositionRequest(defaultMcc string) *PositionRequest {
+	positionRequest := &PositionRequest{}
+	for _, tower := range request.CellTowers {
+		if signal, ok := tower.toSignal(request.RadioType, request.HomeMobileCountryCode, request.HomeMobileNetworkCode); ok {
+			positionRequest.append(signal)
+		}
+	}
+	positionRequest.applyDefaults(defaultMcc)
+
+	return positionRequest
+}
+
+// lacfEnabled tells whether it's fine to fall back on areas
+func (request *GeolocateRequest) lacfEnabled() bool {
+	return request.Fallbacks == nil || request.Fallbacks.Lacf == nil || *request.Fallbacks.Lacf
+}
+
+// toSignal maps the tower onto Signal, with radio type, MCC and MNC of the request taken if the tower comes
+// without them (MCC 0 is taken as missing, while MNC 0 is not). The home MNC is not taken for towers of other
+// countries. It returns false if the radio type is not supported.
+func (tower *CellTower) toSignal(radioType string, homeMcc, homeMnc *int) (Signal, bool) {
+	if tower.RadioType != "" {
+		radioType = tower.RadioType
+	}
+	if radioType == "" {
+		radioType = "gsm"
+	}
+	radio, ok := geolocateRadios[strings.ToLower(radioType)]
+	if !ok {
+		return Signal{}, false
+	}
+
+	mcc, mnc := 0, 0
+	if tower.MobileCountryCode != nil {
+		mcc = *tower.MobileCountryCode
+	}
+	if mcc == 0 && homeMcc != nil {
+		mcc = *homeMcc
+	}
+	if tower.MobileNetworkCode != nil {
+		mnc = *tower.MobileNetworkCode
+	} else if homeMnc != nil && (homeMcc == nil || mcc == *homeMcc) {
+		mnc = *homeMnc
+	}
+	cid := tower.CellId
+	if radio == RadioNR && tower.NewRadioCellId != 0 {
+		cid = tower.NewRadioCellId
+	}
+	var strength float64
+	hasStrength := tower.SignalStrength != nil
+	if hasStrength {
+		strength = *tower.SignalStrength
+	} else if tower.Asu > 0 && tower.Asu < 99 { // 99 stands for unknown
+		if radio == RadioGSM {
+			strength = asuBaseOfRadio[radio] + float64(2*tower.Asu)
+		} else {
+			strength = asuBaseOfRadio[radio] + float64(tower.Asu)
+		}
+		hasStrength = true
+	}
+
+	signal := Signal{
+		Radio:       radio,
+		Mnc:         strconv.Itoa(mnc),
+		Lac:         strconv.FormatInt(tower.LocationAreaCode, 10),
+		Cid:         strconv.FormatInt(cid, 10),
+		Strength:    strength,
+		HasStrength: hasStrength,
+	}
+	if mcc != 0 {
+		signal.Mcc = strconv.Itoa(mcc)
+	}
+
+	return signal, true
+}
+
+func (r *geolocateResource) geolocate(ctx *routing.Context) error {
+	request := &GeolocateRequest{}
+	if err := ctx.Read(request); err != nil {
+		return writeGeolocateError(ctx, errors.SimpleInvalidData("Parse Error"))
+	}
+
+	positionRequest := request.toPositionRequest(app.Config.DefaultMcc)
+	if len(positionRequest.Signals) == 0 {
+		return writeGeolocateError(ctx, errors.NotFound("Not Found"))
+	}
+	if err := positionRequest.Validate(); err != nil {
+		return writeGeolocateError(ctx, errors.SimpleInvalidData(err.Error()))
+	}
+
+	fallback := ""
+	position, err := r.service.ComputePosition(app.GetRequestScope(ctx), positionRequest)
+	if isNotFound(err) && request.lacfEnabled() {
+		// unknown stations are recorded by now, and the error of stations not found is kept if areas are not found either
+		if areaPosition, areaErr := r.service.LocateArea(app.GetRequestScope(ctx), positionRequest); areaErr == nil {
+			position, err, fallback = areaPosition, nil, FallbackLacf
+		} else if !isNotFound(areaErr) {
+			err = areaErr
+		}
+	}
+	if err != nil {
+		return writeGeolocateError(ctx, err)
+	}
+
+	return ctx.Write(&GeolocateResult{
+		Location: GeolocateLocation{Lat: position.Lat, Lng: position.Lng},
+		Accuracy: position.Accuracy,
+		Fallback: fallback,
+	})
+}
+
+func isNotFound(err error) bool {
+	apiError, ok := err.(*errors.APIError)
+	return ok && apiError.Status == http.StatusNotFound
+}
+
+// writeGeolocateError writes err in the shape of Google API errors, rather than the one of APIError
+func writeGeolocateError(ctx *routing.Context, err error) error {
+	status, domain, reason, message := http.StatusInternalServerError, "global", "backendError", "Backend Error"
+	if apiError, ok := err.(*errors.APIError); ok {
+		switch apiError.Status {
+		case http.StatusBadRequest:
+			status, reason, message = apiError.Status, "parseError", apiError.Message
+		case http.StatusNotFound:
+			status, domain, reason, message = apiError.Status, "geolocation", "notFound", "Not Found"
+		case http.StatusUnauthorized, http.StatusForbidden:
+			status, domain, reason, message = http.StatusBadRequest, "usageLimits", "keyInvalid", "Bad Request"
+		}
+	}
+
+	ctx.Response.WriteHeader(status)
+	return ctx.Write(&GeolocateError{GeolocateErrorDetail{
+		Errors:  []GeolocateErrorItem{{Domain: domain, Reason: reason, Message: message}},
+		Code:    status,
+		Message: message,
+	}})
+}
@@ -0,0 +1,90 @@
+package apis
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestGeolocateRequest_toPositionRequest(t *testing.T) {
+	var request GeolocateRequest
+	if err := json.Unmarshal([]byte(`{"homeMobileCountryCode":460,"homeMobileNetworkCode":0,"radioType":"gsm",`+
+		`"considerIp":false,"cellTowers":[`+
+		`{"cellId":60122,"locationAreaCode":32838,"signalStrength":-78},`+
+		`{"cellId":1,"locationAreaCode":2,"mobileCountryCode":310,"mobileNetworkCode":410,"radioType":"WCDMA"},`+
+		`{"newRadioCellId":68719476735,"locationAreaCode":1,"mobileCountryCode":460,"mobileNetworkCode":1,"radioType":"nr"},`+
+		`{"cellId":3,"locationAreaCode":4,"radioType":"cdma"}],`+
+		`"wifiAccessPoints":[{"macAddress":"01:23:45:67:89:ab","signalStrength":-65}]}`), &request); err != nil {
+		t.Fatal(err)
+	}
+
+	signals := request.toPositionRequest("460").Signals
+	if len(signals) != 3 {
+		t.Fatalf("3 signals expected, cdma left out, got %v", signals)
+	}
+	expected := []Signal{
+		{Radio: RadioGSM, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60122", Strength: -78, HasStrength: true},
+		{Radio: RadioUMTS, Mcc: "310", Mnc: "410", Lac: "2", Cid: "1"},
+		{Radio: RadioNR, Mcc: "460", Mnc: "1", Lac: "1", Cid: "68719476735"},
+	}
+	for i := range expected {
+		if signals[i] != expected[i] {
+			t.Errorf("signal %d: expected %v, got %v", i, expected[i], signals[i])
+		}
+	}
+}
+
+func TestGeolocateRequest_toPositionRequestWithDefaultMcc(t *testing.T) {
+	request := GeolocateRequest{CellTowers: []CellTower{{CellId: 60122, LocationAreaCode: 32838}}}
+
+	signals := request.toPositionRequest("460").Signals
+	if len(signals) != 1 || signals[0].Radio != RadioGSM || signals[0].Mcc != "460" || signals[0].Mnc != "0" {
+		t.Errorf("unexpected signals with defaults applied: %v", signals)
+	}
+}
+
+func TestGeolocateRequest_toPositionRequestWithHomeNetwork(t *testing.T) {
+	var request GeolocateRequest
+	if err := json.Unmarshal([]byte(`{"homeMobileCountryCode":460,"homeMobileNetworkCode":1,"cellTowers":[`+
+		`{"cellId":1,"locationAreaCode":2},`+
+		`{"cellId":3,"locationAreaCode":4,"mobileCountryCode":460,"mobileNetworkCode":0},`+
+		`{"cellId":5,"locationAreaCode":6,"mobileCountryCode":460},`+
+		`{"cellId":7,"locationAreaCode":8,"mobileNetworkCode":11},`+
+		`{"cellId":9,"locationAreaCode":10,"mobileCountryCode":310}]}`), &request); err != nil {
+		t.Fatal(err)
+	}
+
+	// MNC of towers is kept even if it's 0, and the home MNC applies to towers of the home country only
+	signals := request.toPositionRequest("250").Signals
+	expected := []string{"460-1-2-1", "460-0-4-3", "460-1-6-5", "460-11-8-7", "310-0-10-9"}
+	if len(signals) != len(expected) {
+		t.Fatalf("unexpected signals %v", signals)
+	}
+	for i, signal := range signals {
+		if id := signal.Mcc + "-" + signal.Mnc + "-" + signal.Lac + "-" + signal.Cid; id != expected[i] {
+			t.Errorf("signal %d: expected %s, got %s", i, expected[i], id)
+		}
+	}
+}
+
+func TestGeolocateRequest_MLS(t *testing.T) {
+	var request GeolocateRequest
+	if err := json.Unmarshal([]byte(`{"radioType":"lte","fallbacks":{"lacf":false,"ipf":true},"cellTowers":[`+
+		`{"cellId":1,"locationAreaCode":2,"mobileCountryCode":460,"mobileNetworkCode":0,"asu":40},`+
+		`{"cellId":3,"locationAreaCode":4,"mobileCountryCode":460,"mobileNetworkCode":0,"radioType":"gsm","asu":20}]}`),
+		&request); err != nil {
+		t.Fatal(err)
+	}
+	if request.lacfEnabled() {
+		t.Error("lacf expected to be disabled")
+	}
+
+	signals := request.toPositionRequest("460").Signals
+	if len(signals) != 2 || signals[0].Radio != RadioLTE || signals[0].Strength != -100 ||
+		signals[1].Radio != RadioGSM || signals[1].Strength != -73 {
+		t.Errorf("unexpected signals with strength derived from asu: %v", signals)
+	}
+
+	if !(&GeolocateRequest{}).lacfEnabled() {
+		t.Error("lacf expected to be enabled by default")
+	}
+}
@@ -0,0 +1,151 @@
+package apis
+
+import (
+	"fmt"
+	"github.com/go-ozzo/ozzo-dbx"
+	"github.com/go-ozzo/ozzo-routing"
+	"github.com/go-ozzo/ozzo-routing/content"
+	"github.com/go-ozzo/ozzo-validation"
+	"time"
+	"xungewang.cn/bsp/app"
+	"xungewang.cn/bsp/errors"
+)
+
+// maximum number of cell towers of an item, far more than a device could see at a time
+const maxGeosubmitCellTowers = 100
+
+type (
+	// GeosubmitRequest is the request body of MLS geosubmit (version 2), see
+	// https://ichnaea.readthedocs.io/en/latest/api/geosubmit2.html
+	GeosubmitRequest struct {
+		Items []GeosubmitItem `json:"items"`
+	}
+
+	GeosubmitItem struct {
+		// milliseconds since epoch when the item is observed, defaults to when the request is received
+		Timestamp int64 `json:"timestamp"`
+
+		Position *GeosubmitPosition `json:"position"`
+
+		CellTowers []CellTower `json:"cellTowers"`
+
+		// accepted for compatibility, but not used since there's no data of wifi access points
+		WifiAccessPoints []WifiAccessPoint `json:"wifiAccessPoints"`
+	}
+
+	GeosubmitPosition struct {
+		Latitude  float64 `json:"latitude"`
+		Longitude float64 `json:"longitude"`
+
+		// radius (in meters) of the uncertainty circle of the position
+		Accuracy float64 `json:"accuracy"`
+
+		Altitude         float64 `json:"altitude"`
+		AltitudeAccuracy float64 `json:"altitudeAccuracy"`
+		Heading          float64 `json:"heading"`
+		Speed            float64 `json:"speed"`
+
+		// milliseconds from when the position is taken to the timestamp of the item
+		Age int64 `json:"age"`
+
+		// e.g. "gps", "manual" or "fused"
+		Source string `json:"source"`
+	}
+
+	// wrapper of observationService, speaking MLS
+	geosubmitResource struct {
+		service observationService
+	}
+)
+
+// SetupGeosubmitRouter sets up routes compatible with MLS geosubmit, i.e., '/geosubmit' under '/v2'
+func SetupGeosubmitRouter(group *routing.RouteGroup, db *dbx.DB, service observationService) {
+	r := &geosubmitResource{service}
+
+	group.Use(
+		content.TypeNegotiator(content.JSON),
+		app.DbAware(db),
+	)
+
+	group.Post("/geosubmit", r.geosubmit)
+}
+
+// Validate limits the size of the request, up to app.Config.MaxBatchSize items of maxGeosubmitCellTowers cell
+// towers each. Items themselves are checked one by one, which are dropped if invalid.
+func (request *GeosubmitRequest) Validate() error {
+	if err := validation.ValidateStruct(request,
+		validation.Field(&request.Items, validation.Length(0, app.Config.MaxBatchSize)),
+	); err != nil {
+		return err
+	}
+
+	for i, item := range request.Items {
+		if len(item.CellTowers) > maxGeosubmitCellTowers {
+			return fmt.Errorf("items[%d]: more than %d cell towers", i, maxGeosubmitCellTowers)
+		}
+	}
+	return nil
+}
+
+// toObservationRequest maps the item onto ObservationRequest. It returns false if the item comes without a
+// position, or any cell of supported radio types.
+func (item *GeosubmitItem) toObservationRequest(defaultMcc string) (*ObservationRequest, bool) {
+	if item.Position == nil {
+		return nil, false
+	}
+
+	request := &ObservationRequest{
+		Lat:      item.Position.Latitude,
+		Lng:      item.Position.Longitude,
+		Accuracy: item.Position.Accuracy,
+	}
+	for _, tower := range item.CellTowers {
+		if signal, ok := tower.toSignal("", nil, nil); ok {
+			request.Signals = append(request.Signals, signal)
+		}
+	}
+	if len(request.Signals) == 0 {
+		return nil, false
+	}
+
+	if item.Timestamp > 0 {
+		observedAt := time.Unix(0, (item.Timestamp-item.Position.Age)*int64(time.Millisecond))
+		request.Time = &observedAt
+	}
+	request.applyDefaults(defaultMcc)
+
+	return request, true
+}
+
+// geosubmit accepts items as MLS does, i.e., invalid items (e.g., without a position) are dropped rather than
+// failing the whole request. Valid ones are submitted all together, hence a failed request can be retried as it is.
+// Submitting requires credential unless it's open, see app.CanSubmit.
+func (r *geosubmitResource) geosubmit(ctx *routing.Context) error {
+	if !app.CanSubmit(ctx.Request) {
+		return writeGeolocateError(ctx, errors.Unauthorized("submit credential required"))
+	}
+
+	request := &GeosubmitRequest{}
+	if err := ctx.Read(request); err != nil {
+		return writeGeolocateError(ctx, errors.SimpleInvalidData("Parse Error"))
+	}
+	if err := request.Validate(); err != nil {
+		return writeGeolocateError(ctx, errors.SimpleInvalidData(err.Error()))
+	}
+
+	var observations []*ObservationRequest
+	for i := range request.Items {
+		observation, ok := request.Items[i].toObservationRequest(app.Config.DefaultMcc)
+		if ok && observation.Validate() == nil {
+			observations = append(observations, observation)
+		}
+	}
+
+	if len(observations) > 0 {
+		if _, err := r.service.Submit(app.GetRequestScope(ctx), observations...); err != nil {
+			return writeGeolocateError(ctx, err)
+		}
+	}
+
+	return ctx.Write(struct{}{})
+}
@@ -0,0 +1,58 @@
+package apis
+
+import (
+	"encoding/json"
+	"testing"
+	"time"
+	"xungewang.cn/bsp/app"
+)
+
+func TestGeosubmitItem_toObservationRequest(t *testing.T) {
+	var request GeosubmitRequest
+	if err := json.Unmarshal([]byte(`{"items":[`+
+		`{"timestamp":1483340645000,"position":{"latitude":30.732796,"longitude":103.962357,"accuracy":10,"age":5000},`+
+		`"cellTowers":[{"radioType":"lte","mobileCountryCode":460,"mobileNetworkCode":0,"locationAreaCode":32838,`+
+		`"cellId":60122,"signalStrength":-78}]},`+
+		`{"cellTowers":[{"radioType":"gsm","mobileCountryCode":460,"mobileNetworkCode":0,"locationAreaCode":1,"cellId":2}]},`+
+		`{"position":{"latitude":30.732796,"longitude":103.962357},"wifiAccessPoints":[{"macAddress":"01:23:45:67:89:ab"}]}`+
+		`]}`), &request); err != nil {
+		t.Fatal(err)
+	}
+
+	observation, ok := request.Items[0].toObservationRequest("460")
+	if !ok {
+		t.Fatal("observation expected from an item with both position and cells")
+	}
+	expected := Signal{Radio: RadioLTE, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60122", Strength: -78, HasStrength: true}
+	if observation.Lat != 30.732796 || observation.Lng != 103.962357 || observation.Accuracy != 10 ||
+		len(observation.Signals) != 1 || observation.Signals[0] != expected {
+		t.Errorf("unexpected observation: %+v", observation)
+	}
+	if !observation.Time.Equal(time.Unix(1483340640, 0)) {
+		t.Errorf("time expected to be the timestamp minus the age of the position, got %s", observation.Time)
+	}
+
+	if _, ok := request.Items[1].toObservationRequest("460"); ok {
+		t.Error("item without position expected to be dropped")
+	}
+	if _, ok := request.Items[2].toObservationRequest("460"); ok {
+		t.Error("item without cells expected to be dropped")
+	}
+}
+
+func TestGeosubmitRequest_Validate(t *testing.T) {
+	defer func(size int) { app.Config.MaxBatchSize = size }(app.Config.MaxBatchSize)
+	app.Config.MaxBatchSize = 2
+
+	item := GeosubmitItem{CellTowers: make([]CellTower, maxGeosubmitCellTowers)}
+	if err := (&GeosubmitRequest{Items: []GeosubmitItem{item, item}}).Validate(); err != nil {
+		t.Errorf("request within limits should be valid: %s", err)
+	}
+	if err := (&GeosubmitRequest{Items: []GeosubmitItem{item, item, item}}).Validate(); err == nil {
+		t.Error("request of more than MaxBatchSize items should be invalid")
+	}
+	item.CellTowers = append(item.CellTowers, CellTower{})
+	if err := (&GeosubmitRequest{Items: []GeosubmitItem{item}}).Validate(); err == nil {
+		t.Errorf("item of more than %d cell towers should be invalid", maxGeosubmitCellTowers)
+	}
+}
@@ -0,0 +1,130 @@
+package apis
+
+import (
+	"fmt"
+	"github.com/go-ozzo/ozzo-dbx"
+	"github.com/go-ozzo/ozzo-routing"
+	"github.com/go-ozzo/ozzo-routing/content"
+	"github.com/go-ozzo/ozzo-validation"
+	"time"
+	"xungewang.cn/bsp/app"
+	"xungewang.cn/bsp/errors"
+)
+
+// observations should be made within the window, allowing for clocks of devices running a bit fast
+const (
+	maxObservationAge = 7 * 24 * time.Hour
+	maxClockSkew      = 5 * time.Minute
+)
+
+type (
+	// contract observation related behaviors
+	observationService interface {
+		// Submit stores observations of all requests, or none of them if it fails
+		Submit(ctx app.RequestScope, requests ...*ObservationRequest) (*ObservationResult, error)
+	}
+
+	// ObservationRequest is a GPS fix along with cells visible there
+	ObservationRequest struct {
+		Lat float64 `json:"lat"`
+		Lng float64 `json:"lng"`
+
+		// radius (in meters) of the uncertainty circle of the fix, 0 if unknown
+		Accuracy float64 `json:"accuracy"`
+
+		// when the fix is taken, defaults to when the request is received. It shouldn't be in the future, or
+		// older than maxObservationAge.
+		Time *time.Time `json:"time"`
+
+		Signals []Signal `json:"signals"`
+	}
+
+	ObservationResult struct {
+		Code int `json:"code"`
+
+		// number of observations accepted, one per signal
+		Accepted int `json:"accepted"`
+	}
+
+	// wrapper of observationService
+	observationResource struct {
+		service observationService
+	}
+)
+
+// SetupObservationRouter sets up routes to submit observations, from which stations are learned. Submitting
+// requires credential unless it's open, see app.CanSubmit.
+func SetupObservationRouter(group *routing.RouteGroup, db *dbx.DB, service observationService) {
+	r := &observationResource{service}
+
+	group.Use(
+		content.TypeNegotiator(content.JSON),
+		app.DbAware(db),
+	)
+
+	group.Post("/observations", app.SubmitAuth(), r.submit)
+}
+
+func (request *ObservationRequest) Validate() error {
+	if err := validation.ValidateStruct(request,
+		validation.Field(&request.Lat, validation.Min(-90.0), validation.Max(90.0), validation.By(func(interface{}) error {
+			if request.Lat == 0 && request.Lng == 0 {
+				return fmt.Errorf("(0, 0) is not a valid fix")
+			}
+			return nil
+		})),
+		validation.Field(&request.Lng, validation.Min(-180.0), validation.Max(180.0)),
+		validation.Field(&request.Accuracy, validation.Min(0.0)),
+		validation.Field(&request.Time, validation.By(func(interface{}) error {
+			if request.Time == nil {
+				return nil
+			}
+			if age := time.Since(*request.Time); age < -maxClockSkew || age > maxObservationAge {
+				return fmt.Errorf("must be within %s before now", maxObservationAge)
+			}
+			return nil
+		})),
+		validation.Field(&request.Signals, validation.Required),
+	); err != nil {
+		return err
+	}
+
+	// validate each signal in the request
+	for _, signal := range request.Signals {
+		if err := signal.Validate(); err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// fill in MCC and radio type of signals that come without them, as well as time of the fix
+func (request *ObservationRequest) applyDefaults(mcc string) {
+	applySignalDefaults(request.Signals, mcc)
+
+	if request.Time == nil {
+		now := time.Now()
+		request.Time = &now
+	}
+}
+
+func (r *observationResource) submit(ctx *routing.Context) error {
+	request := &ObservationRequest{}
+	if err := ctx.Read(request); err != nil {
+		return errors.SimpleInvalidData("request not acceptable. pay special attention on fields type and value. " +
+			"For example, lat, lng and accuracy should be of double type, and time RFC 3339 string.")
+	}
+	request.applyDefaults(app.Config.DefaultMcc)
+
+	if err := request.Validate(); err != nil {
+		return err
+	}
+
+	result, err := r.service.Submit(app.GetRequestScope(ctx), request)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Write(result)
+}
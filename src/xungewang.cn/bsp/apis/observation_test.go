@@ -0,0 +1,79 @@
+package apis
+
+import (
+	"github.com/go-ozzo/ozzo-routing"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+	"xungewang.cn/bsp/app"
+)
+
+func TestObservationRequest_Validate(t *testing.T) {
+	signals := []Signal{{Radio: RadioGSM, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60122", Strength: -78}}
+	now := time.Now()
+	lastWeek, tooOld, skewed, future := now.AddDate(0, 0, -6), now.AddDate(0, 0, -8), now.Add(time.Minute),
+		now.Add(time.Hour)
+
+	valid := []ObservationRequest{
+		{Lat: 30.732796, Lng: 103.962357, Accuracy: 10, Signals: signals},
+		{Lat: -33.856784, Lng: -180, Signals: signals}, // accuracy is optional
+		{Lat: 30.732796, Lng: 103.962357, Time: &lastWeek, Signals: signals},
+		{Lat: 30.732796, Lng: 103.962357, Time: &skewed, Signals: signals}, // clock running a bit fast
+	}
+	for _, request := range valid {
+		if err := request.Validate(); err != nil {
+			t.Errorf("%+v should be valid: %s", request, err)
+		}
+	}
+
+	invalid := []ObservationRequest{
+		{Lat: 0, Lng: 0, Signals: signals},                                    // null island
+		{Lat: 90.1, Lng: 103.962357, Signals: signals},                        // bad lat
+		{Lat: 30.732796, Lng: 103.962357, Accuracy: -1, Signals: signals},     // bad accuracy
+		{Lat: 30.732796, Lng: 103.962357},                                     // no signals
+		{Lat: 30.732796, Lng: 103.962357, Signals: []Signal{{Radio: "cdma"}}}, // bad signal
+		{Lat: 30.732796, Lng: 103.962357, Time: &tooOld, Signals: signals},    // too old
+		{Lat: 30.732796, Lng: 103.962357, Time: &future, Signals: signals},    // in the future
+	}
+	for _, request := range invalid {
+		if err := request.Validate(); err == nil {
+			t.Errorf("%+v should be invalid", request)
+		}
+	}
+}
+
+func TestSubmitAuth(t *testing.T) {
+	defer func(config app.LearningConfig, token string) {
+		app.Config.Learning, app.Config.AdminToken = config, token
+	}(app.Config.Learning, app.Config.AdminToken)
+	app.Config.AdminToken = "admin"
+
+	submit := func(header, token string) error {
+		request := httptest.NewRequest(http.MethodPost, "/api/observations", nil)
+		if header != "" {
+			request.Header.Set(header, token)
+		}
+		return app.SubmitAuth()(routing.NewContext(httptest.NewRecorder(), request))
+	}
+	tests := []struct {
+		tag            string
+		submitToken    string
+		openSubmission bool
+		header, token  string
+		allowed        bool
+	}{
+		{"admin only", "", false, app.AdminTokenHeader, "admin", true},
+		{"admin only without credential", "", false, "", "", false},
+		{"admin only with empty submit token", "", false, app.SubmitTokenHeader, "", false},
+		{"submit token", "secret", false, app.SubmitTokenHeader, "secret", true},
+		{"wrong submit token", "secret", false, app.SubmitTokenHeader, "guess", false},
+		{"open", "", true, "", "", true},
+	}
+	for _, test := range tests {
+		app.Config.Learning.SubmitToken, app.Config.Learning.OpenSubmission = test.submitToken, test.openSubmission
+		if err := submit(test.header, test.token); (err == nil) != test.allowed {
+			t.Errorf("%s: expecting allowed %v, got error %v", test.tag, test.allowed, err)
+		}
+	}
+}
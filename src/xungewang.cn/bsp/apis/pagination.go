@@ -0,0 +1,76 @@
+package apis
+
+import (
+	"github.com/go-ozzo/ozzo-routing"
+	"strconv"
+)
+
+const (
+	// default and maximum number of items per page
+	defaultPerPage = 100
+	maxPerPage     = 1000
+)
+
+// PaginatedList represents a page of items
+type PaginatedList struct {
+	Page       int         `json:"page"`
+	PerPage    int         `json:"per_page"`
+	PageCount  int         `json:"page_count"`
+	TotalCount int         `json:"total_count"`
+	Items      interface{} `json:"items"`
+}
+
+// Offset returns the OFFSET value that can be used in a SQL statement.
+func (p *PaginatedList) Offset() int {
+	return (p.Page - 1) * p.PerPage
+}
+
+// Limit returns the LIMIT value that can be used in a SQL statement.
+func (p *PaginatedList) Limit() int {
+	return p.PerPage
+}
+
+// newPaginatedList creates a PaginatedList with page and per_page normalized, and page count computed
+func newPaginatedList(page, perPage, total int) *PaginatedList {
+	if perPage <= 0 {
+		perPage = defaultPerPage
+	}
+	if perPage > maxPerPage {
+		perPage = maxPerPage
+	}
+
+	pageCount := (total + perPage - 1) / perPage
+	if page > pageCount {
+		page = pageCount
+	}
+	if page < 1 {
+		page = 1
+	}
+
+	return &PaginatedList{
+		Page:       page,
+		PerPage:    perPage,
+		TotalCount: total,
+		PageCount:  pageCount,
+	}
+}
+
+// getPaginatedListFromRequest creates a PaginatedList as per 'page' and 'per_page' query parameters
+func getPaginatedListFromRequest(ctx *routing.Context, count int) *PaginatedList {
+	page := parseInt(ctx.Query("page"), 1)
+	perPage := parseInt(ctx.Query("per_page"), defaultPerPage)
+
+	return newPaginatedList(page, perPage, count)
+}
+
+func parseInt(value string, defaultValue int) int {
+	if value == "" {
+		return defaultValue
+	}
+
+	if result, err := strconv.Atoi(value); err == nil {
+		return result
+	}
+
+	return defaultValue
+}
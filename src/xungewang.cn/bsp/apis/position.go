@@ -1,6 +1,7 @@
 package apis
 
 import (
+	"bytes"
 	"encoding/json"
 	"fmt"
 	"github.com/go-ozzo/ozzo-dbx"
@@ -9,19 +10,90 @@ import (
 	"github.com/go-ozzo/ozzo-validation"
 	"strings"
 	"xungewang.cn/bsp/app"
+	"xungewang.cn/bsp/coord"
 	"xungewang.cn/bsp/errors"
+	"xungewang.cn/bsp/geocode"
 )
 
 type (
 	// contract position related behaviors
 	positionService interface {
 		ComputePosition(ctx app.RequestScope, request *PositionRequest) (*PositionResult, error)
+		ComputePositions(ctx app.RequestScope, request *BatchPositionRequest) ([]*BatchPositionItemResult, error)
+		LocateArea(ctx app.RequestScope, request *PositionRequest) (*PositionResult, error)
 	}
 
 	PositionResult struct {
-		Code int    `json:"code"`
+		Code int     `json:"code"`
+		Lat  float64 `json:"lat"`
+		Lng  float64 `json:"lng"`
+
+		// radius (in meters) of the circle around (lat, lng) the actual position is expected to fall in
+		Accuracy float64 `json:"accuracy"`
+
+		// stations found but rejected for being too far away from others
+		Rejected []*RejectedStation `json:"rejected,omitempty"`
+
+		// how the position is computed, only available in explain mode
+		Explanation *Explanation `json:"explanation,omitempty"`
+
+		// where the position is administratively, only available if geocoding is configured
+		Address *geocode.Address `json:"address,omitempty"`
+	}
+
+	RejectedStation struct {
+		Id  string  `json:"id"`
 		Lat float64 `json:"lat"`
 		Lng float64 `json:"lng"`
+
+		// distance (in meters) from the center of stations by the time it got rejected
+		Distance float64 `json:"distance"`
+
+		// the round (1 based) of outlier elimination in which it got rejected
+		Round int `json:"round"`
+	}
+
+	// Explanation tells what happens inside position computation
+	Explanation struct {
+		// name of the positioning algorithm used
+		Algorithm string `json:"algorithm"`
+
+		// every signal requested, in the order of request
+		Signals []*SignalExplanation `json:"signals"`
+
+		// rounds of outlier elimination, see RejectedStation
+		Rounds []*EliminationRound `json:"rounds"`
+	}
+
+	SignalExplanation struct {
+		Signal
+
+		// id of the station that sends the signal
+		StationId string `json:"station_id"`
+
+		// whether the station is found in base stations
+		Matched bool `json:"matched"`
+
+		// weight of the station in positioning, which sums up to 1 among stations used. 0 if the station
+		// is not used, or the positioning algorithm doesn't weigh stations.
+		Weight float64 `json:"weight"`
+
+		// the round of outlier elimination in which the station got rejected, 0 if not rejected
+		EliminatedInRound int `json:"eliminated_in_round"`
+	}
+
+	EliminationRound struct {
+		Round int `json:"round"`
+
+		// centroid of stations remaining in this round
+		Lat float64 `json:"lat"`
+		Lng float64 `json:"lng"`
+
+		// standard deviation (in meters) of distances from stations to the centroid
+		Spread float64 `json:"spread"`
+
+		// id of station rejected in this round, empty if stations are close enough and no one rejected
+		Eliminated string `json:"eliminated,omitempty"`
 	}
 
 	// wrapper of positionService
@@ -39,17 +111,48 @@ func SetupPositionRouter(group *routing.RouteGroup, db *dbx.DB, service position
 	)
 
 	group.Post("/position", r.computePosition)
+	group.Post("/position/batch", r.computePositions)
 }
 
-type PositionRequest []Signal
+type PositionRequest struct {
+	Signals []Signal `json:"signals"`
+
+	// name of the positioning algorithm, e.g., "weighted-centroid" and "least-squares".
+	// The server-wide default algorithm is used if not given
+	Algorithm string `json:"algorithm"`
+
+	// Deprecated: what Algorithm used to be named, which is taken as Algorithm if Algorithm is not given
+	Mode string `json:"mode"`
+
+	// whether to explain how the position is computed. It's allowed only if app.Config.ExplainEnabled
+	// is on, or the request carries admin credential.
+	Explain bool `json:"explain"`
+
+	// coordinate type of the result, one of "wgs84" (default), "gcj02" (AMap) and "bd09" (Baidu Maps)
+	CoordType string `json:"coord_type"`
+}
+
+// UnmarshalJSON accepts both an object carrying signals and options, and a bare array of signals,
+// which is how requests used to be posted.
+func (request *PositionRequest) UnmarshalJSON(data []byte) error {
+	if trimmed := bytes.TrimSpace(data); len(trimmed) > 0 && trimmed[0] == '[' {
+		return json.Unmarshal(data, &request.Signals)
+	}
+
+	type plainRequest PositionRequest // without UnmarshalJSON, to avoid infinite recursion
+	return json.Unmarshal(data, (*plainRequest)(request))
+}
 
 func (request *PositionRequest) Validate() error {
-	if err := validation.Validate(*request, validation.NilOrNotEmpty); err != nil {
+	if err := validation.ValidateStruct(request,
+		validation.Field(&request.Signals, validation.NilOrNotEmpty),
+		validation.Field(&request.CoordType, validation.In(coord.Types...)),
+	); err != nil {
 		return err
 	}
 
 	// validate each signal in the request
-	for _, signal := range *request {
+	for _, signal := range request.Signals {
 		if err := signal.Validate(); err != nil {
 			return err
 		}
@@ -60,38 +163,15 @@ func (request *PositionRequest) Validate() error {
 
 // append given signal to current request
 func (request *PositionRequest) append(signal Signal) {
-	*request = append(*request, signal)
-}
-
-type Signal struct {
-	// Mobile Network Code
-	Mnc string `json:"mnc"`
-
-	// Location Area Code is a unique number of current location area.
-	Lac string `json:"lac"`
-
-	// a generally unique number used to identify each Base transceiver station (BTS)
-	// or sector of a BTS within a Location area code
-	Cid string `json:"cid"`
-
-	// signal strength refers to the transmitter power output as received by a reference antenna
-	// at a distance from the transmitting antenna.
-	Strength float64 `json:"str"`
+	request.Signals = append(request.Signals, signal)
 }
 
-func (signal *Signal) Validate() error {
-	return validation.ValidateStruct(signal,
-		validation.Field(&signal.Mnc, validation.Required),
-		validation.Field(&signal.Lac, validation.Required),
-		validation.Field(&signal.Cid, validation.Required),
-		// single strength (or in short, RSSI - Received Signal Strength Indication), in theory, should
-		// range from -113 to -51. But in practise, power like -120 can be received. So we enlarge the
-		// range from -150 to 0 to tolerate. The RSSI is measured in dBm, which should be negative.
-		validation.Field(&signal.Strength, validation.Min(-150.0), validation.Max(0.0)))
-}
-
-func (signal *Signal) String() string {
-	return fmt.Sprintf("(lac:%s, cid:%s, str:%f)", signal.Lac, signal.Cid, signal.Strength)
+// fill in MCC and radio type of signals that come without them, as well as the algorithm from its legacy name
+func (request *PositionRequest) applyDefaults(mcc string) {
+	applySignalDefaults(request.Signals, mcc)
+	if request.Algorithm == "" {
+		request.Algorithm = request.Mode
+	}
 }
 
 // request extractor to extract request data from context
@@ -113,7 +193,12 @@ func (r *positionResource) computePosition(ctx *routing.Context) error {
 	// extract request data
 	if err := extract(ctx, request); err != nil {
 		return errors.SimpleInvalidData("request not acceptable. pay special attention on fields type and value. For " +
-			"example, mnc, lac and cid should be of string type, and str double.")
+			"example, mcc, mnc, lac and cid should be of string type, and str double.")
+	}
+	request.applyDefaults(app.Config.DefaultMcc)
+
+	if request.Explain && !app.Config.ExplainEnabled && !app.IsAdmin(ctx.Request) {
+		return errors.Forbidden("explain mode is disabled")
 	}
 
 	if err := request.Validate(); err != nil {
@@ -123,12 +208,31 @@ func (r *positionResource) computePosition(ctx *routing.Context) error {
 	if pos, err := r.service.ComputePosition(app.GetRequestScope(ctx), request); err != nil {
 		return err
 	} else {
+		pos.convertTo(request.CoordType)
 		return ctx.Write(pos)
 	}
 }
 
 func (r *positionResource) extractRequestFromJSON(ctx *routing.Context, request *PositionRequest) error {
-	return ctx.Read(&request)
+	if err := ctx.Read(&request); err != nil {
+		return err
+	}
+
+	// options can also be given as query parameters, which is handy for bare array requests
+	if request.Algorithm == "" {
+		request.Algorithm = ctx.Query("algorithm")
+	}
+	if request.Mode == "" {
+		request.Mode = ctx.Query("mode")
+	}
+	if !request.Explain {
+		request.Explain = ctx.Query("explain") == "true"
+	}
+	if request.CoordType == "" {
+		request.CoordType = ctx.Query("coord_type")
+	}
+
+	return nil
 }
 
 func (r *positionResource) extractRequestFromForm(ctx *routing.Context, request *PositionRequest) error {
@@ -146,6 +250,10 @@ func (r *positionResource) extractRequestFromForm(ctx *routing.Context, request
 
 			request.append(parsed)
 		}
+		request.Algorithm = ctx.Request.Form.Get("algorithm")
+		request.Mode = ctx.Request.Form.Get("mode")
+		request.Explain = ctx.Request.Form.Get("explain") == "true"
+		request.CoordType = ctx.Request.Form.Get("coord_type")
 
 		return nil
 	}
@@ -154,9 +262,30 @@ func (r *positionResource) extractRequestFromForm(ctx *routing.Context, request
 }
 
 func (position *PositionResult) String() string {
-	return fmt.Sprintf("(lat: %f, lng: %f)", position.Lat, position.Lng)
+	return fmt.Sprintf("(lat: %f, lng: %f, accuracy: %f)", position.Lat, position.Lng, position.Accuracy)
+}
+
+// convertTo converts coordinates of the position, as well as those explaining it, from WGS-84 (which stations
+// are stored in) to coordType
+func (position *PositionResult) convertTo(coordType string) {
+	if coordType == "" || coordType == coord.WGS84 {
+		return
+	}
+
+	convert := func(lat, lng *float64) {
+		*lat, *lng, _ = coord.Convert(*lat, *lng, coord.WGS84, coordType)
+	}
+	convert(&position.Lat, &position.Lng)
+	for _, station := range position.Rejected {
+		convert(&station.Lat, &station.Lng)
+	}
+	if position.Explanation != nil {
+		for _, round := range position.Explanation.Rounds {
+			convert(&round.Lat, &round.Lng)
+		}
+	}
 }
 
-func NewPositionResult(lat float64, lng float64) *PositionResult {
-	return &PositionResult{200, lat, lng}
+func NewPositionResult(lat float64, lng float64, accuracy float64) *PositionResult {
+	return &PositionResult{Code: 200, Lat: lat, Lng: lng, Accuracy: accuracy}
 }
@@ -0,0 +1,73 @@
+package apis
+
+import (
+	"encoding/json"
+	"testing"
+	"xungewang.cn/bsp/coord"
+	"xungewang.cn/bsp/errors"
+)
+
+func TestPositionRequest_UnmarshalJSON(t *testing.T) {
+	var legacy PositionRequest
+	if err := json.Unmarshal([]byte(`[{"mnc":"0","lac":"32838","cid":"60122","str":-78}]`), &legacy); err != nil {
+		t.Fatal(err)
+	}
+	if len(legacy.Signals) != 1 || legacy.Signals[0].Cid != "60122" || legacy.Algorithm != "" {
+		t.Errorf("unexpected request from bare array: %+v", legacy)
+	}
+
+	var request PositionRequest
+	if err := json.Unmarshal([]byte(`{"signals":[{"mnc":"0","lac":"32838","cid":"60122","str":-78}],`+
+		`"algorithm":"least-squares"}`), &request); err != nil {
+		t.Fatal(err)
+	}
+	if len(request.Signals) != 1 || request.Signals[0].Cid != "60122" || request.Algorithm != "least-squares" {
+		t.Errorf("unexpected request from object: %+v", request)
+	}
+}
+
+func TestPositionRequest_applyDefaults(t *testing.T) {
+	request := PositionRequest{Signals: []Signal{{Mnc: "0", Lac: "32838", Cid: "60122"}}, Mode: "least-squares"}
+	request.applyDefaults("460")
+	if request.Algorithm != "least-squares" || request.Signals[0].Mcc != "460" || request.Signals[0].Radio != RadioGSM {
+		t.Errorf("legacy mode should be taken as algorithm: %+v", request)
+	}
+
+	request = PositionRequest{Algorithm: "centroid", Mode: "least-squares"}
+	request.applyDefaults("460")
+	if request.Algorithm != "centroid" {
+		t.Errorf("algorithm should win over legacy mode, got %s", request.Algorithm)
+	}
+}
+
+func TestBatchPositionItemResult_MarshalJSON(t *testing.T) {
+	positioned, _ := json.Marshal(NewBatchPositionItemResult("a", NewPositionResult(30.732796, 103.962357, 1000)))
+	if string(positioned) != `{"device_id":"a","code":200,"lat":30.732796,"lng":103.962357,"accuracy":1000}` {
+		t.Errorf("unexpected result of a device positioned: %s", positioned)
+	}
+
+	failed, _ := json.Marshal(NewBatchPositionItemError("b", errors.NotFound("no stations matched")))
+	if string(failed) != `{"device_id":"b","code":404,"error":{"error_code":"NOT_FOUND","message":"no stations matched"}}` {
+		t.Errorf("unexpected result of a device failed: %s", failed)
+	}
+}
+
+func TestPositionResult_convertTo(t *testing.T) {
+	position := NewPositionResult(30.732796, 103.962357, 1000)
+	position.Rejected = []*RejectedStation{{Id: "460-0-32838-60124", Lat: 30.732796, Lng: 103.962357}}
+
+	position.convertTo(coord.GCJ02)
+	lat, lng := coord.WGS84ToGCJ02(30.732796, 103.962357)
+	if position.Lat != lat || position.Lng != lng || position.Accuracy != 1000 {
+		t.Errorf("unexpected position converted to GCJ-02: %s", position)
+	}
+	if rejected := position.Rejected[0]; rejected.Lat != lat || rejected.Lng != lng {
+		t.Errorf("unexpected rejected station converted to GCJ-02: %v", rejected)
+	}
+
+	position = NewPositionResult(30.732796, 103.962357, 1000)
+	position.convertTo(coord.WGS84)
+	if position.Lat != 30.732796 || position.Lng != 103.962357 {
+		t.Errorf("position expected to be kept in WGS-84: %s", position)
+	}
+}
@@ -0,0 +1,143 @@
+package apis
+
+import (
+	"encoding/json"
+	"fmt"
+	"github.com/go-ozzo/ozzo-validation"
+	"regexp"
+	"strconv"
+)
+
+// radio types of signals
+const (
+	RadioGSM  = "gsm"
+	RadioUMTS = "umts"
+	RadioLTE  = "lte"
+	RadioNR   = "nr" // 5G New Radio
+)
+
+// the upper bounds of identifiers, which differ from radio to radio
+type radioLimits struct {
+	lac   uint64 // LAC for GSM and UMTS, TAC for LTE and NR
+	cid   uint64 // CID for GSM, 28-bit UTRAN CI for UMTS, 28-bit ECI for LTE and 36-bit NCI for NR
+	pci   int    // BSIC for GSM, PSC for UMTS, PCI for LTE and NR
+	arfcn int    // ARFCN for GSM, UARFCN for UMTS, EARFCN for LTE and NR-ARFCN for NR
+}
+
+var (
+	// MCC is always made up of 3 digits
+	mccPattern = regexp.MustCompile(`^\d{3}$`)
+
+	limitsOfRadio = map[string]radioLimits{
+		RadioGSM:  {lac: 1<<16 - 1, cid: 1<<16 - 1, pci: 63, arfcn: 1023},
+		RadioUMTS: {lac: 1<<16 - 1, cid: 1<<28 - 1, pci: 511, arfcn: 16383},
+		RadioLTE:  {lac: 1<<16 - 1, cid: 1<<28 - 1, pci: 503, arfcn: 262143},
+		RadioNR:   {lac: 1<<24 - 1, cid: 1<<36 - 1, pci: 1007, arfcn: 3279165},
+	}
+)
+
+type Signal struct {
+	// radio type of the cell, one of "gsm" (default), "umts", "lte" and "nr". Identifiers of
+	// cells (lac and cid) of different radio types never match each other.
+	Radio string `json:"radio"`
+
+	// Mobile Country Code. Defaults to app.Config.DefaultMcc if not given
+	Mcc string `json:"mcc"`
+
+	// Mobile Network Code
+	Mnc string `json:"mnc"`
+
+	// Location Area Code is a unique number of current location area. For LTE and NR, it's
+	// the Tracking Area Code (TAC).
+	Lac string `json:"lac"`
+
+	// a generally unique number used to identify each Base transceiver station (BTS)
+	// or sector of a BTS within a Location area code. For LTE, it's the 28-bit E-UTRAN
+	// Cell Identifier (ECI), and for NR the 36-bit NR Cell Identity (NCI).
+	Cid string `json:"cid"`
+
+	// physical cell id (optional), i.e., PCI for LTE and NR, PSC for UMTS and BSIC for GSM
+	Pci int `json:"pci,omitempty"`
+
+	// absolute radio-frequency channel number (optional), i.e., EARFCN for LTE, NR-ARFCN for NR,
+	// UARFCN for UMTS and ARFCN for GSM
+	Arfcn int `json:"arfcn,omitempty"`
+
+	// signal strength refers to the transmitter power output as received by a reference antenna
+	// at a distance from the transmitting antenna.
+	Strength float64 `json:"str"`
+
+	// whether Strength is given, which tells a strength of 0 dBm from a missing one. It's set when decoded from JSON.
+	HasStrength bool `json:"-"`
+}
+
+// UnmarshalJSON decodes the signal, telling whether its strength is given
+func (signal *Signal) UnmarshalJSON(data []byte) error {
+	type plainSignal Signal // without UnmarshalJSON, to avoid infinite recursion
+	decoded := struct {
+		*plainSignal
+		Strength *float64 `json:"str"`
+	}{plainSignal: (*plainSignal)(signal)}
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		return err
+	}
+
+	if decoded.Strength != nil {
+		signal.Strength, signal.HasStrength = *decoded.Strength, true
+	}
+	return nil
+}
+
+func (signal *Signal) Validate() error {
+	if err := validation.ValidateStruct(signal,
+		validation.Field(&signal.Radio, validation.Required,
+			validation.In(RadioGSM, RadioUMTS, RadioLTE, RadioNR))); err != nil {
+		return err
+	}
+
+	limits := limitsOfRadio[signal.Radio]
+	return validation.ValidateStruct(signal,
+		validation.Field(&signal.Mcc, validation.Required, validation.Match(mccPattern)),
+		validation.Field(&signal.Mnc, validation.Required),
+		validation.Field(&signal.Lac, validation.Required, numberUpTo(limits.lac)),
+		validation.Field(&signal.Cid, validation.Required, numberUpTo(limits.cid)),
+		validation.Field(&signal.Pci, validation.Min(0), validation.Max(limits.pci)),
+		validation.Field(&signal.Arfcn, validation.Min(0), validation.Max(limits.arfcn)),
+		// single strength (or in short, RSSI - Received Signal Strength Indication), in theory, should
+		// range from -113 to -51. But in practise, power like -120 can be received. So we enlarge the
+		// range from -150 to 0 to tolerate. The RSSI is measured in dBm, which should be negative.
+		validation.Field(&signal.Strength, validation.Min(-150.0), validation.Max(0.0)))
+}
+
+func (signal Signal) String() string {
+	return fmt.Sprintf("(radio:%s, mcc:%s, mnc:%s, lac:%s, cid:%s, str:%f)", signal.Radio, signal.Mcc, signal.Mnc,
+		signal.Lac, signal.Cid, signal.Strength)
+}
+
+// numberUpTo checks that a string value is a decimal number no greater than max
+func numberUpTo(max uint64) validation.Rule {
+	return validation.By(func(value interface{}) error {
+		s, _ := value.(string)
+		if s == "" { // leave it to the Required rule
+			return nil
+		}
+
+		if n, err := strconv.ParseUint(s, 10, 64); err != nil || n > max {
+			return fmt.Errorf("must be a number no greater than %d", max)
+		}
+		return nil
+	})
+}
+
+// fill in MCC and radio type of signals that come without them (typically from clients that predate
+// MCC or radio type support)
+func applySignalDefaults(signals []Signal, mcc string) {
+	for i := range signals {
+		if signals[i].Mcc == "" {
+			signals[i].Mcc = mcc
+		}
+		if signals[i].Radio == "" {
+			signals[i].Radio = RadioGSM
+		}
+	}
+}
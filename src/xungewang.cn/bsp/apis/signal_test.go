@@ -0,0 +1,57 @@
+package apis
+
+import (
+	"encoding/json"
+	"testing"
+)
+
+func TestSignal_Validate(t *testing.T) {
+	valid := []Signal{
+		{Radio: RadioGSM, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60122", Strength: -78},
+		{Radio: RadioUMTS, Mcc: "460", Mnc: "1", Lac: "41003", Cid: "268435455", Pci: 511, Strength: -90},
+		{Radio: RadioLTE, Mcc: "460", Mnc: "0", Lac: "6334", Cid: "127502337", Pci: 503, Arfcn: 38950, Strength: -101},
+		{Radio: RadioNR, Mcc: "460", Mnc: "0", Lac: "16777215", Cid: "68719476735", Pci: 1007, Arfcn: 504990,
+			Strength: -95},
+	}
+	for _, signal := range valid {
+		if err := signal.Validate(); err != nil {
+			t.Errorf("signal %s should be valid: %s", signal, err)
+		}
+	}
+
+	invalid := []Signal{
+		{Radio: "cdma", Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60122", Strength: -78},         // unknown radio
+		{Radio: RadioGSM, Mcc: "46", Mnc: "0", Lac: "32838", Cid: "60122", Strength: -78},        // bad mcc
+		{Radio: RadioGSM, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "127502337", Strength: -78},   // cid beyond 16 bits
+		{Radio: RadioGSM, Mcc: "460", Mnc: "0", Lac: "abc", Cid: "60122", Strength: -78},         // not a number
+		{Radio: RadioLTE, Mcc: "460", Mnc: "0", Lac: "6334", Cid: "268435456", Strength: -101},   // eci beyond 28 bits
+		{Radio: RadioLTE, Mcc: "460", Mnc: "0", Lac: "6334", Cid: "1", Pci: 504, Strength: -101}, // pci out of range
+		{Radio: RadioNR, Mcc: "460", Mnc: "0", Lac: "16777216", Cid: "1", Strength: -95},         // tac beyond 24 bits
+		{Radio: RadioNR, Mcc: "460", Mnc: "0", Lac: "1", Cid: "68719476736", Strength: -95},      // nci beyond 36 bits
+	}
+	for _, signal := range invalid {
+		if err := signal.Validate(); err == nil {
+			t.Errorf("signal %s should be invalid", signal)
+		}
+	}
+}
+
+func TestSignal_UnmarshalJSON(t *testing.T) {
+	tests := []struct {
+		json     string
+		expected Signal
+	}{
+		{`{"mcc":"460","mnc":"0","lac":"32838","cid":"60122","str":-78}`,
+			Signal{Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60122", Strength: -78, HasStrength: true}},
+		{`{"radio":"lte","mnc":"0","lac":"6334","cid":"127502337","str":0}`,
+			Signal{Radio: RadioLTE, Mnc: "0", Lac: "6334", Cid: "127502337", HasStrength: true}},
+		{`{"mnc":"0","lac":"32838","cid":"60122"}`, Signal{Mnc: "0", Lac: "32838", Cid: "60122"}},
+		{`{"mnc":"0","lac":"32838","cid":"60122","str":null}`, Signal{Mnc: "0", Lac: "32838", Cid: "60122"}},
+	}
+	for _, test := range tests {
+		var signal Signal
+		if err := json.Unmarshal([]byte(test.json), &signal); err != nil || signal != test.expected {
+			t.Errorf("%s: got %+v, expecting %+v, error %v", test.json, signal, test.expected, err)
+		}
+	}
+}
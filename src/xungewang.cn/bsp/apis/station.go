@@ -0,0 +1,142 @@
+package apis
+
+import (
+	"github.com/go-ozzo/ozzo-dbx"
+	"github.com/go-ozzo/ozzo-routing"
+	"github.com/go-ozzo/ozzo-routing/content"
+	"strconv"
+	"xungewang.cn/bsp/app"
+	"xungewang.cn/bsp/errors"
+	"xungewang.cn/bsp/models"
+)
+
+type (
+	// contract station related behaviors
+	stationService interface {
+		Get(ctx app.RequestScope, id string) (*models.Station, error)
+		Count(ctx app.RequestScope, filter *models.StationFilter) (int, error)
+		Query(ctx app.RequestScope, filter *models.StationFilter, offset, limit int) ([]models.Station, error)
+		Create(ctx app.RequestScope, station *models.Station) (*models.Station, error)
+		Update(ctx app.RequestScope, id string, station *models.Station) (*models.Station, error)
+		Delete(ctx app.RequestScope, id string) (*models.Station, error)
+	}
+
+	// wrapper of stationService
+	stationResource struct {
+		service stationService
+	}
+)
+
+// SetupStationRouter sets up routes to manage stations, which are meant for administrators
+func SetupStationRouter(group *routing.RouteGroup, db *dbx.DB, service stationService) {
+	r := &stationResource{service}
+
+	group.Use(
+		app.AdminAuth(),
+		content.TypeNegotiator(content.JSON),
+		app.DbAware(db),
+	)
+
+	group.Get("/stations/<id>", r.get)
+	group.Get("/stations", r.query)
+	group.Post("/stations", r.create)
+	group.Put("/stations/<id>", r.update)
+	group.Delete("/stations/<id>", r.delete)
+}
+
+func (r *stationResource) get(ctx *routing.Context) error {
+	station, err := r.service.Get(app.GetRequestScope(ctx), ctx.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	return ctx.Write(station)
+}
+
+// query stations with filters on radio, mcc, mnc, lac and bounding box (min_lat, min_lng, max_lat and max_lng),
+// paginated by 'page' and 'per_page'
+func (r *stationResource) query(ctx *routing.Context) error {
+	filter, err := extractStationFilter(ctx)
+	if err != nil {
+		return err
+	}
+
+	scope := app.GetRequestScope(ctx)
+	count, err := r.service.Count(scope, filter)
+	if err != nil {
+		return err
+	}
+
+	paginatedList := getPaginatedListFromRequest(ctx, count)
+	items, err := r.service.Query(scope, filter, paginatedList.Offset(), paginatedList.Limit())
+	if err != nil {
+		return err
+	}
+	paginatedList.Items = items
+
+	return ctx.Write(paginatedList)
+}
+
+func (r *stationResource) create(ctx *routing.Context) error {
+	var station models.Station
+	if err := ctx.Read(&station); err != nil {
+		return errors.SimpleInvalidData("station not acceptable: " + err.Error())
+	}
+
+	created, err := r.service.Create(app.GetRequestScope(ctx), &station)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Write(created)
+}
+
+func (r *stationResource) update(ctx *routing.Context) error {
+	var station models.Station
+	if err := ctx.Read(&station); err != nil {
+		return errors.SimpleInvalidData("station not acceptable: " + err.Error())
+	}
+
+	updated, err := r.service.Update(app.GetRequestScope(ctx), ctx.Param("id"), &station)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Write(updated)
+}
+
+func (r *stationResource) delete(ctx *routing.Context) error {
+	station, err := r.service.Delete(app.GetRequestScope(ctx), ctx.Param("id"))
+	if err != nil {
+		return err
+	}
+
+	return ctx.Write(station)
+}
+
+func extractStationFilter(ctx *routing.Context) (*models.StationFilter, error) {
+	filter := &models.StationFilter{
+		Radio: ctx.Query("radio"),
+		Mcc:   ctx.Query("mcc"),
+		Mnc:   ctx.Query("mnc"),
+		Lac:   ctx.Query("lac"),
+	}
+
+	// bounding box is optional, but all of its 4 sides should be given if any
+	sides := []string{ctx.Query("min_lat"), ctx.Query("min_lng"), ctx.Query("max_lat"), ctx.Query("max_lng")}
+	if sides[0] == "" && sides[1] == "" && sides[2] == "" && sides[3] == "" {
+		return filter, nil
+	}
+
+	values := make([]float64, len(sides))
+	for i, side := range sides {
+		value, err := strconv.ParseFloat(side, 64)
+		if err != nil {
+			return nil, errors.SimpleInvalidData("min_lat, min_lng, max_lat and max_lng should be all given as numbers")
+		}
+		values[i] = value
+	}
+	filter.Bounds = &models.Bounds{MinLat: values[0], MinLng: values[1], MaxLat: values[2], MaxLng: values[3]}
+
+	return filter, nil
+}
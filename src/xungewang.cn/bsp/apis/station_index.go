@@ -0,0 +1,40 @@
+package apis
+
+import (
+	"github.com/go-ozzo/ozzo-dbx"
+	"github.com/go-ozzo/ozzo-routing"
+	"github.com/go-ozzo/ozzo-routing/content"
+	"xungewang.cn/bsp/app"
+)
+
+type (
+	// contract of in-memory station index
+	stationIndex interface {
+		// Reload reloads all stations, and returns the number of stations loaded
+		Reload(ctx app.RequestScope) (int, error)
+	}
+
+	StationIndexReloadResult struct {
+		Code     int `json:"code"`
+		Stations int `json:"stations"`
+	}
+)
+
+// SetupStationIndexRouter sets up routes to manage the in-memory station index, which are meant for administrators
+func SetupStationIndexRouter(group *routing.RouteGroup, db *dbx.DB, index stationIndex) {
+	group.Use(
+		app.AdminAuth(),
+		content.TypeNegotiator(content.JSON),
+		app.DbAware(db),
+	)
+
+	// reload right away, e.g., after stations imported or updated
+	group.Post("/station-index/reload", func(ctx *routing.Context) error {
+		stations, err := index.Reload(app.GetRequestScope(ctx))
+		if err != nil {
+			return err
+		}
+
+		return ctx.Write(&StationIndexReloadResult{Code: 200, Stations: stations})
+	})
+}
@@ -0,0 +1,55 @@
+package apis
+
+import (
+	"github.com/go-ozzo/ozzo-routing"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"xungewang.cn/bsp/models"
+)
+
+func TestExtractStationFilter(t *testing.T) {
+	filter, err := extractStationFilter(newQueryContext("radio=lte&mcc=460&min_lat=60&min_lng=170&max_lat=70&max_lng=-170"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := models.Bounds{MinLat: 60, MinLng: 170, MaxLat: 70, MaxLng: -170}
+	if filter.Radio != "lte" || filter.Mcc != "460" || filter.Mnc != "" || filter.Bounds == nil || *filter.Bounds != expected {
+		t.Errorf("unexpected filter %+v", filter)
+	}
+
+	if filter, err := extractStationFilter(newQueryContext("lac=6244")); err != nil || filter.Lac != "6244" || filter.Bounds != nil {
+		t.Errorf("unexpected filter %+v, error %v", filter, err)
+	}
+	for _, query := range []string{"min_lat=30&min_lng=103&max_lat=31", "min_lat=30&min_lng=103&max_lat=31&max_lng=east"} {
+		if _, err := extractStationFilter(newQueryContext(query)); err == nil {
+			t.Errorf("bbox of %s should be invalid", query)
+		}
+	}
+}
+
+func TestGetPaginatedListFromRequest(t *testing.T) {
+	tests := []struct {
+		query                        string
+		total                        int
+		page, perPage, offset, pages int
+	}{
+		{"", 250, 1, defaultPerPage, 0, 3},
+		{"page=3&per_page=20", 250, 3, 20, 40, 13},
+		{"page=20&per_page=20", 250, 13, 20, 240, 13},
+		{"page=0&per_page=-1", 250, 1, defaultPerPage, 0, 3},
+		{"page=x&per_page=5000", 2500, 1, maxPerPage, 0, 3},
+		{"page=2", 0, 1, defaultPerPage, 0, 0},
+	}
+	for _, test := range tests {
+		list := getPaginatedListFromRequest(newQueryContext(test.query), test.total)
+		if list.Page != test.page || list.PerPage != test.perPage || list.Offset() != test.offset ||
+			list.Limit() != test.perPage || list.PageCount != test.pages || list.TotalCount != test.total {
+			t.Errorf("%q of %d: unexpected page %+v", test.query, test.total, list)
+		}
+	}
+}
+
+func newQueryContext(query string) *routing.Context {
+	return routing.NewContext(httptest.NewRecorder(), httptest.NewRequest(http.MethodGet, "/admin/stations?"+query, nil))
+}
@@ -0,0 +1,28 @@
+package apis
+
+import (
+	"github.com/go-ozzo/ozzo-routing"
+	"github.com/go-ozzo/ozzo-routing/content"
+	"xungewang.cn/bsp/app"
+)
+
+// StatsFunc returns current stats of a component
+type StatsFunc func() interface{}
+
+// SetupStatsRouter sets up routes to expose runtime stats of components, keyed by their names, which are meant
+// for administrators
+func SetupStatsRouter(group *routing.RouteGroup, stats map[string]StatsFunc) {
+	group.Use(
+		app.AdminAuth(),
+		content.TypeNegotiator(content.JSON),
+	)
+
+	group.Get("/stats", func(ctx *routing.Context) error {
+		result := make(map[string]interface{}, len(stats))
+		for name, stat := range stats {
+			result[name] = stat()
+		}
+
+		return ctx.Write(result)
+	})
+}
@@ -0,0 +1,69 @@
+package apis
+
+import (
+	"github.com/go-ozzo/ozzo-dbx"
+	"github.com/go-ozzo/ozzo-routing"
+	"github.com/go-ozzo/ozzo-routing/content"
+	"time"
+	"xungewang.cn/bsp/app"
+	"xungewang.cn/bsp/errors"
+	"xungewang.cn/bsp/models"
+)
+
+type (
+	// contract unknown signal related behaviors
+	unknownSignalService interface {
+		Count(ctx app.RequestScope, filter *models.UnknownSignalFilter) (int, error)
+		QueryTop(ctx app.RequestScope, filter *models.UnknownSignalFilter, offset, limit int) ([]models.UnknownSignal, error)
+	}
+
+	// wrapper of unknownSignalService
+	unknownSignalResource struct {
+		service unknownSignalService
+	}
+)
+
+// SetupUnknownSignalRouter sets up routes to look into unknown signals, which are meant for administrators
+func SetupUnknownSignalRouter(group *routing.RouteGroup, db *dbx.DB, service unknownSignalService) {
+	r := &unknownSignalResource{service}
+
+	group.Use(
+		app.AdminAuth(),
+		content.TypeNegotiator(content.JSON),
+		app.DbAware(db),
+	)
+
+	group.Get("/unknown-signals", r.queryTop)
+}
+
+// queryTop lists unknown signals from the most frequently seen, with filters on radio, mcc, mnc and
+// since (RFC 3339 time, e.g. 2017-01-02T15:04:05+08:00), paginated by 'page' and 'per_page'
+func (r *unknownSignalResource) queryTop(ctx *routing.Context) error {
+	filter := &models.UnknownSignalFilter{
+		Radio: ctx.Query("radio"),
+		Mcc:   ctx.Query("mcc"),
+		Mnc:   ctx.Query("mnc"),
+	}
+	if since := ctx.Query("since"); since != "" {
+		t, err := time.Parse(time.RFC3339, since)
+		if err != nil {
+			return errors.SimpleInvalidData("since should be RFC 3339 time, e.g. 2017-01-02T15:04:05+08:00")
+		}
+		filter.Since = &t
+	}
+
+	scope := app.GetRequestScope(ctx)
+	count, err := r.service.Count(scope, filter)
+	if err != nil {
+		return err
+	}
+
+	paginatedList := getPaginatedListFromRequest(ctx, count)
+	items, err := r.service.QueryTop(scope, filter, paginatedList.Offset(), paginatedList.Limit())
+	if err != nil {
+		return err
+	}
+	paginatedList.Items = items
+
+	return ctx.Write(paginatedList)
+}
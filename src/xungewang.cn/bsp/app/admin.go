@@ -0,0 +1,63 @@
+package app
+
+import (
+	"crypto/subtle"
+	"github.com/go-ozzo/ozzo-routing"
+	"net/http"
+	"xungewang.cn/bsp/errors"
+)
+
+const (
+	// AdminTokenHeader is the request header carrying admin credential
+	AdminTokenHeader = "X-Admin-Token"
+
+	// SubmitTokenHeader is the request header carrying credential of those submitting observations
+	SubmitTokenHeader = "X-Submit-Token"
+)
+
+// IsAdmin tells whether the request carries admin credential, which is the admin token configured.
+// No request is taken as admin if there's no admin token configured.
+func IsAdmin(request *http.Request) bool {
+	if Config.AdminToken == "" {
+		return false
+	}
+
+	token := request.Header.Get(AdminTokenHeader)
+	return subtle.ConstantTimeCompare([]byte(token), []byte(Config.AdminToken)) == 1
+}
+
+// AdminAuth returns a handler that rejects requests without admin credential
+func AdminAuth() routing.Handler {
+	return func(ctx *routing.Context) error {
+		if !IsAdmin(ctx.Request) {
+			return errors.Unauthorized("admin credential required")
+		}
+
+		return nil
+	}
+}
+
+// CanSubmit tells whether the request can submit observations, i.e., submission is open to anyone, or the request
+// carries the submit token or admin credential
+func CanSubmit(request *http.Request) bool {
+	if Config.Learning.OpenSubmission || IsAdmin(request) {
+		return true
+	}
+	if Config.Learning.SubmitToken == "" {
+		return false
+	}
+
+	token := request.Header.Get(SubmitTokenHeader)
+	return subtle.ConstantTimeCompare([]byte(token), []byte(Config.Learning.SubmitToken)) == 1
+}
+
+// SubmitAuth returns a handler that rejects requests not allowed to submit observations
+func SubmitAuth() routing.Handler {
+	return func(ctx *routing.Context) error {
+		if !CanSubmit(ctx.Request) {
+			return errors.Unauthorized("submit credential required")
+		}
+
+		return nil
+	}
+}
@@ -1,8 +1,12 @@
 package app
 
 import (
+	"fmt"
 	"github.com/go-ozzo/ozzo-validation"
 	"github.com/spf13/viper"
+	"os"
+	"regexp"
+	"xungewang.cn/bsp/coord"
 )
 
 // Config stores the application-wide configurations
@@ -22,6 +26,192 @@ type appConfig struct {
 	DbMaxOpenConns    int `mapstructure:"db_max_open_conns"`
 	DbMaxIdleConns    int `mapstructure:"db_max_idle_conns"`
 	DbConnMaxLifetime int `mapstructure:"db_conn_max_lifetime"` // in seconds
+
+	// whether pending schema migrations are applied on startup. Defaults to false
+	AutoMigrate bool `mapstructure:"auto_migrate"`
+
+	// Mobile Country Code assumed for signals that come without one. Defaults to '460' (China)
+	DefaultMcc string `mapstructure:"default_mcc"`
+
+	// positioning algorithm used when requests don't specify one. Defaults to "weighted-centroid"
+	DefaultAlgorithm string `mapstructure:"default_algorithm"`
+
+	// stations are rejected as outliers one after another, until the standard deviation of their distances
+	// (in meters) to the center is less than OutlierThreshold (default to 3000), or there're only
+	// OutlierMinStations (default to 1) left
+	OutlierThreshold   float64 `mapstructure:"outlier_threshold"`
+	OutlierMinStations int     `mapstructure:"outlier_min_stations"`
+
+	// maximum number of devices positioned in a batch. Defaults to 100
+	MaxBatchSize int `mapstructure:"max_batch_size"`
+
+	// unknown signals are persisted in the background, which are queued (up to UnknownSignalQueueSize, default to
+	// 10000, and dropped beyond) and written UnknownSignalBatchSize (default to 500) at a time, or every
+	// UnknownSignalFlushInterval (default to 5) seconds
+	UnknownSignalQueueSize     int `mapstructure:"unknown_signal_queue_size"`
+	UnknownSignalBatchSize     int `mapstructure:"unknown_signal_batch_size"`
+	UnknownSignalFlushInterval int `mapstructure:"unknown_signal_flush_interval"`
+
+	// path loss models used to estimate distances to stations from their signal strength
+	PathLoss PathLossConfig `mapstructure:"path_loss"`
+
+	// whether position requests are allowed to ask for explanation. Defaults to false, in which case
+	// only requests carrying the admin token can.
+	ExplainEnabled bool `mapstructure:"explain_enabled"`
+
+	// credential of administrators, passed via 'X-Admin-Token' header. Admin access is disabled if empty.
+	AdminToken string `mapstructure:"admin_token"`
+
+	// learning stations from crowdsourced observations
+	Learning LearningConfig `mapstructure:"learning"`
+
+	// finding stations from an in-memory index rather than the database
+	StationIndex StationIndexConfig `mapstructure:"station_index"`
+
+	// caching stations found from the database
+	StationCache StationCacheConfig `mapstructure:"station_cache"`
+
+	// resolving addresses of positions offline
+	Geocoding GeocodingConfig `mapstructure:"geocoding"`
+
+	// TCP gateways of trackers, which position trackers reporting stations rather than GNSS fixes
+	Gateway GatewayConfig `mapstructure:"gateway"`
+}
+
+// PathLossConfig configures path loss models
+type PathLossConfig struct {
+	// propagation environment, can be one of "urban" (default), "suburban" and "rural"
+	Environment string `mapstructure:"environment"`
+
+	// effective isotropic radiated power of stations, in dBm
+	TxPower float64 `mapstructure:"tx_power"`
+
+	// antenna height of stations and mobile devices, in meters
+	BaseHeight   float64 `mapstructure:"base_height"`
+	MobileHeight float64 `mapstructure:"mobile_height"`
+
+	// path loss model of each radio band, keyed by radio type ("gsm", "umts", "lte" and "nr").
+	// Bands not configured fall back to the free space model at 1000 MHz.
+	Bands map[string]PathLossBand `mapstructure:"bands"`
+}
+
+// PathLossBand configures path loss model of a radio band
+type PathLossBand struct {
+	// model name, can be one of "free_space", "okumura_hata" and "cost231"
+	Model string `mapstructure:"model"`
+
+	// carrier frequency, in MHz
+	Frequency float64 `mapstructure:"frequency"`
+
+	// propagation environment of this band, overrides the one of PathLossConfig if given
+	Environment string `mapstructure:"environment"`
+}
+
+// LearningConfig configures the background job learning stations from observations
+type LearningConfig struct {
+	// whether the job runs in this instance. Defaults to false
+	Enabled bool `mapstructure:"enabled"`
+
+	// seconds between runs, defaults to 300
+	Interval int `mapstructure:"interval"`
+
+	// number of observations learned in a transaction, defaults to 10000
+	BatchSize int `mapstructure:"batch_size"`
+
+	// number of observations a station should be learned from before it's saved, defaults to 3
+	MinSamples int `mapstructure:"min_samples"`
+
+	// credential of those submitting observations, passed via 'X-Submit-Token' request header. The admin token
+	// is accepted as well. Defaults to "", i.e., only admins can submit unless submission is open.
+	SubmitToken string `mapstructure:"submit_token"`
+
+	// whether anyone can submit observations without credential. Defaults to false
+	OpenSubmission bool `mapstructure:"open_submission"`
+}
+
+// StationIndexConfig configures the in-memory station index
+type StationIndexConfig struct {
+	// whether stations are found from the index, which is loaded on startup. Defaults to false
+	Enabled bool `mapstructure:"enabled"`
+
+	// seconds between reloads, defaults to 600
+	ReloadInterval int `mapstructure:"reload_interval"`
+}
+
+// StationCacheConfig configures the LRU cache of stations found from the database
+type StationCacheConfig struct {
+	// whether stations found are cached, which doesn't apply if the station index is enabled. Defaults to false
+	Enabled bool `mapstructure:"enabled"`
+
+	// number of stations (found or not) cached, defaults to 100000
+	Size int `mapstructure:"size"`
+
+	// seconds stations are cached for, defaults to 300
+	TTL int `mapstructure:"ttl"`
+}
+
+// GeocodingConfig configures offline reverse geocoding from administrative boundaries
+type GeocodingConfig struct {
+	// path to the GeoJSON of boundaries of provinces, cities and districts, which is loaded on startup.
+	// Positions come without addresses if empty (default)
+	DataPath string `mapstructure:"data_path"`
+
+	// coordinate type of the boundaries, can be one of "wgs84" (default), "gcj02" and "bd09"
+	CoordType string `mapstructure:"coord_type"`
+}
+
+// GatewayConfig configures TCP gateways of trackers, whose positions are stored in 'device_positions'
+type GatewayConfig struct {
+	// seconds a connection is kept without receiving anything, defaults to 300
+	IdleTimeout int `mapstructure:"idle_timeout"`
+
+	// URL positions of trackers are POSTed to as JSON, not forwarded if empty (default)
+	ForwardURL string `mapstructure:"forward_url"`
+
+	// positions are forwarded in the background, up to ForwardQueueSize (default to 1000) of which are queued,
+	// beyond which they're dropped
+	ForwardQueueSize int `mapstructure:"forward_queue_size"`
+
+	JT808 JT808Config `mapstructure:"jt808"`
+	GT06  GT06Config  `mapstructure:"gt06"`
+}
+
+// JT808Config configures the JT/T 808 gateway
+type JT808Config struct {
+	// whether the gateway is started. Defaults to false
+	Enabled bool `mapstructure:"enabled"`
+
+	// address the gateway listens at, defaults to ':6808'
+	Addr string `mapstructure:"addr"`
+
+	// secret authentication codes of terminals are derived from, required if enabled. Anyone knowing it can
+	// authenticate as any terminal
+	AuthSecret string `mapstructure:"auth_secret"`
+
+	// who can register (and get its authentication code), either "provisioned" (default), i.e., terminals of
+	// 'devices' only, or "open", i.e., any terminal
+	RegisterPolicy string `mapstructure:"register_policy"`
+
+	// phone numbers of terminals provisioned, required if enabled with the "provisioned" policy
+	Devices []string `mapstructure:"devices"`
+
+	// id of the location additional item carrying stations, defaults to 0xE1 (225)
+	LbsItemId int `mapstructure:"lbs_item_id"`
+}
+
+// GT06Config configures the GT06 gateway of Concox-style trackers
+type GT06Config struct {
+	// whether the gateway is started. Defaults to false
+	Enabled bool `mapstructure:"enabled"`
+
+	// address the gateway listens at, defaults to ':5023'
+	Addr string `mapstructure:"addr"`
+
+	// who can log in, either "provisioned" (default), i.e., trackers of 'devices' only, or "open", i.e., any tracker
+	RegisterPolicy string `mapstructure:"register_policy"`
+
+	// IMEIs of trackers provisioned, required if enabled with the "provisioned" policy
+	Devices []string `mapstructure:"devices"`
 }
 
 func (config appConfig) Validate() error {
@@ -30,6 +220,113 @@ func (config appConfig) Validate() error {
 		validation.Field(&config.LogLevel, validation.Required,
 			validation.In("debug", "info", "warn", "warning", "fatal", "panic")),
 		validation.Field(&config.DSN, validation.Required),
+		validation.Field(&config.DefaultMcc, validation.Required, validation.Match(regexp.MustCompile(`^\d{3}$`))),
+		validation.Field(&config.DefaultAlgorithm, validation.Required),
+		validation.Field(&config.OutlierThreshold, validation.Required, validation.Min(0.0).Exclusive()),
+		validation.Field(&config.OutlierMinStations, validation.Required, validation.Min(1)),
+		validation.Field(&config.MaxBatchSize, validation.Required, validation.Min(1)),
+		validation.Field(&config.UnknownSignalQueueSize, validation.Required, validation.Min(1)),
+		validation.Field(&config.UnknownSignalBatchSize, validation.Required, validation.Min(1)),
+		validation.Field(&config.UnknownSignalFlushInterval, validation.Required, validation.Min(1)),
+		validation.Field(&config.PathLoss),
+		validation.Field(&config.Learning),
+		validation.Field(&config.StationIndex),
+		validation.Field(&config.StationCache),
+		validation.Field(&config.Geocoding),
+		validation.Field(&config.Gateway),
+	)
+}
+
+func (config PathLossConfig) Validate() error {
+	return validation.ValidateStruct(&config,
+		validation.Field(&config.Environment, validation.Required, validation.In("urban", "suburban", "rural")),
+		validation.Field(&config.BaseHeight, validation.Required, validation.Min(0.0).Exclusive()),
+		validation.Field(&config.MobileHeight, validation.Required, validation.Min(0.0).Exclusive()),
+		validation.Field(&config.Bands, validation.By(func(interface{}) error {
+			for radio := range config.Bands {
+				if err := validation.Validate(radio, validation.In("gsm", "umts", "lte", "nr")); err != nil {
+					return fmt.Errorf("%s: %s", radio, err)
+				}
+			}
+			return nil
+		})),
+	)
+}
+
+func (config LearningConfig) Validate() error {
+	return validation.ValidateStruct(&config,
+		validation.Field(&config.Interval, validation.Required, validation.Min(1)),
+		validation.Field(&config.BatchSize, validation.Required, validation.Min(1)),
+		validation.Field(&config.MinSamples, validation.Required, validation.Min(1)),
+	)
+}
+
+func (config StationIndexConfig) Validate() error {
+	return validation.ValidateStruct(&config,
+		validation.Field(&config.ReloadInterval, validation.Required, validation.Min(1)),
+	)
+}
+
+func (config StationCacheConfig) Validate() error {
+	return validation.ValidateStruct(&config,
+		validation.Field(&config.Size, validation.Required, validation.Min(1)),
+		validation.Field(&config.TTL, validation.Required, validation.Min(1)),
+	)
+}
+
+func (config GeocodingConfig) Validate() error {
+	return validation.ValidateStruct(&config,
+		validation.Field(&config.CoordType, validation.Required, validation.In(coord.Types...)),
+	)
+}
+
+func (config GatewayConfig) Validate() error {
+	return validation.ValidateStruct(&config,
+		validation.Field(&config.IdleTimeout, validation.Required, validation.Min(1)),
+		validation.Field(&config.ForwardQueueSize, validation.Required, validation.Min(1)),
+		validation.Field(&config.JT808),
+		validation.Field(&config.GT06),
+	)
+}
+
+func (config JT808Config) Validate() error {
+	return validation.ValidateStruct(&config,
+		validation.Field(&config.Addr, validation.Required),
+		validation.Field(&config.AuthSecret, validation.By(func(interface{}) error {
+			if config.Enabled && config.AuthSecret == "" {
+				return fmt.Errorf("cannot be blank if the gateway is enabled")
+			}
+			return nil
+		})),
+		validation.Field(&config.RegisterPolicy, validation.Required, validation.In("provisioned", "open")),
+		validation.Field(&config.Devices, validation.By(func(interface{}) error {
+			if config.Enabled && config.RegisterPolicy == "provisioned" && len(config.Devices) == 0 {
+				return fmt.Errorf("cannot be empty if only terminals provisioned can register")
+			}
+			return nil
+		})),
+		validation.Field(&config.LbsItemId, validation.Required, validation.Min(1), validation.Max(255)),
+	)
+}
+
+func (config GT06Config) Validate() error {
+	return validation.ValidateStruct(&config,
+		validation.Field(&config.Addr, validation.Required),
+		validation.Field(&config.RegisterPolicy, validation.Required, validation.In("provisioned", "open")),
+		validation.Field(&config.Devices, validation.By(func(interface{}) error {
+			if config.Enabled && config.RegisterPolicy == "provisioned" && len(config.Devices) == 0 {
+				return fmt.Errorf("cannot be empty if only trackers provisioned can log in")
+			}
+			return nil
+		})),
+	)
+}
+
+func (band PathLossBand) Validate() error {
+	return validation.ValidateStruct(&band,
+		validation.Field(&band.Model, validation.Required, validation.In("free_space", "okumura_hata", "cost231")),
+		validation.Field(&band.Frequency, validation.Required, validation.Min(0.0).Exclusive()),
+		validation.Field(&band.Environment, validation.In("urban", "suburban", "rural")),
 	)
 }
 
@@ -54,6 +351,47 @@ func LoadConfig(paths ...string) error {
 	viper.SetDefault("db_max_open_conns", 0)
 	viper.SetDefault("db_max_idle_conns", 0)
 	viper.SetDefault("db_conn_max_lifetime", 0)
+	viper.SetDefault("auto_migrate", false)
+	viper.SetDefault("default_mcc", "460")
+	viper.SetDefault("default_algorithm", "weighted-centroid")
+	viper.SetDefault("outlier_threshold", 3000.0)
+	viper.SetDefault("outlier_min_stations", 1)
+	viper.SetDefault("max_batch_size", 100)
+	viper.SetDefault("unknown_signal_queue_size", 10000)
+	viper.SetDefault("unknown_signal_batch_size", 500)
+	viper.SetDefault("unknown_signal_flush_interval", 5)
+	viper.SetDefault("path_loss.environment", "urban")
+	viper.SetDefault("path_loss.tx_power", 43.0)
+	viper.SetDefault("path_loss.base_height", 30.0)
+	viper.SetDefault("path_loss.mobile_height", 1.5)
+	viper.SetDefault("explain_enabled", false)
+	viper.SetDefault("admin_token", "")
+	viper.SetDefault("learning.enabled", false)
+	viper.SetDefault("learning.interval", 300)
+	viper.SetDefault("learning.batch_size", 10000)
+	viper.SetDefault("learning.min_samples", 3)
+	viper.SetDefault("learning.submit_token", "")
+	viper.SetDefault("learning.open_submission", false)
+	viper.SetDefault("station_index.enabled", false)
+	viper.SetDefault("station_index.reload_interval", 600)
+	viper.SetDefault("station_cache.enabled", false)
+	viper.SetDefault("station_cache.size", 100000)
+	viper.SetDefault("station_cache.ttl", 300)
+	viper.SetDefault("geocoding.data_path", "")
+	viper.SetDefault("geocoding.coord_type", "wgs84")
+	viper.SetDefault("gateway.idle_timeout", 300)
+	viper.SetDefault("gateway.forward_url", "")
+	viper.SetDefault("gateway.forward_queue_size", 1000)
+	viper.SetDefault("gateway.jt808.enabled", false)
+	viper.SetDefault("gateway.jt808.addr", ":6808")
+	viper.SetDefault("gateway.jt808.auth_secret", "")
+	viper.SetDefault("gateway.jt808.register_policy", "provisioned")
+	viper.SetDefault("gateway.jt808.devices", []string{})
+	viper.SetDefault("gateway.jt808.lbs_item_id", 0xE1)
+	viper.SetDefault("gateway.gt06.enabled", false)
+	viper.SetDefault("gateway.gt06.addr", ":5023")
+	viper.SetDefault("gateway.gt06.register_policy", "provisioned")
+	viper.SetDefault("gateway.gt06.devices", []string{})
 
 	// read config from paths in file system.
 	if len(paths) > 0 {
@@ -67,6 +405,13 @@ func LoadConfig(paths ...string) error {
 		}
 	}
 
+	// 'default_position_mode' is what 'default_algorithm' used to be named, which is still honored unless
+	// 'default_algorithm' is given as well
+	if mode := viper.GetString("default_position_mode"); mode != "" && !viper.InConfig("default_algorithm") &&
+		os.Getenv("BSP_DEFAULT_ALGORITHM") == "" {
+		viper.Set("default_algorithm", mode)
+	}
+
 	if err := viper.Unmarshal(&Config); err != nil {
 		return err
 	}
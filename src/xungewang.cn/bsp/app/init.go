@@ -1,6 +1,7 @@
 package app
 
 import (
+	"database/sql"
 	log "github.com/Sirupsen/logrus"
 	"github.com/go-ozzo/ozzo-routing"
 	"github.com/go-ozzo/ozzo-routing/fault"
@@ -38,6 +39,10 @@ func recovery(logf fault.LogFunc, errorf ...fault.ConvertErrorFunc) routing.Hand
 }
 
 func convertError(ctx *routing.Context, err error) error {
+	if err == sql.ErrNoRows {
+		return errors.NotFound("the requested resource was not found")
+	}
+
 	switch err.(type) {
 	case *errors.APIError:
 		return err
@@ -28,3 +28,9 @@ func (scope *requestScope) SetDb(db *dbx.DB) {
 func newRequestScope(request *http.Request) RequestScope {
 	return &requestScope{}
 }
+
+// NewDbScope creates a RequestScope bound to db, which is meant for tasks run outside of http requests,
+// e.g., command line tools.
+func NewDbScope(db *dbx.DB) RequestScope {
+	return &requestScope{db: db}
+}
@@ -0,0 +1,159 @@
+// Package coord converts coordinates among datums used by maps in China, i.e., WGS-84 (GPS, and what base
+// stations are stored in), GCJ-02 (AMap, Tencent Maps and Google Maps in China) and BD-09 (Baidu Maps).
+//
+// GCJ-02 obfuscates WGS-84 with a non-linear offset of hundreds of meters, which is only applied within China.
+// BD-09 adds another offset on top of GCJ-02. Conversions towards WGS-84 are inverted numerically, which are
+// accurate to about a centimeter.
+package coord
+
+import (
+	"fmt"
+	"math"
+)
+
+// coordinate types, a.k.a. datums
+const (
+	WGS84 = "wgs84"
+	GCJ02 = "gcj02"
+	BD09  = "bd09"
+)
+
+// Types lists all coordinate types supported
+var Types = []interface{}{WGS84, GCJ02, BD09}
+
+const (
+	// Krasovsky 1940 ellipsoid, which GCJ-02 is based on
+	semiMajorAxis = 6378245.0
+	eccentricity2 = 0.00669342162296594323
+
+	// precision (in degrees) of numeric inversions, about a centimeter
+	inversionPrecision = 1e-7
+	maxInversionRounds = 30
+
+	bdFactor = math.Pi * 3000.0 / 180.0
+)
+
+// Convert converts (lat, lng) from one coordinate type to another. Empty types are taken as WGS84.
+func Convert(lat, lng float64, from, to string) (float64, float64, error) {
+	if from == "" {
+		from = WGS84
+	}
+	if to == "" {
+		to = WGS84
+	}
+
+	switch from {
+	case WGS84:
+	case GCJ02:
+		lat, lng = GCJ02ToWGS84(lat, lng)
+	case BD09:
+		lat, lng = BD09ToWGS84(lat, lng)
+	default:
+		return 0, 0, fmt.Errorf("unknown coordinate type '%s'", from)
+	}
+
+	switch to {
+	case WGS84:
+		return lat, lng, nil
+	case GCJ02:
+		lat, lng = WGS84ToGCJ02(lat, lng)
+		return lat, lng, nil
+	case BD09:
+		lat, lng = WGS84ToBD09(lat, lng)
+		return lat, lng, nil
+	default:
+		return 0, 0, fmt.Errorf("unknown coordinate type '%s'", to)
+	}
+}
+
+// WGS84ToGCJ02 converts WGS-84 coordinates to GCJ-02. Those outside China are kept as they are.
+func WGS84ToGCJ02(lat, lng float64) (float64, float64) {
+	if outOfChina(lat, lng) {
+		return lat, lng
+	}
+
+	dLat, dLng := delta(lat, lng)
+	return lat + dLat, lng + dLng
+}
+
+// GCJ02ToWGS84 converts GCJ-02 coordinates to WGS-84, by refining the WGS-84 guess until it converts back
+// to the coordinates given
+func GCJ02ToWGS84(lat, lng float64) (float64, float64) {
+	return invert(lat, lng, WGS84ToGCJ02)
+}
+
+// GCJ02ToBD09 converts GCJ-02 coordinates to BD-09
+func GCJ02ToBD09(lat, lng float64) (float64, float64) {
+	z := math.Hypot(lng, lat) + 0.00002*math.Sin(lat*bdFactor)
+	theta := math.Atan2(lat, lng) + 0.000003*math.Cos(lng*bdFactor)
+
+	return z*math.Sin(theta) + 0.006, z*math.Cos(theta) + 0.0065
+}
+
+// BD09ToGCJ02 converts BD-09 coordinates to GCJ-02
+func BD09ToGCJ02(lat, lng float64) (float64, float64) {
+	return invert(lat, lng, GCJ02ToBD09)
+}
+
+// WGS84ToBD09 converts WGS-84 coordinates to BD-09
+func WGS84ToBD09(lat, lng float64) (float64, float64) {
+	return GCJ02ToBD09(WGS84ToGCJ02(lat, lng))
+}
+
+// BD09ToWGS84 converts BD-09 coordinates to WGS-84
+func BD09ToWGS84(lat, lng float64) (float64, float64) {
+	return GCJ02ToWGS84(BD09ToGCJ02(lat, lng))
+}
+
+// invert finds (lat, lng) that forward converts to the target given, starting from the target itself
+func invert(targetLat, targetLng float64, forward func(lat, lng float64) (float64, float64)) (float64, float64) {
+	lat, lng := targetLat, targetLng
+	for i := 0; i < maxInversionRounds; i++ {
+		convertedLat, convertedLng := forward(lat, lng)
+		dLat, dLng := targetLat-convertedLat, targetLng-convertedLng
+		lat, lng = lat+dLat, lng+dLng
+		if math.Abs(dLat) < inversionPrecision && math.Abs(dLng) < inversionPrecision {
+			break
+		}
+	}
+
+	return lat, lng
+}
+
+// a rough bounding box of China, outside which GCJ-02 is the same as WGS-84
+func outOfChina(lat, lng float64) bool {
+	return lng < 72.004 || lng > 137.8347 || lat < 0.8293 || lat > 55.8271
+}
+
+// delta computes the offset (in degrees) GCJ-02 applies at the given WGS-84 coordinates
+func delta(lat, lng float64) (float64, float64) {
+	dLat := transformLat(lng-105.0, lat-35.0)
+	dLng := transformLng(lng-105.0, lat-35.0)
+
+	radLat := lat / 180.0 * math.Pi
+	magic := 1 - eccentricity2*math.Pow(math.Sin(radLat), 2)
+	sqrtMagic := math.Sqrt(magic)
+
+	dLat = (dLat * 180.0) / ((semiMajorAxis * (1 - eccentricity2)) / (magic * sqrtMagic) * math.Pi)
+	dLng = (dLng * 180.0) / (semiMajorAxis / sqrtMagic * math.Cos(radLat) * math.Pi)
+
+	return dLat, dLng
+}
+
+func transformLat(x, y float64) float64 {
+	ret := -100.0 + 2.0*x + 3.0*y + 0.2*y*y + 0.1*x*y + 0.2*math.Sqrt(math.Abs(x))
+	ret += (20.0*math.Sin(6.0*x*math.Pi) + 20.0*math.Sin(2.0*x*math.Pi)) * 2.0 / 3.0
+	ret += (20.0*math.Sin(y*math.Pi) + 40.0*math.Sin(y/3.0*math.Pi)) * 2.0 / 3.0
+	ret += (160.0*math.Sin(y/12.0*math.Pi) + 320*math.Sin(y*math.Pi/30.0)) * 2.0 / 3.0
+
+	return ret
+}
+
+func transformLng(x, y float64) float64 {
+	ret := 300.0 + x + 2.0*y + 0.1*x*x + 0.1*x*y + 0.1*math.Sqrt(math.Abs(x))
+	ret += (20.0*math.Sin(6.0*x*math.Pi) + 20.0*math.Sin(2.0*x*math.Pi)) * 2.0 / 3.0
+	ret += (20.0*math.Sin(x*math.Pi) + 40.0*math.Sin(x/3.0*math.Pi)) * 2.0 / 3.0
+	ret += (150.0*math.Sin(x/12.0*math.Pi) + 300.0*math.Sin(x/30.0*math.Pi)) * 2.0 / 3.0
+
+	return ret
+}
@@ -0,0 +1,48 @@
+package coord
+
+import (
+	"math"
+	"testing"
+)
+
+const EPSILON = 1e-6
+
+func TestWGS84ToGCJ02(t *testing.T) {
+	lat, lng := WGS84ToGCJ02(31.1774276, 121.5272106)
+	if math.Abs(lat-31.17530398364597) > EPSILON || math.Abs(lng-121.531541859215) > EPSILON {
+		t.Errorf("unexpected GCJ-02 coordinates (%f, %f)", lat, lng)
+	}
+
+	// no offset outside China
+	if lat, lng := WGS84ToGCJ02(37.774929, -122.419416); lat != 37.774929 || lng != -122.419416 {
+		t.Errorf("coordinates outside China expected to be kept, got (%f, %f)", lat, lng)
+	}
+}
+
+func TestConvert_RoundTrip(t *testing.T) {
+	for _, from := range []string{WGS84, GCJ02, BD09} {
+		for _, to := range []string{WGS84, GCJ02, BD09} {
+			lat, lng, err := Convert(30.732796, 103.962357, from, to)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if from != to && math.Abs(lat-30.732796)+math.Abs(lng-103.962357) < 0.001 {
+				t.Errorf("%s to %s expected to shift by hundreds of meters, got (%f, %f)", from, to, lat, lng)
+			}
+
+			lat, lng, _ = Convert(lat, lng, to, from)
+			if math.Abs(lat-30.732796) > EPSILON || math.Abs(lng-103.962357) > EPSILON {
+				t.Errorf("%s to %s and back: unexpected coordinates (%f, %f)", from, to, lat, lng)
+			}
+		}
+	}
+}
+
+func TestConvert_UnknownType(t *testing.T) {
+	if _, _, err := Convert(30.732796, 103.962357, WGS84, "mercator"); err == nil {
+		t.Error("error expected for unknown coordinate type")
+	}
+	if _, _, err := Convert(30.732796, 103.962357, "mercator", ""); err == nil {
+		t.Error("error expected for unknown coordinate type")
+	}
+}
@@ -20,6 +20,21 @@ func NotFound(message string) *APIError {
 	return NewAPIError(http.StatusNotFound, "NOT_FOUND", message)
 }
 
+// Unauthorized creates a new API error representing an authentication failure (HTTP 401)
+func Unauthorized(message string) *APIError {
+	return NewAPIError(http.StatusUnauthorized, "UNAUTHORIZED", message)
+}
+
+// Forbidden creates a new API error representing an authorization failure (HTTP 403)
+func Forbidden(message string) *APIError {
+	return NewAPIError(http.StatusForbidden, "FORBIDDEN", message)
+}
+
+// Conflict creates a new API error representing a conflict with an existing resource (HTTP 409)
+func Conflict(message string) *APIError {
+	return NewAPIError(http.StatusConflict, "CONFLICT", message)
+}
+
 // InvalidData converts a data validation error into an API error (HTTP 400)
 func InvalidData(errs validation.Errors) *APIError {
 	return NewAPIError(http.StatusBadRequest, "INVALID_DATA", errs.Error())
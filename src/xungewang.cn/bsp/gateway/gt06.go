@@ -0,0 +1,364 @@
+package gateway
+
+import (
+	"bufio"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"strconv"
+	"time"
+	"xungewang.cn/bsp/apis"
+)
+
+// ProtocolGT06 names the GT06 protocol of Concox-style trackers, as recorded along with positions
+const ProtocolGT06 = "gt06"
+
+// framing of GT06, i.e., start bits, packet length (1 byte, or 2 bytes for the extended start bits), protocol
+// number, information content, serial number, CRC-ITU of the length through serial number, and stop bits
+const (
+	gt06Start         = 0x78
+	gt06StartExtended = 0x79
+	gt06Stop          = 0x0d0a
+
+	// packet length covers protocol number, serial number and CRC, besides information content
+	gt06MinPacketLength = 5
+	gt06MaxPacketLength = 1024
+)
+
+// protocol numbers of GT06 packets
+const (
+	gt06Login        = 0x01
+	gt06GPSLBS       = 0x12
+	gt06Status       = 0x13
+	gt06LBSExtension = 0x18
+	gt06GPSLBS2      = 0x22
+	gt06Heartbeat    = 0x23
+	gt06LBSMultiple  = 0x28
+)
+
+// bits of course and status of GPS information
+const (
+	gt06Positioned = 1 << 12
+	gt06West       = 1 << 11
+	gt06North      = 1 << 10
+)
+
+// length of GPS information, i.e., satellites, latitude, longitude, speed, course and status
+const gt06GPSLength = 12
+
+// number of cells (the serving cell and neighbors) carried by LBS packets, each of which is LAC, CI and RSSI
+const (
+	gt06Cells      = 7
+	gt06CellLength = 6
+)
+
+var errGT06PacketTooLong = errors.New("packet too long")
+
+type (
+	// gt06Packet is a GT06 packet, decoded out of a frame
+	gt06Packet struct {
+		protocol byte
+		info     []byte
+		serial   uint16
+	}
+
+	// gt06Handler serves GT06 trackers
+	gt06Handler struct {
+		tracker *Tracker
+		// IMEIs of trackers provisioned, nil if any tracker can log in
+		devices map[string]bool
+	}
+)
+
+// NewGT06Server creates a Server of GT06 trackers, which positions and records trackers reporting GPS or LBS
+// packets once they log in. Only trackers of devices (IMEIs) can log in, or any tracker if devices is nil.
+func NewGT06Server(addr string, idleTimeout time.Duration, tracker *Tracker, devices []string) *Server {
+	handler := &gt06Handler{tracker: tracker}
+	if devices != nil {
+		handler.devices = make(map[string]bool, len(devices))
+		for _, imei := range devices {
+			handler.devices[imei] = true
+		}
+	}
+
+	return newServer(ProtocolGT06, addr, idleTimeout, handler.serve)
+}
+
+func (handler *gt06Handler) serve(session *session) error {
+	for {
+		session.touch()
+		frame, err := readGT06Frame(session.reader)
+		if err != nil {
+			return err
+		}
+
+		packet, err := decodeGT06Packet(frame)
+		if err != nil {
+			// a corrupt frame is dropped, whose tracker is expected to resend it
+			log.Debugf("invalid GT06 frame from %s: %s", session.conn.RemoteAddr(), err)
+			continue
+		}
+		if err := session.write(handler.handle(session, packet)); err != nil {
+			return err
+		}
+	}
+}
+
+// handle handles a packet, which returns the encoded response, nil if no response is needed
+func (handler *gt06Handler) handle(session *session, packet *gt06Packet) []byte {
+	if packet.protocol == gt06Login {
+		if len(packet.info) < 8 {
+			log.Debugf("invalid GT06 login from %s", session.conn.RemoteAddr())
+			return nil
+		}
+		// terminal id is the IMEI led by a 0
+		imei := decodeBCD(packet.info[:8])[1:]
+		if !handler.provisioned(imei) {
+			// the login is not acknowledged, and packets afterwards are dropped as if before login
+			log.Infof("GT06 tracker %s not provisioned is refused to log in", imei)
+			session.deviceId, session.authenticated = "", false
+			return nil
+		}
+		session.deviceId, session.authenticated = imei, true
+		return packet.acknowledge()
+	}
+
+	if !session.authenticated {
+		log.Debugf("GT06 packet %#02x from %s before login is dropped", packet.protocol, session.conn.RemoteAddr())
+		return nil
+	}
+
+	var report *report
+	var err error
+	switch packet.protocol {
+	case gt06Status, gt06Heartbeat:
+		return packet.acknowledge()
+	case gt06GPSLBS, gt06GPSLBS2:
+		report, err = parseGT06GPSLBS(session.deviceId, packet.info)
+	case gt06LBSExtension, gt06LBSMultiple:
+		report, err = parseGT06LBS(session.deviceId, packet.info)
+	default:
+		log.Debugf("unsupported GT06 packet %#02x from %s", packet.protocol, session.deviceId)
+		return nil
+	}
+	if err != nil {
+		log.Debugf("invalid GT06 packet %#02x from %s: %s", packet.protocol, session.deviceId, err)
+		return nil
+	}
+
+	handler.tracker.track(ProtocolGT06, report)
+	return packet.acknowledge()
+}
+
+// provisioned tells whether the tracker of imei can log in
+func (handler *gt06Handler) provisioned(imei string) bool {
+	return handler.devices == nil || handler.devices[imei]
+}
+
+// parseGT06GPSLBS parses GPS and LBS information, i.e., date time, GPS information, MCC, MNC, LAC and CI of the
+// serving cell, followed by what's ignored (e.g., ACC of 0x22)
+func parseGT06GPSLBS(deviceId string, info []byte) (*report, error) {
+	if len(info) < 6+gt06GPSLength {
+		return nil, fmt.Errorf("information of %d bytes is too short", len(info))
+	}
+
+	report := &report{deviceId: deviceId, time: parseGT06Time(info)}
+	gps := info[6:]
+	status := binary.BigEndian.Uint16(gps[10:])
+	if status&gt06Positioned != 0 {
+		report.gnss = true
+		report.lat = float64(binary.BigEndian.Uint32(gps[1:])) / 1800000
+		report.lng = float64(binary.BigEndian.Uint32(gps[5:])) / 1800000
+		if status&gt06North == 0 {
+			report.lat = -report.lat
+		}
+		if status&gt06West != 0 {
+			report.lng = -report.lng
+		}
+		return report, nil
+	}
+
+	mcc, mnc, cells, err := parseGT06Network(info[6+gt06GPSLength:])
+	if err != nil {
+		return nil, err
+	}
+	if len(cells) < 5 {
+		return nil, errors.New("truncated serving cell")
+	}
+	report.signals = appendGT06Signal(report.signals, mcc, mnc, cells)
+
+	return report, nil
+}
+
+// parseGT06LBS parses LBS information, i.e., date time, MCC, MNC, followed by LAC, CI and RSSI of the serving
+// cell and 6 neighbors. RSSI is not in dBm hence ignored, and neighbors of LAC and CI 0 are absent.
+func parseGT06LBS(deviceId string, info []byte) (*report, error) {
+	if len(info) < 6 {
+		return nil, fmt.Errorf("information of %d bytes is too short", len(info))
+	}
+
+	report := &report{deviceId: deviceId, time: parseGT06Time(info)}
+	mcc, mnc, cells, err := parseGT06Network(info[6:])
+	if err != nil {
+		return nil, err
+	}
+	if len(cells) < gt06Cells*gt06CellLength {
+		return nil, fmt.Errorf("cells of %d bytes, expecting %d", len(cells), gt06Cells*gt06CellLength)
+	}
+	for i := 0; i < gt06Cells; i++ {
+		report.signals = appendGT06Signal(report.signals, mcc, mnc, cells[i*gt06CellLength:])
+	}
+
+	return report, nil
+}
+
+// parseGT06Network parses MCC and MNC, which returns the cells following them. MNC is of 2 bytes rather than 1 if
+// the highest bit of MCC is set.
+func parseGT06Network(data []byte) (mcc, mnc string, cells []byte, err error) {
+	if len(data) < 3 {
+		return "", "", nil, errors.New("truncated MCC and MNC")
+	}
+
+	code := binary.BigEndian.Uint16(data)
+	if code&0x8000 == 0 {
+		return strconv.Itoa(int(code)), strconv.Itoa(int(data[2])), data[3:], nil
+	}
+	if len(data) < 4 {
+		return "", "", nil, errors.New("truncated MNC")
+	}
+	return strconv.Itoa(int(code &^ 0x8000)), strconv.Itoa(int(binary.BigEndian.Uint16(data[2:]))), data[4:], nil
+}
+
+// appendGT06Signal appends the GSM cell of LAC WORD and CI of 3 bytes, unless both are 0
+func appendGT06Signal(signals []apis.Signal, mcc, mnc string, cell []byte) []apis.Signal {
+	lac, ci := binary.BigEndian.Uint16(cell), uint32(cell[2])<<16|uint32(cell[3])<<8|uint32(cell[4])
+	if lac == 0 && ci == 0 {
+		return signals
+	}
+
+	return append(signals, apis.Signal{
+		Radio: apis.RadioGSM,
+		Mcc:   mcc,
+		Mnc:   mnc,
+		Lac:   strconv.Itoa(int(lac)),
+		Cid:   strconv.Itoa(int(ci)),
+	})
+}
+
+// parseGT06Time parses date time of 6 bytes, i.e., year (since 2000), month, day, hour, minute and second of UTC
+func parseGT06Time(data []byte) time.Time {
+	if data[1] < 1 || data[1] > 12 || data[2] < 1 || data[2] > 31 {
+		return time.Now()
+	}
+
+	return time.Date(2000+int(data[0]), time.Month(data[1]), int(data[2]), int(data[3]), int(data[4]), int(data[5]), 0,
+		time.UTC)
+}
+
+// acknowledge encodes the response to the packet, which echoes its protocol number and serial number
+func (packet *gt06Packet) acknowledge() []byte {
+	return encodeGT06Packet(packet.protocol, packet.serial, nil)
+}
+
+// encodeGT06Packet encodes a packet with the standard start bits
+func encodeGT06Packet(protocol byte, serial uint16, info []byte) []byte {
+	frame := make([]byte, 0, 10+len(info))
+	frame = append(frame, gt06Start, gt06Start, byte(gt06MinPacketLength+len(info)), protocol)
+	frame = append(frame, info...)
+	frame = append(frame, byte(serial>>8), byte(serial))
+	crc := gt06Checksum(frame[2:])
+
+	return append(frame, byte(crc>>8), byte(crc), gt06Stop>>8, gt06Stop&0xff)
+}
+
+// readGT06Frame reads the next frame, from start bits to stop bits
+func readGT06Frame(reader *bufio.Reader) ([]byte, error) {
+	// skip whatever precedes start bits
+	var start byte
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b != gt06Start && b != gt06StartExtended {
+			continue
+		}
+		if next, err := reader.Peek(1); err == nil && next[0] == b {
+			reader.ReadByte()
+			start = b
+			break
+		}
+	}
+
+	frame := []byte{start, start}
+	lengthSize := 1
+	if start == gt06StartExtended {
+		lengthSize = 2
+	}
+	frame = append(frame, make([]byte, lengthSize)...)
+	if _, err := io.ReadFull(reader, frame[2:]); err != nil {
+		return nil, err
+	}
+
+	length := int(frame[2])
+	if lengthSize == 2 {
+		length = int(binary.BigEndian.Uint16(frame[2:]))
+	}
+	if length > gt06MaxPacketLength {
+		return nil, errGT06PacketTooLong
+	}
+
+	// packet and stop bits
+	frame = append(frame, make([]byte, length+2)...)
+	if _, err := io.ReadFull(reader, frame[2+lengthSize:]); err != nil {
+		return nil, err
+	}
+
+	return frame, nil
+}
+
+// decodeGT06Packet decodes a frame, whose packet length, CRC and stop bits are checked
+func decodeGT06Packet(frame []byte) (*gt06Packet, error) {
+	lengthSize := 1
+	if frame[0] == gt06StartExtended {
+		lengthSize = 2
+	}
+	if len(frame) < 2+lengthSize+gt06MinPacketLength+2 {
+		return nil, fmt.Errorf("frame of %d bytes is too short", len(frame))
+	}
+	if binary.BigEndian.Uint16(frame[len(frame)-2:]) != gt06Stop {
+		return nil, errors.New("invalid stop bits")
+	}
+
+	// from packet length through serial number, followed by CRC
+	data := frame[2 : len(frame)-4]
+	if crc := binary.BigEndian.Uint16(frame[len(frame)-4:]); gt06Checksum(data) != crc {
+		return nil, errors.New("CRC mismatch")
+	}
+
+	packet := data[lengthSize:]
+	return &gt06Packet{
+		protocol: packet[0],
+		info:     packet[1 : len(packet)-2],
+		serial:   binary.BigEndian.Uint16(packet[len(packet)-2:]),
+	}, nil
+}
+
+// gt06Checksum computes CRC-ITU (i.e., CRC-16/X-25) of data
+func gt06Checksum(data []byte) uint16 {
+	crc := uint16(0xffff)
+	for _, b := range data {
+		crc ^= uint16(b)
+		for i := 0; i < 8; i++ {
+			if crc&1 != 0 {
+				crc = crc>>1 ^ 0x8408
+			} else {
+				crc >>= 1
+			}
+		}
+	}
+
+	return ^crc
+}
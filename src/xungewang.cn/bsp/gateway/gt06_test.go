@@ -0,0 +1,197 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+	"xungewang.cn/bsp/apis"
+	"xungewang.cn/bsp/models"
+)
+
+// the sample login response of the GT06 protocol
+var gt06LoginResponse = []byte{0x78, 0x78, 0x05, 0x01, 0x00, 0x01, 0xd9, 0xdc, 0x0d, 0x0a}
+
+func TestGT06Frame(t *testing.T) {
+	if frame := encodeGT06Packet(gt06Login, 1, nil); !bytes.Equal(frame, gt06LoginResponse) {
+		t.Errorf("encoded as % x, expecting % x", frame, gt06LoginResponse)
+	}
+
+	// garbage before start bits is skipped, and packets of extended start bits are read the same way
+	extended := []byte{0x79, 0x79, 0x00, 0x06, 0x94, 0x0a, 0x00, 0x02}
+	crc := gt06Checksum(extended[2:])
+	extended = append(extended, byte(crc>>8), byte(crc), 0x0d, 0x0a)
+	stream := append([]byte{0x78, 0x01}, gt06LoginResponse...)
+	stream = append(stream, extended...)
+	reader := bufio.NewReader(bytes.NewReader(stream))
+	for _, expected := range []gt06Packet{
+		{protocol: gt06Login, info: []byte{}, serial: 1},
+		{protocol: 0x94, info: []byte{0x0a}, serial: 2},
+	} {
+		frame, err := readGT06Frame(reader)
+		if err != nil {
+			t.Fatal(err)
+		}
+		packet, err := decodeGT06Packet(frame)
+		if err != nil || packet.protocol != expected.protocol || !bytes.Equal(packet.info, expected.info) ||
+			packet.serial != expected.serial {
+			t.Errorf("unexpected packet %+v, error %v", packet, err)
+		}
+	}
+
+	corrupt := append([]byte(nil), gt06LoginResponse...)
+	corrupt[7]++
+	if _, err := decodeGT06Packet(corrupt); err == nil {
+		t.Error("CRC mismatch should fail")
+	}
+}
+
+func TestGT06Server(t *testing.T) {
+	recorder := &mockPositionRecorder{}
+	client, stop := startServer(t, func(tracker *Tracker) *Server {
+		return NewGT06Server("", time.Minute, tracker, []string{"123456789012345"})
+	}, recorder)
+
+	// packets before login and corrupt ones are dropped without responses, hence the first response is of login
+	client.Write(encodeGT06Packet(gt06Heartbeat, 1, []byte{0x04, 0x06, 0x04, 0x00, 0x02}))
+	corrupt := encodeGT06Packet(gt06Login, 2, []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0x01, 0x23, 0x45})
+	corrupt[6]++
+	client.Write(corrupt)
+	expectGT06Response(t, client, encodeGT06Packet(gt06Login, 3, []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0x01, 0x23, 0x45}),
+		gt06Login, 3)
+	expectGT06Response(t, client, encodeGT06Packet(gt06Status, 4, []byte{0x04, 0x06, 0x04, 0x00, 0x02}), gt06Status, 4)
+
+	// a GPS fix is recorded as it is, while the serving cell positions the tracker without one. Those failed to
+	// be positioned are still acknowledged.
+	expectGT06Response(t, client, encodeGT06Packet(gt06GPSLBS, 5, encodeGT06GPSLBS(true, 6334, 6)), gt06GPSLBS, 5)
+	expectGT06Response(t, client, encodeGT06Packet(gt06GPSLBS2, 6, encodeGT06GPSLBS(false, 6334, 6)), gt06GPSLBS2, 6)
+	expectGT06Response(t, client, encodeGT06Packet(gt06LBSMultiple, 7, encodeGT06LBS(6334, 6, 1)), gt06LBSMultiple, 7)
+	expectGT06Response(t, client, encodeGT06Packet(gt06LBSExtension, 8, encodeGT06LBS(6334, 1, 2)), gt06LBSExtension, 8)
+
+	stop()
+
+	positions := recorder.recorded()
+	if len(positions) != 3 {
+		t.Fatalf("unexpected positions %v", positions)
+	}
+	gnss, lbs := positions[0], positions[1]
+	if gnss.DeviceId != "123456789012345" || gnss.Protocol != ProtocolGT06 || gnss.Source != models.PositionSourceGNSS ||
+		gnss.Lat != 22.5 || gnss.Lng != -113.9 || !gnss.LocatedAt.Equal(time.Date(2024, 5, 1, 1, 30, 0, 0, time.UTC)) {
+		t.Errorf("unexpected GNSS position %+v", gnss)
+	}
+	if lbs.Source != models.PositionSourceLBS || lbs.Lat != 30.5 || lbs.Accuracy != 500 || positions[2].Source != models.PositionSourceLBS {
+		t.Errorf("unexpected LBS positions %+v, %+v", lbs, positions[2])
+	}
+}
+
+func TestGT06Server_Provisioned(t *testing.T) {
+	recorder := &mockPositionRecorder{}
+	client, stop := startServer(t, func(tracker *Tracker) *Server {
+		return NewGT06Server("", time.Minute, tracker, []string{"123456789012345"})
+	}, recorder)
+
+	// trackers not provisioned get no login response, and their locations are dropped without responses as well,
+	// hence the first response is of the login of the one provisioned
+	client.Write(encodeGT06Packet(gt06Login, 1, []byte{0x09, 0x87, 0x65, 0x43, 0x21, 0x09, 0x87, 0x65}))
+	client.Write(encodeGT06Packet(gt06GPSLBS, 2, encodeGT06GPSLBS(true, 6334, 6)))
+	expectGT06Response(t, client, encodeGT06Packet(gt06Login, 3, []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0x01, 0x23, 0x45}),
+		gt06Login, 3)
+
+	// logging in as one not provisioned afterwards drops the login before
+	client.Write(encodeGT06Packet(gt06Login, 4, []byte{0x09, 0x87, 0x65, 0x43, 0x21, 0x09, 0x87, 0x65}))
+	client.Write(encodeGT06Packet(gt06GPSLBS, 5, encodeGT06GPSLBS(true, 6334, 6)))
+	expectGT06Response(t, client, encodeGT06Packet(gt06Login, 6, []byte{0x01, 0x23, 0x45, 0x67, 0x89, 0x01, 0x23, 0x45}),
+		gt06Login, 6)
+
+	stop()
+
+	if positions := recorder.recorded(); len(positions) != 0 {
+		t.Errorf("locations of trackers not provisioned should be dropped, got %v", positions)
+	}
+
+	// any tracker can log in if the policy is open
+	client, stop = startServer(t, func(tracker *Tracker) *Server {
+		return NewGT06Server("", time.Minute, tracker, nil)
+	}, &mockPositionRecorder{})
+	defer stop()
+	expectGT06Response(t, client, encodeGT06Packet(gt06Login, 1, []byte{0x09, 0x87, 0x65, 0x43, 0x21, 0x09, 0x87, 0x65}),
+		gt06Login, 1)
+}
+
+func TestParseGT06LBS(t *testing.T) {
+	report, err := parseGT06LBS("123456789012345", encodeGT06LBS(6334, 6, 2))
+	expected := []apis.Signal{
+		{Radio: apis.RadioGSM, Mcc: "460", Mnc: "0", Lac: "6334", Cid: "6"},
+		{Radio: apis.RadioGSM, Mcc: "460", Mnc: "0", Lac: "6334", Cid: "7"},
+	}
+	if err != nil || len(report.signals) != len(expected) || report.signals[0] != expected[0] || report.signals[1] != expected[1] {
+		t.Errorf("unexpected report %+v, error %v", report, err)
+	}
+
+	// MNC is of 2 bytes if the highest bit of MCC is set
+	info := encodeGT06LBS(6334, 6, 1)
+	info = append(info[:8], append([]byte{0x00, 0x0b}, info[9:]...)...)
+	info[6] |= 0x80
+	if report, err := parseGT06LBS("123456789012345", info); err != nil || len(report.signals) != 1 ||
+		report.signals[0].Mcc != "460" || report.signals[0].Mnc != "11" {
+		t.Errorf("unexpected report %+v, error %v", report, err)
+	}
+
+	if _, err := parseGT06LBS("123456789012345", info[:20]); err == nil {
+		t.Error("truncated cells should fail")
+	}
+}
+
+func expectGT06Response(t *testing.T, client net.Conn, frame []byte, protocol byte, serial uint16) {
+	if _, err := client.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+
+	response, err := readGT06Frame(bufio.NewReader(&byteReader{client}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if expected := encodeGT06Packet(protocol, serial, nil); !bytes.Equal(response, expected) {
+		t.Errorf("unexpected response % x, expecting % x", response, expected)
+	}
+}
+
+// gt06Time is 2024-05-01 01:30:00 UTC
+var gt06Time = []byte{24, 5, 1, 1, 30, 0}
+
+// encodeGT06GPSLBS encodes GPS and LBS information, at (22.5, -113.9) if positioned, and within a cell of MCC 460
+// and MNC 0
+func encodeGT06GPSLBS(positioned bool, lac uint16, ci uint32) []byte {
+	info := append([]byte(nil), gt06Time...)
+	gps := make([]byte, gt06GPSLength)
+	gps[0] = 0xc9
+	binary.BigEndian.PutUint32(gps[1:], 22500000*18/10)
+	binary.BigEndian.PutUint32(gps[5:], 113900000*18/10)
+	if positioned {
+		binary.BigEndian.PutUint16(gps[10:], gt06Positioned|gt06North|gt06West|90)
+	}
+	info = append(info, gps...)
+	info = append(info, 0x01, 0xcc, 0x00)
+	info = append(info, byte(lac>>8), byte(lac), byte(ci>>16), byte(ci>>8), byte(ci))
+
+	return append(info, 0x01, 0x00, 0x00)
+}
+
+// encodeGT06LBS encodes LBS information of cells of MCC 460, MNC 0 and lac, whose CI are ci, ci+1 and so on,
+// followed by absent ones
+func encodeGT06LBS(lac uint16, ci uint32, cells int) []byte {
+	info := append([]byte(nil), gt06Time...)
+	info = append(info, 0x01, 0xcc, 0x00)
+	for i := 0; i < gt06Cells; i++ {
+		cell := make([]byte, gt06CellLength)
+		if i < cells {
+			id := ci + uint32(i)
+			cell = []byte{byte(lac >> 8), byte(lac), byte(id >> 16), byte(id >> 8), byte(id), 0x30}
+		}
+		info = append(info, cell...)
+	}
+
+	return append(info, 0xff, 0x00, 0x02)
+}
@@ -0,0 +1,494 @@
+package gateway
+
+import (
+	"bufio"
+	"crypto/hmac"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"strconv"
+	"strings"
+	"time"
+	"xungewang.cn/bsp/apis"
+)
+
+// ProtocolJT808 names the JT/T 808 protocol, as recorded along with positions
+const ProtocolJT808 = "jt808"
+
+// framing of JT/T 808, i.e., frames are delimited by 0x7e, with 0x7e and 0x7d escaped as 0x7d 0x02 and 0x7d 0x01
+const (
+	jt808Flag   = 0x7e
+	jt808Escape = 0x7d
+
+	// a message body is up to 1023 bytes, which may double once escaped
+	jt808MaxFrameLength = 4096
+)
+
+// ids of JT/T 808 messages
+const (
+	jt808TerminalResponse = 0x0001
+	jt808Heartbeat        = 0x0002
+	jt808Deregister       = 0x0003
+	jt808Register         = 0x0100
+	jt808Authenticate     = 0x0102
+	jt808Location         = 0x0200
+	jt808BatchLocation    = 0x0704
+	jt808PlatformResponse = 0x8001
+	jt808RegisterResponse = 0x8100
+)
+
+// results of platform responses
+const (
+	jt808ResultOk          = 0
+	jt808ResultFailure     = 1
+	jt808ResultInvalid     = 2
+	jt808ResultUnsupported = 3
+)
+
+// result of registration responses telling the terminal is not provisioned
+const jt808RegisterUnknownTerminal = 4
+
+// bits of message properties and of location status
+const (
+	jt808BodyLengthMask = 0x03ff
+	jt808Subpackage     = 1 << 13
+	jt808Version2019    = 1 << 14
+
+	jt808Positioned = 1 << 1
+	jt808South      = 1 << 2
+	jt808West       = 1 << 3
+)
+
+// length of location basic information, i.e., alarm, status, latitude, longitude, altitude, speed, direction and time
+const jt808LocationLength = 28
+
+// length of each cell carried by the LBS item
+const jt808CellLength = 14
+
+// radio types of cells carried by the LBS item
+var jt808Radios = map[byte]string{1: apis.RadioGSM, 2: apis.RadioUMTS, 3: apis.RadioLTE, 4: apis.RadioNR}
+
+// times reported by JT/T 808 terminals are of GMT+8
+var jt808Zone = time.FixedZone("GMT+8", 8*60*60)
+
+var errJT808FrameTooLong = errors.New("frame too long")
+
+type (
+	// jt808Message is a JT/T 808 message, decoded out of a frame
+	jt808Message struct {
+		id    uint16
+		props uint16
+		// protocol version, only for 2019
+		version byte
+		// terminal phone number, as BCD encoded and as decoded
+		phoneBCD []byte
+		phone    string
+		serial   uint16
+		body     []byte
+	}
+
+	// jt808Handler serves JT/T 808 terminals
+	jt808Handler struct {
+		tracker    *Tracker
+		authSecret []byte
+		lbsItemId  byte
+		// phone numbers (without leading zeros) of terminals provisioned, nil if any terminal can register
+		devices map[string]bool
+	}
+)
+
+// NewJT808Server creates a Server of JT/T 808 terminals, which positions and records terminals reporting locations.
+// Terminals are authenticated by codes derived from authSecret, and stations they see are carried by the location
+// additional item of lbsItemId. Only terminals of devices (phone numbers, leading zeros ignored) can register and
+// authenticate, or any terminal if devices is nil.
+func NewJT808Server(addr string, idleTimeout time.Duration, tracker *Tracker, authSecret string, lbsItemId byte,
+	devices []string) *Server {
+	handler := &jt808Handler{tracker: tracker, authSecret: []byte(authSecret), lbsItemId: lbsItemId}
+	if devices != nil {
+		handler.devices = make(map[string]bool, len(devices))
+		for _, phone := range devices {
+			handler.devices[strings.TrimLeft(phone, "0")] = true
+		}
+	}
+
+	return newServer(ProtocolJT808, addr, idleTimeout, handler.serve)
+}
+
+func (handler *jt808Handler) serve(session *session) error {
+	for {
+		session.touch()
+		frame, err := readJT808Frame(session.reader)
+		if err != nil {
+			return err
+		}
+
+		message, err := decodeJT808Message(frame)
+		if err != nil {
+			// a corrupt frame is dropped, whose terminal is expected to resend it
+			log.Debugf("invalid JT/T 808 frame from %s: %s", session.conn.RemoteAddr(), err)
+			continue
+		}
+		if err := session.write(handler.handle(session, message)); err != nil {
+			return err
+		}
+	}
+}
+
+// handle handles a message, which returns the encoded response, nil if no response is needed
+func (handler *jt808Handler) handle(session *session, message *jt808Message) []byte {
+	// subpackages are not reassembled, each of which is refused as unsupported rather than taken as the whole
+	// message, so that terminals don't take a part of a message for acknowledged
+	if message.props&jt808Subpackage != 0 {
+		log.Debugf("JT/T 808 subpackage of message %#04x from %s is unsupported", message.id, message.phone)
+		return message.respond(session.nextSerial(), jt808ResultUnsupported)
+	}
+
+	switch message.id {
+	case jt808Register:
+		body := make([]byte, 3, 3+64)
+		binary.BigEndian.PutUint16(body, message.serial)
+		if !handler.provisioned(message.phone) {
+			log.Infof("JT/T 808 terminal %s not provisioned is refused to register", message.phone)
+			body[2] = jt808RegisterUnknownTerminal
+			return message.reply(jt808RegisterResponse, session.nextSerial(), body)
+		}
+		session.deviceId = message.phone
+		body[2] = jt808ResultOk
+		body = append(body, handler.authCode(message.phone)...)
+		return message.reply(jt808RegisterResponse, session.nextSerial(), body)
+	case jt808Authenticate:
+		code, err := message.authCode()
+		if err != nil || !handler.provisioned(message.phone) ||
+			!hmac.Equal([]byte(code), []byte(handler.authCode(message.phone))) {
+			log.Debugf("JT/T 808 terminal %s failed to authenticate", message.phone)
+			return message.respond(session.nextSerial(), jt808ResultFailure)
+		}
+		session.deviceId, session.authenticated = message.phone, true
+		return message.respond(session.nextSerial(), jt808ResultOk)
+	case jt808Heartbeat, jt808Deregister:
+		return message.respond(session.nextSerial(), jt808ResultOk)
+	case jt808TerminalResponse:
+		return nil
+	case jt808Location, jt808BatchLocation:
+		if !session.authenticated || message.phone != session.deviceId {
+			return message.respond(session.nextSerial(), jt808ResultFailure)
+		}
+
+		var reports []*report
+		var err error
+		if message.id == jt808Location {
+			var report *report
+			if report, err = handler.parseLocation(message.phone, message.body); err == nil {
+				reports = append(reports, report)
+			}
+		} else {
+			reports, err = handler.parseBatchLocation(message.phone, message.body)
+		}
+		if err != nil {
+			log.Debugf("invalid JT/T 808 location from %s: %s", message.phone, err)
+			return message.respond(session.nextSerial(), jt808ResultInvalid)
+		}
+
+		for _, report := range reports {
+			handler.tracker.track(ProtocolJT808, report)
+		}
+		return message.respond(session.nextSerial(), jt808ResultOk)
+	}
+
+	return message.respond(session.nextSerial(), jt808ResultUnsupported)
+}
+
+// provisioned tells whether the terminal of phone can register and authenticate
+func (handler *jt808Handler) provisioned(phone string) bool {
+	return handler.devices == nil || handler.devices[strings.TrimLeft(phone, "0")]
+}
+
+// authCode derives the authentication code of a terminal from its phone number
+func (handler *jt808Handler) authCode(phone string) string {
+	mac := hmac.New(sha256.New, handler.authSecret)
+	mac.Write([]byte(phone))
+
+	return hex.EncodeToString(mac.Sum(nil))[:16]
+}
+
+// parseLocation parses location information, i.e., the basic information followed by additional items, among
+// which the LBS item carries stations seen
+func (handler *jt808Handler) parseLocation(phone string, data []byte) (*report, error) {
+	if len(data) < jt808LocationLength {
+		return nil, fmt.Errorf("location of %d bytes, expecting at least %d", len(data), jt808LocationLength)
+	}
+
+	status := binary.BigEndian.Uint32(data[4:])
+	report := &report{
+		deviceId: phone,
+		gnss:     status&jt808Positioned != 0,
+		lat:      float64(binary.BigEndian.Uint32(data[8:])) / 1e6,
+		lng:      float64(binary.BigEndian.Uint32(data[12:])) / 1e6,
+	}
+	if status&jt808South != 0 {
+		report.lat = -report.lat
+	}
+	if status&jt808West != 0 {
+		report.lng = -report.lng
+	}
+	if t, err := time.ParseInLocation("060102150405", decodeBCD(data[22:28]), jt808Zone); err == nil {
+		report.time = t
+	} else {
+		report.time = time.Now()
+	}
+
+	for items := data[jt808LocationLength:]; len(items) > 0; {
+		if len(items) < 2 || len(items) < 2+int(items[1]) {
+			return nil, errors.New("truncated additional item")
+		}
+		id, item := items[0], items[2:2+int(items[1])]
+		items = items[2+len(item):]
+
+		if id == handler.lbsItemId {
+			signals, err := parseJT808Cells(item)
+			if err != nil {
+				return nil, err
+			}
+			report.signals = append(report.signals, signals...)
+		}
+	}
+
+	return report, nil
+}
+
+// parseBatchLocation parses batched location information, i.e., count WORD, type BYTE, followed by each location
+// information prefixed by its length WORD
+func (handler *jt808Handler) parseBatchLocation(phone string, data []byte) ([]*report, error) {
+	if len(data) < 3 {
+		return nil, errors.New("truncated batch")
+	}
+
+	count := int(binary.BigEndian.Uint16(data))
+	reports := make([]*report, 0, count)
+	for data = data[3:]; len(reports) < count; {
+		if len(data) < 2 || len(data) < 2+int(binary.BigEndian.Uint16(data)) {
+			return nil, errors.New("truncated batch")
+		}
+		length := int(binary.BigEndian.Uint16(data))
+
+		report, err := handler.parseLocation(phone, data[2:2+length])
+		if err != nil {
+			return nil, err
+		}
+		reports = append(reports, report)
+		data = data[2+length:]
+	}
+
+	return reports, nil
+}
+
+// parseJT808Cells parses the LBS item, i.e., count BYTE followed by cells, each of which is made up of radio BYTE
+// (1 GSM, 2 UMTS, 3 LTE and 4 NR), MCC WORD, MNC WORD, LAC DWORD, CI DWORD and signal strength BYTE (the absolute
+// value of RSSI in dBm, 0 if unknown). Cells of unknown radio types are skipped.
+func parseJT808Cells(item []byte) ([]apis.Signal, error) {
+	if len(item) == 0 || len(item) != 1+int(item[0])*jt808CellLength {
+		return nil, fmt.Errorf("LBS item of %d bytes doesn't match its count of cells", len(item))
+	}
+
+	var signals []apis.Signal
+	for cell := item[1:]; len(cell) > 0; cell = cell[jt808CellLength:] {
+		radio, ok := jt808Radios[cell[0]]
+		if !ok {
+			continue
+		}
+
+		signals = append(signals, apis.Signal{
+			Radio:       radio,
+			Mcc:         strconv.Itoa(int(binary.BigEndian.Uint16(cell[1:]))),
+			Mnc:         strconv.Itoa(int(binary.BigEndian.Uint16(cell[3:]))),
+			Lac:         strconv.FormatUint(uint64(binary.BigEndian.Uint32(cell[5:])), 10),
+			Cid:         strconv.FormatUint(uint64(binary.BigEndian.Uint32(cell[9:])), 10),
+			Strength:    -float64(cell[13]),
+			HasStrength: true,
+		})
+	}
+
+	return signals, nil
+}
+
+// is2019 tells whether the message is of the 2019 revision, whose header carries the version and a longer phone
+func (message *jt808Message) is2019() bool {
+	return message.props&jt808Version2019 != 0
+}
+
+// authCode reads the authentication code carried by an authentication message, which is prefixed by its length
+// in the 2019 revision and followed by IMEI and software version
+func (message *jt808Message) authCode() (string, error) {
+	if !message.is2019() {
+		return string(message.body), nil
+	}
+
+	if len(message.body) == 0 || len(message.body) < 1+int(message.body[0]) {
+		return "", errors.New("truncated authentication code")
+	}
+	return string(message.body[1 : 1+int(message.body[0])]), nil
+}
+
+// respond encodes the platform general response to the message
+func (message *jt808Message) respond(serial uint16, result byte) []byte {
+	body := make([]byte, 5)
+	binary.BigEndian.PutUint16(body, message.serial)
+	binary.BigEndian.PutUint16(body[2:], message.id)
+	body[4] = result
+
+	return message.reply(jt808PlatformResponse, serial, body)
+}
+
+// reply encodes a message of id sent to the terminal of the message, in the same revision
+func (message *jt808Message) reply(id uint16, serial uint16, body []byte) []byte {
+	data := make([]byte, 4, 17+len(body)+1)
+	binary.BigEndian.PutUint16(data, id)
+	binary.BigEndian.PutUint16(data[2:], uint16(len(body))|message.props&jt808Version2019)
+	if message.is2019() {
+		data = append(data, message.version)
+	}
+	data = append(data, message.phoneBCD...)
+	data = append(data, byte(serial>>8), byte(serial))
+	data = append(data, body...)
+	data = append(data, jt808Checksum(data))
+
+	return escapeJT808Frame(data)
+}
+
+// readJT808Frame reads the next frame, unescaped and without flags
+func readJT808Frame(reader *bufio.Reader) ([]byte, error) {
+	// skip whatever precedes the leading flag
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == jt808Flag {
+			break
+		}
+	}
+
+	var frame []byte
+	for {
+		b, err := reader.ReadByte()
+		if err != nil {
+			return nil, err
+		}
+		if b == jt808Flag {
+			if len(frame) == 0 {
+				// the trailing flag of a previous frame taken as the leading one
+				continue
+			}
+			return unescapeJT808Frame(frame)
+		}
+		if len(frame) >= jt808MaxFrameLength {
+			return nil, errJT808FrameTooLong
+		}
+		frame = append(frame, b)
+	}
+}
+
+// decodeJT808Message decodes an unescaped frame, i.e., header, body and checksum
+func decodeJT808Message(frame []byte) (*jt808Message, error) {
+	if len(frame) < 13 {
+		return nil, fmt.Errorf("frame of %d bytes is too short", len(frame))
+	}
+	data, checksum := frame[:len(frame)-1], frame[len(frame)-1]
+	if jt808Checksum(data) != checksum {
+		return nil, errors.New("checksum mismatch")
+	}
+
+	message := &jt808Message{
+		id:    binary.BigEndian.Uint16(data),
+		props: binary.BigEndian.Uint16(data[2:]),
+	}
+	offset, phoneLength := 4, 6
+	if message.is2019() {
+		message.version = data[4]
+		offset, phoneLength = 5, 10
+	}
+
+	headerLength := offset + phoneLength + 2
+	if message.props&jt808Subpackage != 0 {
+		headerLength += 4
+	}
+	if len(data) < headerLength {
+		return nil, errors.New("truncated header")
+	}
+	message.phoneBCD = data[offset : offset+phoneLength]
+	message.phone = decodeBCD(message.phoneBCD)
+	message.serial = binary.BigEndian.Uint16(data[offset+phoneLength:])
+	message.body = data[headerLength:]
+
+	if length := int(message.props & jt808BodyLengthMask); length != len(message.body) {
+		return nil, fmt.Errorf("body of %d bytes, expecting %d", len(message.body), length)
+	}
+
+	return message, nil
+}
+
+func escapeJT808Frame(data []byte) []byte {
+	frame := make([]byte, 0, len(data)+2)
+	frame = append(frame, jt808Flag)
+	for _, b := range data {
+		switch b {
+		case jt808Flag:
+			frame = append(frame, jt808Escape, 0x02)
+		case jt808Escape:
+			frame = append(frame, jt808Escape, 0x01)
+		default:
+			frame = append(frame, b)
+		}
+	}
+
+	return append(frame, jt808Flag)
+}
+
+func unescapeJT808Frame(frame []byte) ([]byte, error) {
+	data := make([]byte, 0, len(frame))
+	for i := 0; i < len(frame); i++ {
+		if frame[i] != jt808Escape {
+			data = append(data, frame[i])
+			continue
+		}
+
+		if i++; i == len(frame) {
+			return nil, errors.New("truncated escape")
+		}
+		switch frame[i] {
+		case 0x01:
+			data = append(data, jt808Escape)
+		case 0x02:
+			data = append(data, jt808Flag)
+		default:
+			return nil, fmt.Errorf("invalid escape 0x7d 0x%02x", frame[i])
+		}
+	}
+
+	return data, nil
+}
+
+// jt808Checksum XORs all bytes of header and body
+func jt808Checksum(data []byte) byte {
+	var checksum byte
+	for _, b := range data {
+		checksum ^= b
+	}
+
+	return checksum
+}
+
+// decodeBCD decodes BCD encoded digits, e.g., phone numbers and times
+func decodeBCD(data []byte) string {
+	const digits = "0123456789abcdef"
+
+	decoded := make([]byte, 0, len(data)*2)
+	for _, b := range data {
+		decoded = append(decoded, digits[b>>4], digits[b&0x0f])
+	}
+
+	return string(decoded)
+}
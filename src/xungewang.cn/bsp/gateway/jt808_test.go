@@ -0,0 +1,259 @@
+package gateway
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+	"xungewang.cn/bsp/apis"
+	"xungewang.cn/bsp/models"
+)
+
+// terminals of the 2013 and 2019 revisions, whose phone numbers are 013912345678 and 00000000013912345678
+var (
+	jt808Terminal2013 = &jt808Message{phoneBCD: []byte{0x01, 0x39, 0x12, 0x34, 0x56, 0x78}}
+	jt808Terminal2019 = &jt808Message{props: jt808Version2019, version: 1,
+		phoneBCD: []byte{0x00, 0x00, 0x00, 0x00, 0x01, 0x39, 0x12, 0x34, 0x56, 0x78}}
+)
+
+func TestJT808Frame(t *testing.T) {
+	data := []byte{0x30, 0x7e, 0x08, 0x7d, 0x55}
+	frame := escapeJT808Frame(data)
+	if expected := []byte{0x7e, 0x30, 0x7d, 0x02, 0x08, 0x7d, 0x01, 0x55, 0x7e}; !bytes.Equal(frame, expected) {
+		t.Errorf("escaped as % x, expecting % x", frame, expected)
+	}
+
+	// garbage before the leading flag is skipped, and so are adjacent flags
+	stream := append([]byte{0x01, 0x02}, frame...)
+	stream = append(stream, 0x7e, 0x7e, 0x7d, 0x03, 0x7e)
+	reader := bufio.NewReader(bytes.NewReader(stream))
+	if unescaped, err := readJT808Frame(reader); err != nil || !bytes.Equal(unescaped, data) {
+		t.Errorf("unescaped as % x, error %v", unescaped, err)
+	}
+	if _, err := readJT808Frame(reader); err == nil {
+		t.Error("invalid escape should fail")
+	}
+
+	if checksum := jt808Checksum([]byte{0x01, 0x02, 0x04}); checksum != 0x07 {
+		t.Errorf("unexpected checksum %#x", checksum)
+	}
+}
+
+func TestDecodeJT808Message(t *testing.T) {
+	for _, terminal := range []*jt808Message{jt808Terminal2013, jt808Terminal2019} {
+		frame, _ := unescapeJT808Frame(trimFlags(terminal.reply(jt808Heartbeat, 0x7e7d, []byte{0x7e})))
+		message, err := decodeJT808Message(frame)
+		if err != nil {
+			t.Fatalf("failed to decode % x: %s", frame, err)
+		}
+		if message.id != jt808Heartbeat || message.serial != 0x7e7d || !bytes.Equal(message.body, []byte{0x7e}) ||
+			message.is2019() != terminal.is2019() || message.version != terminal.version {
+			t.Errorf("unexpected message %+v", message)
+		}
+		if expected := decodeBCD(terminal.phoneBCD); message.phone != expected || len(expected) != 2*len(terminal.phoneBCD) {
+			t.Errorf("phone %s, expecting %s", message.phone, expected)
+		}
+
+		frame[len(frame)-1]++
+		if _, err := decodeJT808Message(frame); err == nil {
+			t.Error("checksum mismatch should fail")
+		}
+	}
+}
+
+func TestJT808Server(t *testing.T) {
+	for _, terminal := range []*jt808Message{jt808Terminal2013, jt808Terminal2019} {
+		recorder := &mockPositionRecorder{}
+		client, stop := startServer(t, func(tracker *Tracker) *Server {
+			return NewJT808Server("", time.Minute, tracker, "secret", 0xe1, []string{"13912345678"})
+		}, recorder)
+
+		// locations before authentication are rejected
+		location := encodeJT808Location(0, encodeJT808LbsItem(3, 6334, 127502337, 85))
+		expectJT808Response(t, client, terminal.reply(jt808Location, 1, location), jt808Location, 1, jt808ResultFailure)
+
+		// registration replies the authentication code, and authentication with a wrong code fails
+		response := exchangeJT808(t, client, terminal.reply(jt808Register, 2, make([]byte, 37)))
+		if response.id != jt808RegisterResponse || binary.BigEndian.Uint16(response.body) != 2 || response.body[2] != jt808ResultOk {
+			t.Fatalf("unexpected registration response %+v", response)
+		}
+		code := response.body[3:]
+		if len(code) != 16 {
+			t.Errorf("unexpected authentication code %s", code)
+		}
+		expectJT808Response(t, client, terminal.reply(jt808Authenticate, 3, jt808AuthBody(terminal, []byte("wrong"))),
+			jt808Authenticate, 3, jt808ResultFailure)
+		expectJT808Response(t, client, terminal.reply(jt808Authenticate, 4, jt808AuthBody(terminal, code)),
+			jt808Authenticate, 4, jt808ResultOk)
+		expectJT808Response(t, client, terminal.reply(jt808Heartbeat, 5, nil), jt808Heartbeat, 5, jt808ResultOk)
+
+		// a location carrying stations is positioned by them, while a GNSS fix is recorded as it is. Those failed
+		// to be positioned are still acknowledged.
+		expectJT808Response(t, client, terminal.reply(jt808Location, 6, location), jt808Location, 6, jt808ResultOk)
+		batch := []byte{0, 3, 1}
+		for _, location := range [][]byte{
+			encodeJT808Location(jt808Positioned|jt808West, nil),
+			encodeJT808Location(0, encodeJT808LbsItem(1, 32838, 1, 0)),
+			encodeJT808Location(0, nil),
+		} {
+			batch = append(batch, byte(len(location)>>8), byte(len(location)))
+			batch = append(batch, location...)
+		}
+		expectJT808Response(t, client, terminal.reply(jt808BatchLocation, 7, batch), jt808BatchLocation, 7, jt808ResultOk)
+
+		// malformed locations and unknown messages are told
+		expectJT808Response(t, client, terminal.reply(jt808Location, 8, location[:20]), jt808Location, 8, jt808ResultInvalid)
+		expectJT808Response(t, client, terminal.reply(0x0900, 9, nil), 0x0900, 9, jt808ResultUnsupported)
+
+		// subpackages are refused, rather than recorded as the whole message
+		expectJT808Response(t, client, encodeJT808Subpackage(terminal, jt808Location, 10, 2, 1, location),
+			jt808Location, 10, jt808ResultUnsupported)
+
+		stop()
+
+		positions := recorder.recorded()
+		if len(positions) != 2 {
+			t.Fatalf("unexpected positions %v", positions)
+		}
+		phone := decodeBCD(terminal.phoneBCD)
+		lbs, gnss := positions[0], positions[1]
+		if lbs.DeviceId != phone || lbs.Protocol != ProtocolJT808 || lbs.Source != models.PositionSourceLBS ||
+			lbs.Lat != 30.5 || lbs.Accuracy != 500 || !lbs.LocatedAt.Equal(time.Date(2024, 5, 1, 1, 30, 0, 0, time.UTC)) {
+			t.Errorf("unexpected LBS position %+v", lbs)
+		}
+		if gnss.Source != models.PositionSourceGNSS || gnss.Lat != 30.123456 || gnss.Lng != -104.654321 || gnss.Accuracy != 0 {
+			t.Errorf("unexpected GNSS position %+v", gnss)
+		}
+	}
+}
+
+func TestJT808Server_Provisioned(t *testing.T) {
+	for _, devices := range [][]string{{"13800000000"}, {}} {
+		client, stop := startServer(t, func(tracker *Tracker) *Server {
+			return NewJT808Server("", time.Minute, tracker, "secret", 0xe1, devices)
+		}, &mockPositionRecorder{})
+
+		// terminals not provisioned are refused to register, and can't authenticate even with the right code
+		response := exchangeJT808(t, client, jt808Terminal2013.reply(jt808Register, 1, make([]byte, 37)))
+		if response.id != jt808RegisterResponse || len(response.body) != 3 || response.body[2] != jt808RegisterUnknownTerminal {
+			t.Errorf("unexpected registration response %+v", response)
+		}
+		code := (&jt808Handler{authSecret: []byte("secret")}).authCode(decodeBCD(jt808Terminal2013.phoneBCD))
+		expectJT808Response(t, client, jt808Terminal2013.reply(jt808Authenticate, 2, []byte(code)),
+			jt808Authenticate, 2, jt808ResultFailure)
+
+		stop()
+	}
+
+	// any terminal can register if the policy is open
+	client, stop := startServer(t, func(tracker *Tracker) *Server {
+		return NewJT808Server("", time.Minute, tracker, "secret", 0xe1, nil)
+	}, &mockPositionRecorder{})
+	defer stop()
+	response := exchangeJT808(t, client, jt808Terminal2019.reply(jt808Register, 1, make([]byte, 37)))
+	if response.id != jt808RegisterResponse || response.body[2] != jt808ResultOk || len(response.body) != 3+16 {
+		t.Errorf("unexpected registration response %+v", response)
+	}
+}
+
+func TestParseJT808Cells(t *testing.T) {
+	item := encodeJT808LbsItem(3, 6334, 127502337, 85)
+	item = append(item, encodeJT808LbsItem(9, 1, 1, 0)[1:]...)
+	item[0] = 2
+	signals, err := parseJT808Cells(item)
+	if err != nil || len(signals) != 1 {
+		t.Fatalf("unexpected signals %v, error %v", signals, err)
+	}
+	expected := apis.Signal{Radio: apis.RadioLTE, Mcc: "460", Mnc: "0", Lac: "6334", Cid: "127502337", Strength: -85,
+		HasStrength: true}
+	if signals[0] != expected {
+		t.Errorf("got %v, expecting %v", signals[0], expected)
+	}
+
+	if _, err := parseJT808Cells(item[:20]); err == nil {
+		t.Error("truncated item should fail")
+	}
+}
+
+func exchangeJT808(t *testing.T, client net.Conn, frame []byte) *jt808Message {
+	if _, err := client.Write(frame); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := readJT808Frame(bufio.NewReader(&byteReader{client}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	message, err := decodeJT808Message(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return message
+}
+
+func expectJT808Response(t *testing.T, client net.Conn, frame []byte, id, serial uint16, result byte) {
+	response := exchangeJT808(t, client, frame)
+	if response.id != jt808PlatformResponse || len(response.body) != 5 || binary.BigEndian.Uint16(response.body) != serial ||
+		binary.BigEndian.Uint16(response.body[2:]) != id || response.body[4] != result {
+		t.Errorf("unexpected response % x to message %#04x, expecting result %d", response.body, id, result)
+	}
+}
+
+// encodeJT808Location encodes a location taken at 2024-05-01 09:30:00 GMT+8, at (30.123456, 104.654321) if positioned
+// encodeJT808Subpackage encodes the index-th (1 based) of total packages of a message sent by the terminal
+func encodeJT808Subpackage(terminal *jt808Message, id, serial, total, index uint16, body []byte) []byte {
+	frame, _ := unescapeJT808Frame(trimFlags(terminal.reply(id, serial, body)))
+	header := 4 + len(terminal.phoneBCD) + 2
+	if terminal.is2019() {
+		header++
+	}
+	binary.BigEndian.PutUint16(frame[2:], binary.BigEndian.Uint16(frame[2:])|jt808Subpackage)
+
+	data := append([]byte{}, frame[:header]...)
+	data = append(data, byte(total>>8), byte(total), byte(index>>8), byte(index))
+	data = append(data, body...)
+	data = append(data, jt808Checksum(data))
+
+	return escapeJT808Frame(data)
+}
+
+func encodeJT808Location(status uint32, items ...[]byte) []byte {
+	location := make([]byte, jt808LocationLength)
+	binary.BigEndian.PutUint32(location[4:], status)
+	binary.BigEndian.PutUint32(location[8:], 30123456)
+	binary.BigEndian.PutUint32(location[12:], 104654321)
+	copy(location[22:], []byte{0x24, 0x05, 0x01, 0x09, 0x30, 0x00})
+	for _, item := range items {
+		if item != nil {
+			location = append(location, 0xe1, byte(len(item)))
+			location = append(location, item...)
+		}
+	}
+
+	return location
+}
+
+// encodeJT808LbsItem encodes an LBS item of a single cell of MCC 460 and MNC 0
+func encodeJT808LbsItem(radio byte, lac, ci uint32, strength byte) []byte {
+	item := []byte{1, radio, 0x01, 0xcc, 0, 0}
+	item = append(item, byte(lac>>24), byte(lac>>16), byte(lac>>8), byte(lac))
+	item = append(item, byte(ci>>24), byte(ci>>16), byte(ci>>8), byte(ci))
+
+	return append(item, strength)
+}
+
+func jt808AuthBody(terminal *jt808Message, code []byte) []byte {
+	if !terminal.is2019() {
+		return code
+	}
+
+	body := append([]byte{byte(len(code))}, code...)
+	return append(body, make([]byte, 15+20)...)
+}
+
+func trimFlags(frame []byte) []byte {
+	return frame[1 : len(frame)-1]
+}
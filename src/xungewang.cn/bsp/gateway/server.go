@@ -0,0 +1,217 @@
+// Package gateway speaks protocols of trackers over raw TCP, e.g., JT/T 808, and positions trackers that report
+// stations they see rather than GNSS fixes (e.g., indoors), the same way '/api/position' does.
+package gateway
+
+import (
+	"bufio"
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"net"
+	"os"
+	"sync"
+	"syscall"
+	"time"
+)
+
+const (
+	// time allowed to write a response to a tracker
+	writeTimeout = 10 * time.Second
+
+	// delay before retrying to listen, which doubles after each failure up to the maximum
+	listenRetryDelay    = 100 * time.Millisecond
+	maxListenRetryDelay = 10 * time.Second
+)
+
+type (
+	// Server accepts TCP connections of trackers, each of which is served by the protocol in its own goroutine
+	Server struct {
+		protocol    string
+		addr        string
+		idleTimeout time.Duration
+		serve       serveFunc
+
+		mutex    sync.Mutex
+		listener net.Listener
+		sessions map[*session]struct{}
+		closed   bool
+		done     sync.WaitGroup
+	}
+
+	// serveFunc reads messages of a session and responds to them, until the connection fails or gets closed
+	serveFunc func(session *session) error
+
+	// session is a connection of a tracker, along with what's known about the tracker
+	session struct {
+		conn        net.Conn
+		reader      *bufio.Reader
+		idleTimeout time.Duration
+
+		// id of the tracker, empty until it's identified (e.g., by registration or login)
+		deviceId      string
+		authenticated bool
+
+		// serial number of the last message sent to the tracker
+		serial uint16
+	}
+)
+
+// ListenAndServe listens at the address of the server, and serves connections until Close is called. Listening is
+// retried while the address is in use (e.g., held by the process replaced by a restart), until it succeeds or the
+// server is closed. Other failures (e.g., an invalid address) are returned.
+func (server *Server) ListenAndServe() error {
+	delay := listenRetryDelay
+	for {
+		listener, err := net.Listen("tcp", server.addr)
+		if err == nil {
+			return server.Serve(listener)
+		}
+		if server.isClosed() {
+			return nil
+		}
+		if !isAddrInUse(err) {
+			return err
+		}
+
+		log.Warnf("%s gateway failed to listen at %s, retrying in %s: %s", server.protocol, server.addr, delay, err)
+		time.Sleep(delay)
+		if delay *= 2; delay > maxListenRetryDelay {
+			delay = maxListenRetryDelay
+		}
+	}
+}
+
+// isAddrInUse tells whether err is of listening at an address in use
+func isAddrInUse(err error) bool {
+	if opErr, ok := err.(*net.OpError); ok {
+		err = opErr.Err
+	}
+	if syscallErr, ok := err.(*os.SyscallError); ok {
+		err = syscallErr.Err
+	}
+
+	return err == syscall.EADDRINUSE
+}
+
+// Serve serves connections accepted by listener, until Close is called
+func (server *Server) Serve(listener net.Listener) error {
+	server.mutex.Lock()
+	if server.closed {
+		server.mutex.Unlock()
+		return listener.Close()
+	}
+	server.listener = listener
+	server.mutex.Unlock()
+
+	log.Infof("%s gateway starts at %s", server.protocol, listener.Addr())
+	for {
+		conn, err := listener.Accept()
+		if err != nil {
+			if server.isClosed() {
+				return nil
+			}
+			if netError, ok := err.(net.Error); ok && netError.Temporary() {
+				log.Warnf("%s gateway failed to accept connection: %s", server.protocol, err)
+				time.Sleep(100 * time.Millisecond)
+				continue
+			}
+			return err
+		}
+
+		session := &session{conn: conn, reader: bufio.NewReader(conn), idleTimeout: server.idleTimeout}
+		if !server.track(session) {
+			conn.Close()
+			return nil
+		}
+		go server.handle(session)
+	}
+}
+
+// Close stops accepting connections, closes those connected, and waits until they're done
+func (server *Server) Close() error {
+	server.mutex.Lock()
+	server.closed = true
+	var err error
+	if server.listener != nil {
+		err = server.listener.Close()
+	}
+	for session := range server.sessions {
+		session.conn.Close()
+	}
+	server.mutex.Unlock()
+
+	server.done.Wait()
+	return err
+}
+
+func (server *Server) handle(session *session) {
+	defer server.done.Done()
+	defer server.untrack(session)
+	defer session.conn.Close()
+
+	log.Debugf("%s connection from %s", server.protocol, session.conn.RemoteAddr())
+	if err := server.serve(session); err != nil && err != io.EOF && !server.isClosed() {
+		log.Debugf("%s connection from %s (device %s) closed: %s", server.protocol, session.conn.RemoteAddr(),
+			session.deviceId, err)
+	}
+}
+
+// track adds session to those connected, which returns false if the server is closed
+func (server *Server) track(session *session) bool {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	if server.closed {
+		return false
+	}
+	server.sessions[session] = struct{}{}
+	server.done.Add(1)
+
+	return true
+}
+
+func (server *Server) untrack(session *session) {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	delete(server.sessions, session)
+}
+
+func (server *Server) isClosed() bool {
+	server.mutex.Lock()
+	defer server.mutex.Unlock()
+
+	return server.closed
+}
+
+// touch extends the read deadline, which should be called before reading a message
+func (session *session) touch() {
+	session.conn.SetReadDeadline(time.Now().Add(session.idleTimeout))
+}
+
+// write writes data (if any) to the tracker
+func (session *session) write(data []byte) error {
+	if len(data) == 0 {
+		return nil
+	}
+
+	session.conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+	_, err := session.conn.Write(data)
+
+	return err
+}
+
+// nextSerial returns serial number of the next message sent to the tracker
+func (session *session) nextSerial() uint16 {
+	session.serial++
+	return session.serial
+}
+
+func newServer(protocol, addr string, idleTimeout time.Duration, serve serveFunc) *Server {
+	return &Server{
+		protocol:    protocol,
+		addr:        addr,
+		idleTimeout: idleTimeout,
+		serve:       serve,
+		sessions:    map[*session]struct{}{},
+	}
+}
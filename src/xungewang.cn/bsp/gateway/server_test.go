@@ -0,0 +1,139 @@
+package gateway
+
+import (
+	"io"
+	"net"
+	"sync"
+	"testing"
+	"time"
+	"xungewang.cn/bsp/apis"
+	"xungewang.cn/bsp/app"
+	"xungewang.cn/bsp/errors"
+	"xungewang.cn/bsp/models"
+)
+
+type mockPositionService struct{}
+
+// ComputePosition positions every request at the same place, except those seeing cell 1 which are not found
+func (service *mockPositionService) ComputePosition(ctx app.RequestScope, request *apis.PositionRequest) (*apis.PositionResult, error) {
+	for _, signal := range request.Signals {
+		if signal.Cid == "1" {
+			return nil, errors.NotFound("signals")
+		}
+	}
+
+	return &apis.PositionResult{Lat: 30.5, Lng: 104.5, Accuracy: 500}, nil
+}
+
+type mockPositionRecorder struct {
+	mutex     sync.Mutex
+	positions []*models.DevicePosition
+}
+
+func (recorder *mockPositionRecorder) Record(ctx app.RequestScope, position *models.DevicePosition) error {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	recorder.positions = append(recorder.positions, position)
+	return nil
+}
+
+func (recorder *mockPositionRecorder) recorded() []*models.DevicePosition {
+	recorder.mutex.Lock()
+	defer recorder.mutex.Unlock()
+
+	return append([]*models.DevicePosition(nil), recorder.positions...)
+}
+
+// startServer starts a server created by newServer at a random port, which returns a client connected to it and
+// a function closing both
+func startServer(t *testing.T, newServer func(tracker *Tracker) *Server, recorder *mockPositionRecorder) (net.Conn, func()) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	server := newServer(NewTracker(nil, &mockPositionService{}, recorder))
+	served := make(chan error)
+	go func() { served <- server.Serve(listener) }()
+
+	client, err := net.Dial("tcp", listener.Addr().String())
+	if err != nil {
+		t.Fatal(err)
+	}
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+
+	return client, func() {
+		client.Close()
+		server.Close()
+		if err := <-served; err != nil {
+			t.Errorf("unexpected error serving: %s", err)
+		}
+	}
+}
+
+// byteReader reads a byte at a time, so that bytes following a frame are left to the next read
+type byteReader struct {
+	conn net.Conn
+}
+
+func (reader *byteReader) Read(p []byte) (int, error) {
+	return reader.conn.Read(p[:1])
+}
+
+func TestServer_ListenAndServe_Retry(t *testing.T) {
+	// the address is held by someone else for a while, e.g., the process replaced by a restart
+	holder, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := holder.Addr().String()
+
+	server := newServer("test", addr, time.Minute, func(session *session) error {
+		_, err := session.conn.Write([]byte("ok"))
+		return err
+	})
+	served := make(chan error)
+	go func() { served <- server.ListenAndServe() }()
+
+	time.Sleep(3 * listenRetryDelay)
+	holder.Close()
+
+	var client net.Conn
+	for deadline := time.Now().Add(5 * time.Second); client == nil && time.Now().Before(deadline); {
+		if client, err = net.Dial("tcp", addr); err != nil {
+			time.Sleep(50 * time.Millisecond)
+		}
+	}
+	if client == nil {
+		t.Fatal("server never listened once the address is released")
+	}
+	client.SetDeadline(time.Now().Add(5 * time.Second))
+	response := make([]byte, 2)
+	if _, err := io.ReadFull(client, response); err != nil || string(response) != "ok" {
+		t.Errorf("unexpected response %q, error %v", response, err)
+	}
+	client.Close()
+
+	server.Close()
+	if err := <-served; err != nil {
+		t.Errorf("unexpected error serving: %s", err)
+	}
+}
+
+func TestServer_ListenAndServe_Fail(t *testing.T) {
+	// an invalid address never gets listened, which is returned rather than retried
+	server := newServer("test", "127.0.0.1:70000", time.Minute, func(session *session) error { return nil })
+	served := make(chan error)
+	go func() { served <- server.ListenAndServe() }()
+
+	select {
+	case err := <-served:
+		if err == nil || isAddrInUse(err) {
+			t.Errorf("error of the invalid address expected, got %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		server.Close()
+		t.Fatal("listening at an invalid address is retried")
+	}
+}
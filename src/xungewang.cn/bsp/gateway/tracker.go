@@ -0,0 +1,86 @@
+package gateway
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"time"
+	"xungewang.cn/bsp/apis"
+	"xungewang.cn/bsp/app"
+	"xungewang.cn/bsp/models"
+)
+
+type (
+	// contract of positioning trackers from stations, which services.positionService implements
+	positionService interface {
+		ComputePosition(ctx app.RequestScope, request *apis.PositionRequest) (*apis.PositionResult, error)
+	}
+
+	// contract of recording positions of trackers, which services.devicePositionService implements
+	positionRecorder interface {
+		Record(ctx app.RequestScope, position *models.DevicePosition) error
+	}
+
+	// Tracker records positions reported by trackers, whatever protocol they speak. Those reporting stations
+	// rather than GNSS fixes are positioned first.
+	Tracker struct {
+		ctx      app.RequestScope
+		service  positionService
+		recorder positionRecorder
+	}
+
+	// report is what a tracker reports, with either a GNSS fix or stations seen
+	report struct {
+		deviceId string
+
+		// when the report is taken
+		time time.Time
+
+		// WGS-84 coordinates of the GNSS fix, if gnss is true
+		gnss     bool
+		lat, lng float64
+
+		// stations seen, if there's no GNSS fix
+		signals []apis.Signal
+	}
+)
+
+// track records the position of a report, which returns the position recorded. Reports positioned neither by
+// GNSS nor by stations are dropped, in which case nil is returned.
+func (tracker *Tracker) track(protocol string, report *report) *models.DevicePosition {
+	position := &models.DevicePosition{
+		DeviceId:   report.deviceId,
+		Protocol:   protocol,
+		LocatedAt:  report.time,
+		ReceivedAt: time.Now(),
+	}
+
+	if report.gnss {
+		position.Source, position.Lat, position.Lng = models.PositionSourceGNSS, report.lat, report.lng
+	} else if len(report.signals) > 0 {
+		request := &apis.PositionRequest{Signals: report.signals}
+		if err := request.Validate(); err != nil {
+			log.Debugf("invalid signals from %s device %s: %s", protocol, report.deviceId, err)
+			return nil
+		}
+
+		result, err := tracker.service.ComputePosition(tracker.ctx, request)
+		if err != nil {
+			log.Debugf("failed to position %s device %s: %s", protocol, report.deviceId, err)
+			return nil
+		}
+		position.Source, position.Lat, position.Lng, position.Accuracy = models.PositionSourceLBS,
+			result.Lat, result.Lng, result.Accuracy
+	} else {
+		return nil
+	}
+
+	if err := tracker.recorder.Record(tracker.ctx, position); err != nil {
+		log.Errorf("failed to record position of %s device %s: %s", protocol, report.deviceId, err)
+	}
+
+	return position
+}
+
+// NewTracker creates a Tracker, which positions trackers with service and records their positions with recorder
+func NewTracker(ctx app.RequestScope, service positionService, recorder positionRecorder) *Tracker {
+	return &Tracker{ctx: ctx, service: service, recorder: recorder}
+}
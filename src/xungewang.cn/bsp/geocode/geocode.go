@@ -0,0 +1,208 @@
+// Package geocode resolves addresses (province, city and district) of positions offline, from administrative
+// boundaries loaded into memory.
+//
+// Boundaries are read from a GeoJSON FeatureCollection of Polygon and MultiPolygon features, whose properties
+// carry "name", "level" (one of "province", "city" and "district") and optionally "adcode", as datasets like
+// DataV.GeoAtlas do. Shapefiles can be converted to GeoJSON beforehand, e.g. by `ogr2ogr -f GeoJSON`.
+package geocode
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"os"
+	"xungewang.cn/bsp/coord"
+)
+
+// administrative levels of boundaries
+const (
+	LevelProvince = "province"
+	LevelCity     = "city"
+	LevelDistrict = "district"
+)
+
+// size (in degrees) of grid cells boundaries are indexed by
+const gridSize = 0.5
+
+// levels ranked from the least specific
+var rankOfLevel = map[string]int{LevelProvince: 1, LevelCity: 2, LevelDistrict: 3}
+
+type (
+	// Address is where a position is, administratively. Levels not covered by the boundaries are left empty.
+	Address struct {
+		Province string `json:"province,omitempty"`
+		City     string `json:"city,omitempty"`
+		District string `json:"district,omitempty"`
+
+		// administrative division code of the most specific level found
+		Adcode string `json:"adcode,omitempty"`
+	}
+
+	// Geocoder resolves addresses from boundaries indexed by a grid, i.e., each grid cell lists boundaries whose
+	// bounding boxes overlap it. It's read only once loaded, hence safe for concurrent use.
+	Geocoder struct {
+		coordType  string
+		boundaries []*boundary
+		grid       map[gridCell][]*boundary
+	}
+
+	boundary struct {
+		name   string
+		level  string
+		adcode string
+		bounds bounds
+		// each polygon is made up of an exterior ring followed by holes
+		polygons [][]ring
+	}
+
+	// points of a ring, each as [lng, lat]
+	ring [][2]float64
+
+	bounds struct {
+		minLng, minLat, maxLng, maxLat float64
+	}
+
+	gridCell struct {
+		x, y int
+	}
+)
+
+// Load loads boundaries from a GeoJSON file, whose coordinates are of coordType (WGS-84 if empty)
+func Load(path string, coordType string) (*Geocoder, error) {
+	if _, _, err := coord.Convert(0, 0, coord.WGS84, coordType); err != nil {
+		return nil, err
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	var collection featureCollection
+	if err := json.NewDecoder(file).Decode(&collection); err != nil {
+		return nil, fmt.Errorf("invalid GeoJSON: %s", err)
+	}
+
+	geocoder := &Geocoder{coordType: coordType, grid: map[gridCell][]*boundary{}}
+	for i, feature := range collection.Features {
+		boundary, err := feature.toBoundary()
+		if err != nil {
+			return nil, fmt.Errorf("feature %d: %s", i, err)
+		}
+		if boundary != nil {
+			geocoder.add(boundary)
+		}
+	}
+	if len(geocoder.boundaries) == 0 {
+		return nil, fmt.Errorf("no boundaries of provinces, cities or districts found in %s", path)
+	}
+
+	return geocoder, nil
+}
+
+// Reverse resolves the address of a WGS-84 position, nil if it's outside all boundaries
+func (geocoder *Geocoder) Reverse(lat, lng float64) *Address {
+	lat, lng, _ = coord.Convert(lat, lng, coord.WGS84, geocoder.coordType)
+
+	var address *Address
+	adcodeLevel := ""
+	for _, boundary := range geocoder.grid[cellOf(lng, lat)] {
+		if !boundary.contains(lng, lat) {
+			continue
+		}
+
+		if address == nil {
+			address = &Address{}
+		}
+		switch boundary.level {
+		case LevelProvince:
+			address.Province = boundary.name
+		case LevelCity:
+			address.City = boundary.name
+		case LevelDistrict:
+			address.District = boundary.name
+		}
+		if boundary.adcode != "" && moreSpecific(boundary.level, adcodeLevel) {
+			address.Adcode, adcodeLevel = boundary.adcode, boundary.level
+		}
+	}
+
+	return address
+}
+
+// Len tells the number of boundaries loaded
+func (geocoder *Geocoder) Len() int {
+	return len(geocoder.boundaries)
+}
+
+// add indexes boundary into every grid cell its bounding box overlaps
+func (geocoder *Geocoder) add(boundary *boundary) {
+	geocoder.boundaries = append(geocoder.boundaries, boundary)
+
+	from, to := cellOf(boundary.bounds.minLng, boundary.bounds.minLat), cellOf(boundary.bounds.maxLng, boundary.bounds.maxLat)
+	for x := from.x; x <= to.x; x++ {
+		for y := from.y; y <= to.y; y++ {
+			cell := gridCell{x, y}
+			geocoder.grid[cell] = append(geocoder.grid[cell], boundary)
+		}
+	}
+}
+
+// contains tells whether (lng, lat) is inside any polygon of the boundary, i.e., inside its exterior ring but
+// none of its holes
+func (boundary *boundary) contains(lng, lat float64) bool {
+	if !boundary.bounds.contains(lng, lat) {
+		return false
+	}
+
+	for _, polygon := range boundary.polygons {
+		if !polygon[0].contains(lng, lat) {
+			continue
+		}
+
+		inHole := false
+		for _, hole := range polygon[1:] {
+			if hole.contains(lng, lat) {
+				inHole = true
+				break
+			}
+		}
+		if !inHole {
+			return true
+		}
+	}
+
+	return false
+}
+
+// contains tells whether (lng, lat) is inside the ring, by casting a ray towards east and counting crossings
+func (ring ring) contains(lng, lat float64) bool {
+	inside := false
+	for i, j := 0, len(ring)-1; i < len(ring); j, i = i, i+1 {
+		lngI, latI, lngJ, latJ := ring[i][0], ring[i][1], ring[j][0], ring[j][1]
+		if (latI > lat) != (latJ > lat) && lng < (lngJ-lngI)*(lat-latI)/(latJ-latI)+lngI {
+			inside = !inside
+		}
+	}
+
+	return inside
+}
+
+func (bounds *bounds) extend(lng, lat float64) {
+	bounds.minLng, bounds.maxLng = math.Min(bounds.minLng, lng), math.Max(bounds.maxLng, lng)
+	bounds.minLat, bounds.maxLat = math.Min(bounds.minLat, lat), math.Max(bounds.maxLat, lat)
+}
+
+func (bounds *bounds) contains(lng, lat float64) bool {
+	return lng >= bounds.minLng && lng <= bounds.maxLng && lat >= bounds.minLat && lat <= bounds.maxLat
+}
+
+func cellOf(lng, lat float64) gridCell {
+	return gridCell{int(math.Floor(lng / gridSize)), int(math.Floor(lat / gridSize))}
+}
+
+// moreSpecific tells whether level is more specific than the other, e.g., district than city
+func moreSpecific(level, other string) bool {
+	return rankOfLevel[level] > rankOfLevel[other]
+}
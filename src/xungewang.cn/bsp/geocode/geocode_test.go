@@ -0,0 +1,60 @@
+package geocode
+
+import (
+	"testing"
+	"xungewang.cn/bsp/coord"
+)
+
+func TestGeocoder_Reverse(t *testing.T) {
+	geocoder, err := Load("testdata/boundaries.geojson", coord.WGS84)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if geocoder.Len() != 3 {
+		t.Errorf("3 boundaries expected, those of other levels left out, got %d", geocoder.Len())
+	}
+
+	tests := []struct {
+		lat, lng float64
+		expected *Address
+	}{
+		{30.732796, 103.962357, &Address{Province: "四川省", City: "成都市", District: "郫都区", Adcode: "510124"}},
+		{30.5, 104.2, &Address{Province: "四川省", City: "成都市", Adcode: "510100"}},
+		{30.85, 104.35, &Address{Province: "四川省", Adcode: "510000"}}, // in the hole of the city
+		{29.5, 105.5, &Address{Province: "四川省", Adcode: "510000"}},
+		{39.908692, 116.397477, nil},
+	}
+	for _, test := range tests {
+		address := geocoder.Reverse(test.lat, test.lng)
+		if (address == nil) != (test.expected == nil) || (address != nil && *address != *test.expected) {
+			t.Errorf("(%f, %f): expected %v, got %v", test.lat, test.lng, test.expected, address)
+		}
+	}
+}
+
+func TestGeocoder_ReverseGCJ02(t *testing.T) {
+	geocoder, err := Load("testdata/boundaries.geojson", coord.GCJ02)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// close to the west border of the district, which is located in GCJ-02. Positions are converted from WGS-84
+	// before resolved, without which both would be outside.
+	lat, lng := coord.GCJ02ToWGS84(30.75, 103.801)
+	if address := geocoder.Reverse(lat, lng); address == nil || address.District != "郫都区" {
+		t.Errorf("unexpected address: %v", address)
+	}
+	lat, lng = coord.GCJ02ToWGS84(30.75, 103.799)
+	if address := geocoder.Reverse(lat, lng); address == nil || address.District != "" || address.City != "成都市" {
+		t.Errorf("unexpected address: %v", address)
+	}
+}
+
+func TestLoad_Invalid(t *testing.T) {
+	if _, err := Load("testdata/missing.geojson", coord.WGS84); err == nil {
+		t.Error("error expected for missing file")
+	}
+	if _, err := Load("testdata/boundaries.geojson", "mercator"); err == nil {
+		t.Error("error expected for unknown coordinate type")
+	}
+}
@@ -0,0 +1,89 @@
+package geocode
+
+import (
+	"encoding/json"
+	"fmt"
+	"math"
+	"strconv"
+)
+
+type (
+	// GeoJSON structures, with only what matters to us
+	featureCollection struct {
+		Features []feature `json:"features"`
+	}
+
+	feature struct {
+		Properties map[string]interface{} `json:"properties"`
+		Geometry   *geometry              `json:"geometry"`
+	}
+
+	geometry struct {
+		Type        string          `json:"type"`
+		Coordinates json.RawMessage `json:"coordinates"`
+	}
+)
+
+// toBoundary converts the feature into a boundary. It returns nil if the feature is not of a level we're
+// interested in, or comes without polygons.
+func (feature *feature) toBoundary() (*boundary, error) {
+	name, _ := feature.Properties["name"].(string)
+	level, _ := feature.Properties["level"].(string)
+	if name == "" || (level != LevelProvince && level != LevelCity && level != LevelDistrict) || feature.Geometry == nil {
+		return nil, nil
+	}
+
+	var polygons [][][][2]float64
+	switch feature.Geometry.Type {
+	case "Polygon":
+		var polygon [][][2]float64
+		if err := json.Unmarshal(feature.Geometry.Coordinates, &polygon); err != nil {
+			return nil, err
+		}
+		polygons = append(polygons, polygon)
+	case "MultiPolygon":
+		if err := json.Unmarshal(feature.Geometry.Coordinates, &polygons); err != nil {
+			return nil, err
+		}
+	default:
+		return nil, nil
+	}
+
+	boundary := &boundary{
+		name:   name,
+		level:  level,
+		adcode: propertyString(feature.Properties["adcode"]),
+		bounds: bounds{math.Inf(1), math.Inf(1), math.Inf(-1), math.Inf(-1)},
+	}
+	for _, polygon := range polygons {
+		if len(polygon) == 0 {
+			continue
+		}
+
+		rings := make([]ring, len(polygon))
+		for i, points := range polygon {
+			rings[i] = ring(points)
+		}
+		for _, point := range rings[0] {
+			boundary.bounds.extend(point[0], point[1])
+		}
+		boundary.polygons = append(boundary.polygons, rings)
+	}
+	if len(boundary.polygons) == 0 {
+		return nil, fmt.Errorf("%s has no polygons", name)
+	}
+
+	return boundary, nil
+}
+
+// propertyString formats a property, which may be either a string or a number (e.g., adcode)
+func propertyString(value interface{}) string {
+	switch value.(type) {
+	case string:
+		return value.(string)
+	case float64:
+		return strconv.FormatFloat(value.(float64), 'f', -1, 64)
+	}
+
+	return ""
+}
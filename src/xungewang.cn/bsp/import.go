@@ -0,0 +1,107 @@
+package main
+
+import (
+	"bufio"
+	"compress/gzip"
+	"flag"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/go-ozzo/ozzo-dbx"
+	"io"
+	"os"
+	"strings"
+	"xungewang.cn/bsp/app"
+	"xungewang.cn/bsp/coord"
+	"xungewang.cn/bsp/repos"
+	"xungewang.cn/bsp/services"
+)
+
+// runImport imports cell exports of OpenCelliD(cell_towers.csv) or Mozilla Location Service, plain or gzipped,
+// into base stations. e.g.
+//
+//	bsp -c ./config import -mcc 460 -radio gsm,lte cell_towers.csv.gz
+func runImport(args []string) error {
+	flags := flag.NewFlagSet("import", flag.ExitOnError)
+	mcc := flags.String("mcc", "", "comma separated MCCs to import, e.g. 460,461. All if not given")
+	mnc := flags.String("mnc", "", "comma separated MNCs to import, e.g. 0,1. All if not given")
+	radio := flags.String("radio", "", "comma separated radio types to import, among gsm, umts, lte and nr. All if not given")
+	batchSize := flags.Int("batch", services.DefaultImportBatchSize,
+		fmt.Sprintf("number of stations written at a time, up to %d", services.MaxImportBatchSize))
+	coordType := flags.String("coord", coord.WGS84, "coordinate type of files, among wgs84, gcj02 and bd09. "+
+		"Stations are converted to wgs84 on import")
+	flags.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: bsp [-c path/to/config] import [options] file...")
+		flags.PrintDefaults()
+	}
+	flags.Parse(args)
+
+	if flags.NArg() == 0 {
+		flags.Usage()
+		return fmt.Errorf("no file to import")
+	}
+	if _, _, err := coord.Convert(0, 0, *coordType, coord.WGS84); err != nil {
+		return err
+	}
+
+	filter := &services.ImportFilter{
+		Radios: splitList(strings.ToLower(*radio)),
+		Mccs:   splitList(*mcc),
+		Mncs:   splitList(*mnc),
+	}
+
+	db := setupDatabase()
+	for _, path := range flags.Args() {
+		if err := importFile(db, path, filter, *batchSize, *coordType); err != nil {
+			return fmt.Errorf("failed to import %s: %s", path, err)
+		}
+	}
+
+	return nil
+}
+
+func importFile(db *dbx.DB, path string, filter *services.ImportFilter, batchSize int, coordType string) error {
+	file, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+
+	reader, err := maybeGunzip(file)
+	if err != nil {
+		return err
+	}
+
+	log.Infof("importing %s", path)
+	importer := services.NewStationImporter(repos.NewStationRepo(), batchSize, coordType, func(progress services.ImportProgress) {
+		log.Infof("%s: %s", path, progress)
+	})
+	progress, err := importer.Import(app.NewDbScope(db), reader, filter)
+	if err != nil {
+		return fmt.Errorf("%s (%s)", err, progress)
+	}
+	log.Infof("%s imported: %s", path, progress)
+
+	return nil
+}
+
+// maybeGunzip decompresses gzipped content, which is told by its magic number rather than file extension
+func maybeGunzip(reader io.Reader) (io.Reader, error) {
+	buffered := bufio.NewReader(reader)
+	magic, err := buffered.Peek(2)
+	if err == nil && magic[0] == 0x1f && magic[1] == 0x8b {
+		return gzip.NewReader(buffered)
+	}
+
+	return buffered, nil
+}
+
+func splitList(value string) []string {
+	var items []string
+	for _, item := range strings.Split(value, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+
+	return items
+}
@@ -9,10 +9,14 @@ import (
 	"github.com/go-ozzo/ozzo-routing"
 	_ "github.com/lib/pq"
 	"net/http"
+	"os"
 	"strings"
+	"syscall"
 	"time"
 	"xungewang.cn/bsp/apis"
 	"xungewang.cn/bsp/app"
+	"xungewang.cn/bsp/gateway"
+	"xungewang.cn/bsp/geocode"
 	"xungewang.cn/bsp/repos"
 	"xungewang.cn/bsp/services"
 )
@@ -20,6 +24,15 @@ import (
 func main() {
 	//parse command line to allow config directory to be specified via '-c path/to/config'
 	configPath := flag.String("c", "", "the path(directory) where the config resides, e.g. ./config")
+	flag.Usage = func() {
+		fmt.Fprintln(os.Stderr, "usage: bsp [-c path/to/config] [command]\n\n"+
+			"commands:\n"+
+			"  import\timport cell exports of OpenCelliD or Mozilla Location Service\n"+
+			"  migrate\tapply, revert or list schema migrations, by 'migrate up|down [steps]|status'\n"+
+			"  (none)\tstart the http server\n\n"+
+			"options:")
+		flag.PrintDefaults()
+	}
 	flag.Parse()
 
 	// load application-wide configuration
@@ -38,8 +51,73 @@ func main() {
 	}
 
 	setupLogger()
+
+	switch command := flag.Arg(0); command {
+	case "":
+	case "import":
+		if err := runImport(flag.Args()[1:]); err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		return
+	case "migrate":
+		if err := runMigrate(setupDatabase(), flag.Args()[1:]); err != nil {
+			log.Error(err)
+			os.Exit(1)
+		}
+		return
+	default:
+		fmt.Fprintf(os.Stderr, "unknown command: %s\n", command)
+		flag.Usage()
+		os.Exit(2)
+	}
+
+	locators := setupLocators()
+	geocoder := setupGeocoder()
 	db := setupDatabase()
-	setupHttpServerAndStart(db)
+	if app.Config.AutoMigrate {
+		if err := migrateOnStartup(db); err != nil {
+			log.Errorf("failed to migrate schema: %s", err)
+			panic(err)
+		}
+	} else if err := checkSchemaOnStartup(db); err != nil {
+		log.Errorf("schema is out of date: %s", err)
+		panic(err)
+	}
+	stopLearning := setupLearning(db)
+	unknowns := setupUnknownSignalWriter(db)
+	positioning := setupPositioning(db, locators, geocoder, unknowns)
+	gateways, forwarder := setupGateways(db, positioning)
+	closeGateways := func() {
+		for _, server := range gateways {
+			server.Close()
+		}
+	}
+	setupHttpServerAndStart(db, positioning, closeGateways)
+
+	// server is shut down (or restarted by SIGHUP) with outstanding requests handled, stop gateways (if not yet)
+	// and background jobs, and flush what's left
+	closeGateways()
+	if forwarder != nil {
+		forwarder.Close()
+	}
+	stopLearning()
+	positioning.stopIndex()
+	unknowns.Close()
+}
+
+// positioning is what positioning services are made of, shared by the http server and gateways
+type positioning struct {
+	locators *services.LocatorRegistry
+	geocoder *geocode.Geocoder
+	unknowns *services.UnknownSignalWriter
+
+	// stations are found from the index if enabled, or through the cache if enabled
+	repo        repos.PositionRepo
+	index       *repos.StationIndex
+	stopIndex   func() // stops reloading the index
+	invalidator services.StationInvalidator
+	stats       map[string]apis.StatsFunc
 }
 
 func setupLogger() {
@@ -49,6 +127,33 @@ func setupLogger() {
 	}
 }
 
+func setupLocators() *services.LocatorRegistry {
+	locators := services.NewLocatorRegistry(services.NewPathLossModels(app.Config.PathLoss), app.Config.DefaultAlgorithm)
+	if err := locators.Validate(); err != nil {
+		log.Errorf("invalid positioning algorithms: %s", err)
+		panic(err)
+	}
+
+	return locators
+}
+
+// setupGeocoder loads administrative boundaries if configured, which returns nil if not
+func setupGeocoder() *geocode.Geocoder {
+	config := app.Config.Geocoding
+	if config.DataPath == "" {
+		return nil
+	}
+
+	geocoder, err := geocode.Load(config.DataPath, config.CoordType)
+	if err != nil {
+		log.Errorf("failed to load boundaries from %s: %s", config.DataPath, err)
+		panic(err)
+	}
+	log.Infof("%d boundaries loaded for geocoding", geocoder.Len())
+
+	return geocoder
+}
+
 func setupDatabase() *dbx.DB {
 	log.Debugf("trying to connect to %s", app.Config.DSN)
 	db, err := dbx.MustOpen("postgres", app.Config.DSN)
@@ -75,13 +180,145 @@ func setupDatabase() *dbx.DB {
 	return db
 }
 
-func setupHttpServerAndStart(db *dbx.DB) {
+// setupLearning starts learning stations if enabled, which returns a function stopping it once the round in
+// progress (if any) is done
+func setupLearning(db *dbx.DB) (stop func()) {
+	config := app.Config.Learning
+	if !config.Enabled {
+		return func() {}
+	}
+
+	learner := services.NewStationLearner(repos.NewObservationRepo(), services.NewPathLossModels(app.Config.PathLoss),
+		config.MinSamples, config.BatchSize)
+	log.Infof("learning stations from observations every %d seconds", config.Interval)
+	stopping, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		learner.Start(app.NewDbScope(db), time.Duration(config.Interval)*time.Second, stopping)
+	}()
+
+	return func() {
+		close(stopping)
+		<-stopped
+	}
+}
+
+func setupUnknownSignalWriter(db *dbx.DB) *services.UnknownSignalWriter {
+	return services.NewUnknownSignalWriter(repos.NewPositionRepo(), app.NewDbScope(db), app.Config.UnknownSignalQueueSize,
+		app.Config.UnknownSignalBatchSize, time.Duration(app.Config.UnknownSignalFlushInterval)*time.Second)
+}
+
+// setupStationIndex loads the station index if enabled, which returns nil if not. It returns as well a function
+// stopping reloading the index once the reload in progress (if any) is done.
+func setupStationIndex(db *dbx.DB) (index *repos.StationIndex, stop func()) {
+	config := app.Config.StationIndex
+	if !config.Enabled {
+		return nil, func() {}
+	}
+
+	index = repos.NewStationIndex()
+	if _, err := index.Reload(app.NewDbScope(db)); err != nil {
+		log.Errorf("failed to load station index: %s", err)
+		panic(err)
+	}
+	stopping, stopped := make(chan struct{}), make(chan struct{})
+	go func() {
+		defer close(stopped)
+		index.Start(app.NewDbScope(db), time.Duration(config.ReloadInterval)*time.Second, stopping)
+	}()
+
+	return index, func() {
+		close(stopping)
+		<-stopped
+	}
+}
+
+func setupPositioning(db *dbx.DB, locators *services.LocatorRegistry, geocoder *geocode.Geocoder,
+	unknowns *services.UnknownSignalWriter) *positioning {
+	p := &positioning{
+		locators: locators,
+		geocoder: geocoder,
+		unknowns: unknowns,
+		repo:     repos.NewPositionRepo(),
+		stats: map[string]apis.StatsFunc{
+			"unknown_signal_writer": func() interface{} { return unknowns.Stats() },
+		},
+	}
+	p.index, p.stopIndex = setupStationIndex(db)
+	if index := p.index; index != nil {
+		p.repo = index
+		p.stats["station_index"] = func() interface{} { return index.Stats() }
+	} else if config := app.Config.StationCache; config.Enabled {
+		cache := repos.NewStationCache(p.repo, config.Size, time.Duration(config.TTL)*time.Second)
+		p.repo, p.invalidator = cache, cache
+		p.stats["station_cache"] = func() interface{} { return cache.Stats() }
+	}
+
+	return p
+}
+
+// setupGateways starts TCP gateways of trackers that are enabled, which returns those started, along with the
+// forwarder of their positions (nil if not forwarded)
+func setupGateways(db *dbx.DB, p *positioning) ([]*gateway.Server, *services.PositionForwarder) {
+	config := app.Config.Gateway
+	if !config.JT808.Enabled && !config.GT06.Enabled {
+		return nil, nil
+	}
+
+	var forwarder *services.PositionForwarder
+	if config.ForwardURL != "" {
+		forwarder = services.NewPositionForwarder(config.ForwardURL, config.ForwardQueueSize)
+		p.stats["position_forwarder"] = func() interface{} { return forwarder.Stats() }
+	}
+	positionService := services.NewPositionService(p.repo, p.locators,
+		services.NewOutlierFilter(app.Config.OutlierThreshold, app.Config.OutlierMinStations), p.unknowns, p.geocoder)
+	tracker := gateway.NewTracker(app.NewDbScope(db), positionService,
+		services.NewDevicePositionService(repos.NewDevicePositionRepo(), forwarder))
+	idleTimeout := time.Duration(config.IdleTimeout) * time.Second
+
+	var servers []*gateway.Server
+	if config.JT808.Enabled {
+		// only terminals provisioned can register, unless the policy is open (in which case devices is nil)
+		var devices []string
+		if config.JT808.RegisterPolicy != "open" {
+			devices = append([]string{}, config.JT808.Devices...)
+		}
+		servers = append(servers, gateway.NewJT808Server(config.JT808.Addr, idleTimeout, tracker,
+			config.JT808.AuthSecret, byte(config.JT808.LbsItemId), devices))
+	}
+	if config.GT06.Enabled {
+		// only trackers provisioned can log in, unless the policy is open (in which case devices is nil)
+		var devices []string
+		if config.GT06.RegisterPolicy != "open" {
+			devices = append([]string{}, config.GT06.Devices...)
+		}
+		servers = append(servers, gateway.NewGT06Server(config.GT06.Addr, idleTimeout, tracker, devices))
+	}
+	for _, server := range servers {
+		go func(server *gateway.Server) {
+			// listening is retried while addresses are in use, hence it fails if they're invalid, listening fails
+			// otherwise, or accepting connections fails
+			if err := server.ListenAndServe(); err != nil {
+				log.Errorf("gateway stops serving: %s", err)
+			}
+		}(server)
+	}
+
+	return servers, forwarder
+}
+
+// setupHttpServerAndStart serves http requests until the server is shut down or restarted, with beforeRestart called
+// before the new process starts on SIGHUP
+func setupHttpServerAndStart(db *dbx.DB, p *positioning, beforeRestart func()) {
 	// setup router
-	http.Handle("/", setupRouter(db))
+	http.Handle("/", setupRouter(db, p))
 
-	// start server
+	// start server. Listeners other than the http one (e.g., of gateways) are not handed over to the new process,
+	// which should be closed for it to listen at their addresses.
 	log.Infof("http server starts at %s", app.Config.HttpServerAddr)
-	if err := endless.ListenAndServe(app.Config.HttpServerAddr, nil); err != nil {
+	server := endless.NewServer(app.Config.HttpServerAddr, nil)
+	server.RegisterSignalHook(endless.PRE_SIGNAL, syscall.SIGHUP, beforeRestart)
+	if err := server.ListenAndServe(); err != nil {
 		// sometimes it's fine since we may receive OS signals like SIGHUP. In that case, the error
 		// message is 'use of closed network connection'.
 		if !isClosedConnError(err) {
@@ -90,12 +327,29 @@ func setupHttpServerAndStart(db *dbx.DB) {
 	}
 }
 
-func setupRouter(db *dbx.DB) *routing.Router {
+func setupRouter(db *dbx.DB, p *positioning) *routing.Router {
 	router := routing.New()
 	router.Use(app.Init())
 
 	// api routers
-	apis.SetupPositionRouter(router.Group("/api"), db, services.NewPositionService(repos.NewPositionRepo()))
+	positionService := services.NewPositionService(p.repo, p.locators,
+		services.NewOutlierFilter(app.Config.OutlierThreshold, app.Config.OutlierMinStations), p.unknowns, p.geocoder)
+	apis.SetupPositionRouter(router.Group("/api"), db, positionService)
+	observationService := services.NewObservationService(repos.NewObservationRepo(), p.repo, p.unknowns)
+	apis.SetupObservationRouter(router.Group("/api"), db, observationService)
+	apis.SetupCellRouter(router.Group("/api"), db, services.NewCellService(repos.NewStationRepo(), p.unknowns))
+
+	// routers compatible with third party geolocation APIs
+	apis.SetupGeolocateRouter(router.Group("/v1"), db, positionService)
+	apis.SetupGeosubmitRouter(router.Group("/v2"), db, observationService)
+
+	// admin routers
+	apis.SetupStationRouter(router.Group("/admin"), db, services.NewStationService(repos.NewStationRepo(), p.invalidator))
+	apis.SetupUnknownSignalRouter(router.Group("/admin"), db, services.NewUnknownSignalService(repos.NewUnknownSignalRepo()))
+	apis.SetupStatsRouter(router.Group("/admin"), p.stats)
+	if p.index != nil {
+		apis.SetupStationIndexRouter(router.Group("/admin"), db, p.index)
+	}
 
 	return router
 }
@@ -0,0 +1,90 @@
+package main
+
+import (
+	"fmt"
+	"github.com/go-ozzo/ozzo-dbx"
+	"os"
+	"strconv"
+	"strings"
+	"xungewang.cn/bsp/app"
+	"xungewang.cn/bsp/migrations"
+)
+
+// runMigrate applies, reverts or lists schema migrations. e.g.
+//
+//	bsp -c ./config migrate up
+//	bsp -c ./config migrate down [steps]
+//	bsp -c ./config migrate status
+func runMigrate(db *dbx.DB, args []string) error {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "usage: bsp [-c path/to/config] migrate up|down [steps]|status")
+		return fmt.Errorf("migrate command missing")
+	}
+
+	migrator := migrations.NewMigrator(db, app.Config.DefaultMcc)
+	switch args[0] {
+	case "up":
+		applied, err := migrator.Up()
+		for _, migration := range applied {
+			fmt.Printf("applied %d_%s\n", migration.Version, migration.Name)
+		}
+		if err == nil && len(applied) == 0 {
+			fmt.Println("schema is up to date")
+		}
+		return err
+	case "down":
+		steps := 1
+		if len(args) > 1 {
+			var err error
+			if steps, err = strconv.Atoi(args[1]); err != nil || steps < 1 {
+				return fmt.Errorf("steps should be a positive number, got %s", args[1])
+			}
+		}
+		reverted, err := migrator.Down(steps)
+		for _, migration := range reverted {
+			fmt.Printf("reverted %d_%s\n", migration.Version, migration.Name)
+		}
+		return err
+	case "status":
+		statuses, err := migrator.Status()
+		for _, status := range statuses {
+			appliedAt := "pending"
+			if status.AppliedAt != nil {
+				appliedAt = "applied at " + status.AppliedAt.Format("2006-01-02 15:04:05")
+			}
+			fmt.Printf("%d_%s\t%s\n", status.Version, status.Name, appliedAt)
+		}
+		return err
+	}
+
+	return fmt.Errorf("unknown migrate command: %s", args[0])
+}
+
+// migrateOnStartup applies pending migrations before serving, each of which gets logged by the migrator
+func migrateOnStartup(db *dbx.DB) error {
+	_, err := migrations.NewMigrator(db, app.Config.DefaultMcc).Up()
+
+	return err
+}
+
+// checkSchemaOnStartup refuses to serve against a schema with migrations pending, since queries rely on what
+// they add (e.g., radio and mcc of stations) and would fail one by one otherwise
+func checkSchemaOnStartup(db *dbx.DB) error {
+	statuses, err := migrations.NewMigrator(db, app.Config.DefaultMcc).Status()
+	if err != nil {
+		return err
+	}
+
+	var pending []string
+	for _, status := range statuses {
+		if status.AppliedAt == nil {
+			pending = append(pending, fmt.Sprintf("%d_%s", status.Version, status.Name))
+		}
+	}
+	if len(pending) > 0 {
+		return fmt.Errorf("migrations %s are pending, apply them by 'bsp migrate up' or set auto_migrate",
+			strings.Join(pending, ", "))
+	}
+
+	return nil
+}
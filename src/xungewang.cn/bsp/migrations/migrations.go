@@ -0,0 +1,226 @@
+package migrations
+
+// Migration is a versioned change to the schema, along with the way to revert it. The default MCC configured is
+// available to migrations as current_setting('bsp.default_mcc').
+type Migration struct {
+	Version int
+	Name    string
+	Up      string
+	Down    string
+}
+
+// all migrations, in ascending order of versions. Never change a migration once released, add a new one instead.
+var all = []Migration{
+	{
+		// tables used to be created by hand, which are kept as they are
+		Version: 1,
+		Name:    "create_base_stations_and_unknown_signals",
+		Up: `
+CREATE TABLE IF NOT EXISTS base_stations (
+	id  VARCHAR(64) PRIMARY KEY,
+	lat DOUBLE PRECISION NOT NULL,
+	lng DOUBLE PRECISION NOT NULL
+);
+
+CREATE TABLE IF NOT EXISTS unknown_signals (
+	mnc        VARCHAR(8) NOT NULL,
+	lac        VARCHAR(16) NOT NULL,
+	cid        VARCHAR(16) NOT NULL,
+	created_at TIMESTAMP NOT NULL
+);`,
+		Down: `
+DROP TABLE IF EXISTS unknown_signals;
+DROP TABLE IF EXISTS base_stations;`,
+	},
+	{
+		// stations are identified by radio type and MCC as well. Identifiers of existing stations are
+		// recovered from their ids, i.e., 'mcc-mnc-lac-cid' and 'radio-mcc-mnc-lac-cid'. Stations used to be
+		// identified by 'mnc-lac-cid', whose ids are prefixed by the default MCC, unless the same station is
+		// there with the prefixed id already, which wins. Unknown signals recorded without MCC are of the default
+		// MCC as well. Reverting it keeps ids as they are rewritten.
+		Version: 2,
+		Name:    "add_station_identifiers",
+		Up: `
+ALTER TABLE base_stations
+	ADD COLUMN IF NOT EXISTS radio VARCHAR(8) NOT NULL DEFAULT 'gsm',
+	ADD COLUMN IF NOT EXISTS mcc VARCHAR(3) NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS mnc VARCHAR(8) NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS lac VARCHAR(16) NOT NULL DEFAULT '',
+	ADD COLUMN IF NOT EXISTS cid VARCHAR(16) NOT NULL DEFAULT '';
+
+DELETE FROM base_stations legacy
+	WHERE id ~ '^\d+-\d+-\d+$' AND EXISTS (
+		SELECT 1 FROM base_stations WHERE id = current_setting('bsp.default_mcc') || '-' || legacy.id);
+
+UPDATE base_stations
+	SET id = current_setting('bsp.default_mcc') || '-' || id
+	WHERE id ~ '^\d+-\d+-\d+$';
+
+UPDATE base_stations
+	SET mcc = split_part(id, '-', 1), mnc = split_part(id, '-', 2),
+		lac = split_part(id, '-', 3), cid = split_part(id, '-', 4)
+	WHERE mcc = '' AND id ~ '^\d+-\d+-\d+-\d+$';
+
+UPDATE base_stations
+	SET radio = split_part(id, '-', 1), mcc = split_part(id, '-', 2), mnc = split_part(id, '-', 3),
+		lac = split_part(id, '-', 4), cid = split_part(id, '-', 5)
+	WHERE mcc = '' AND id ~ '^[a-z]+-\d+-\d+-\d+-\d+$';
+
+CREATE INDEX IF NOT EXISTS base_stations_mcc_mnc_lac ON base_stations (mcc, mnc, lac);
+CREATE INDEX IF NOT EXISTS base_stations_lat_lng ON base_stations (lat, lng);
+
+ALTER TABLE unknown_signals
+	ADD COLUMN IF NOT EXISTS radio VARCHAR(8) NOT NULL DEFAULT 'gsm',
+	ADD COLUMN IF NOT EXISTS mcc VARCHAR(3) NOT NULL DEFAULT '';
+
+UPDATE unknown_signals SET mcc = current_setting('bsp.default_mcc') WHERE mcc = '';
+
+CREATE INDEX IF NOT EXISTS unknown_signals_created_at ON unknown_signals (created_at);`,
+		Down: `
+DROP INDEX IF EXISTS unknown_signals_created_at;
+
+ALTER TABLE unknown_signals
+	DROP COLUMN IF EXISTS mcc,
+	DROP COLUMN IF EXISTS radio;
+
+DROP INDEX IF EXISTS base_stations_lat_lng;
+DROP INDEX IF EXISTS base_stations_mcc_mnc_lac;
+
+ALTER TABLE base_stations
+	DROP COLUMN IF EXISTS cid,
+	DROP COLUMN IF EXISTS lac,
+	DROP COLUMN IF EXISTS mnc,
+	DROP COLUMN IF EXISTS mcc,
+	DROP COLUMN IF EXISTS radio;`,
+	},
+	{
+		// unknown signals are aggregated as one row per cell, rather than one row per sighting. Existing
+		// sightings are aggregated as well, and reverting it leaves one sighting per cell (at last_seen).
+		Version: 3,
+		Name:    "aggregate_unknown_signals",
+		Up: `
+ALTER TABLE unknown_signals RENAME TO unknown_sightings;
+
+CREATE TABLE unknown_signals (
+	id           VARCHAR(64) PRIMARY KEY,
+	radio        VARCHAR(8) NOT NULL,
+	mcc          VARCHAR(3) NOT NULL,
+	mnc          VARCHAR(8) NOT NULL,
+	lac          VARCHAR(16) NOT NULL,
+	cid          VARCHAR(16) NOT NULL,
+	first_seen   TIMESTAMP NOT NULL,
+	last_seen    TIMESTAMP NOT NULL,
+	hit_count    BIGINT NOT NULL DEFAULT 1,
+	max_strength DOUBLE PRECISION
+);
+
+INSERT INTO unknown_signals (id, radio, mcc, mnc, lac, cid, first_seen, last_seen, hit_count)
+	SELECT CASE WHEN radio = 'gsm' THEN '' ELSE radio || '-' END || concat_ws('-', mcc, mnc, lac, cid),
+		radio, mcc, mnc, lac, cid, MIN(created_at), MAX(created_at), COUNT(*)
+	FROM unknown_sightings
+	GROUP BY radio, mcc, mnc, lac, cid;
+
+DROP TABLE unknown_sightings;
+
+CREATE INDEX unknown_signals_hit_count ON unknown_signals (hit_count DESC);`,
+		Down: `
+ALTER TABLE unknown_signals RENAME TO unknown_cells;
+
+CREATE TABLE unknown_signals (
+	radio      VARCHAR(8) NOT NULL DEFAULT 'gsm',
+	mcc        VARCHAR(3) NOT NULL DEFAULT '',
+	mnc        VARCHAR(8) NOT NULL,
+	lac        VARCHAR(16) NOT NULL,
+	cid        VARCHAR(16) NOT NULL,
+	created_at TIMESTAMP NOT NULL
+);
+
+INSERT INTO unknown_signals (radio, mcc, mnc, lac, cid, created_at)
+	SELECT radio, mcc, mnc, lac, cid, last_seen FROM unknown_cells;
+
+DROP TABLE unknown_cells;
+
+CREATE INDEX unknown_signals_created_at ON unknown_signals (created_at);`,
+	},
+	{
+		// crowdsourced observations, from which stations are learned
+		Version: 4,
+		Name:    "create_observations_and_station_learnings",
+		Up: `
+CREATE TABLE observations (
+	id          BIGSERIAL PRIMARY KEY,
+	station_id  VARCHAR(64) NOT NULL,
+	radio       VARCHAR(8) NOT NULL,
+	mcc         VARCHAR(3) NOT NULL,
+	mnc         VARCHAR(8) NOT NULL,
+	lac         VARCHAR(16) NOT NULL,
+	cid         VARCHAR(16) NOT NULL,
+	lat         DOUBLE PRECISION NOT NULL,
+	lng         DOUBLE PRECISION NOT NULL,
+	accuracy    DOUBLE PRECISION NOT NULL DEFAULT 0,
+	strength    DOUBLE PRECISION NOT NULL DEFAULT 0,
+	observed_at TIMESTAMP NOT NULL,
+	learned     BOOLEAN NOT NULL DEFAULT FALSE
+);
+
+CREATE INDEX observations_pending ON observations (id) WHERE NOT learned;
+
+CREATE TABLE station_learnings (
+	station_id VARCHAR(64) PRIMARY KEY,
+	radio      VARCHAR(8) NOT NULL,
+	mcc        VARCHAR(3) NOT NULL,
+	mnc        VARCHAR(8) NOT NULL,
+	lac        VARCHAR(16) NOT NULL,
+	cid        VARCHAR(16) NOT NULL,
+	x          DOUBLE PRECISION NOT NULL,
+	y          DOUBLE PRECISION NOT NULL,
+	z          DOUBLE PRECISION NOT NULL,
+	weight     DOUBLE PRECISION NOT NULL,
+	samples    INTEGER NOT NULL,
+	updated_at TIMESTAMP NOT NULL
+);
+
+ALTER TABLE base_stations ADD COLUMN samples INTEGER NOT NULL DEFAULT 0;`,
+		Down: `
+ALTER TABLE base_stations DROP COLUMN samples;
+
+DROP TABLE station_learnings;
+DROP TABLE observations;`,
+	},
+	{
+		// range of stations as OpenCelliD exports tell, and where locations of stations come from. Existing
+		// stations are either learned (if they have samples) or imported.
+		Version: 5,
+		Name:    "add_station_range_and_source",
+		Up: `
+ALTER TABLE base_stations ADD COLUMN range INTEGER NOT NULL DEFAULT 0;
+ALTER TABLE base_stations ADD COLUMN source VARCHAR(16) NOT NULL DEFAULT '';
+
+UPDATE base_stations SET source = 'learned' WHERE samples > 0;
+UPDATE base_stations SET source = 'import' WHERE samples = 0;`,
+		Down: `
+ALTER TABLE base_stations DROP COLUMN source;
+ALTER TABLE base_stations DROP COLUMN range;`,
+	},
+	{
+		// positions of trackers connected through gateways
+		Version: 6,
+		Name:    "create_device_positions",
+		Up: `
+CREATE TABLE device_positions (
+	id          BIGSERIAL PRIMARY KEY,
+	device_id   VARCHAR(32) NOT NULL,
+	protocol    VARCHAR(16) NOT NULL,
+	source      VARCHAR(8) NOT NULL,
+	lat         DOUBLE PRECISION NOT NULL,
+	lng         DOUBLE PRECISION NOT NULL,
+	accuracy    DOUBLE PRECISION NOT NULL DEFAULT 0,
+	located_at  TIMESTAMP NOT NULL,
+	received_at TIMESTAMP NOT NULL
+);
+
+CREATE INDEX device_positions_device_id_located_at ON device_positions (device_id, located_at DESC);`,
+		Down: `
+DROP TABLE device_positions;`,
+	},
+}
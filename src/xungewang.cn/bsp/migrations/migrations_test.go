@@ -0,0 +1,21 @@
+package migrations
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestMigrations(t *testing.T) {
+	for i, migration := range all {
+		if migration.Version != i+1 {
+			t.Errorf("migration %s should be of version %d, got %d", migration.Name, i+1, migration.Version)
+		}
+		if migration.Name == "" || strings.TrimSpace(migration.Up) == "" || strings.TrimSpace(migration.Down) == "" {
+			t.Errorf("migration %d should have name, up and down", migration.Version)
+		}
+		// braces are placeholders of dbx queries
+		if strings.ContainsAny(migration.Up+migration.Down, "{}") {
+			t.Errorf("migration %d shouldn't contain braces", migration.Version)
+		}
+	}
+}
@@ -0,0 +1,183 @@
+package migrations
+
+import (
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"github.com/go-ozzo/ozzo-dbx"
+	"time"
+)
+
+const (
+	// table keeping track of applied migrations
+	versionTable = "schema_migrations"
+
+	// key of the advisory lock that serializes migrations run by multiple instances at the same time
+	lockKey = 20170101
+)
+
+// MigrationStatus tells whether a migration is applied, and when
+type MigrationStatus struct {
+	Migration
+	AppliedAt *time.Time
+}
+
+// migrator applies and reverts migrations against a database
+type migrator struct {
+	db         *dbx.DB
+	migrations []Migration
+
+	// MCC assumed for stations and signals identified without one, e.g., by legacy ids
+	defaultMcc string
+}
+
+// Up applies all pending migrations in ascending order, and returns those applied
+func (m *migrator) Up() ([]Migration, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for _, migration := range m.migrations {
+		if _, ok := applied[migration.Version]; ok {
+			continue
+		}
+
+		if err := m.run(migration, true); err != nil {
+			return done, err
+		}
+		done = append(done, migration)
+	}
+
+	return done, nil
+}
+
+// Down reverts the latest steps applied migrations in descending order, and returns those reverted
+func (m *migrator) Down(steps int) ([]Migration, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	var done []Migration
+	for i := len(m.migrations) - 1; i >= 0 && len(done) < steps; i-- {
+		migration := m.migrations[i]
+		if _, ok := applied[migration.Version]; !ok {
+			continue
+		}
+
+		if err := m.run(migration, false); err != nil {
+			return done, err
+		}
+		done = append(done, migration)
+	}
+
+	return done, nil
+}
+
+// Status lists all migrations along with when they're applied
+func (m *migrator) Status() ([]MigrationStatus, error) {
+	applied, err := m.applied()
+	if err != nil {
+		return nil, err
+	}
+
+	statuses := make([]MigrationStatus, len(m.migrations))
+	for i, migration := range m.migrations {
+		statuses[i] = MigrationStatus{Migration: migration}
+		if appliedAt, ok := applied[migration.Version]; ok {
+			statuses[i].AppliedAt = &appliedAt
+		}
+	}
+
+	return statuses, nil
+}
+
+// applied creates the version table if necessary, and tells when each applied migration is applied
+func (m *migrator) applied() (map[int]time.Time, error) {
+	if _, err := m.db.NewQuery(`CREATE TABLE IF NOT EXISTS ` + versionTable + ` (
+	version    INTEGER PRIMARY KEY,
+	name       VARCHAR(128) NOT NULL,
+	applied_at TIMESTAMP NOT NULL
+)`).Execute(); err != nil {
+		return nil, fmt.Errorf("failed to create %s: %s", versionTable, err)
+	}
+
+	var rows []struct {
+		Version   int       `db:"version"`
+		AppliedAt time.Time `db:"applied_at"`
+	}
+	if err := m.db.Select("version", "applied_at").From(versionTable).All(&rows); err != nil {
+		return nil, err
+	}
+
+	applied := make(map[int]time.Time, len(rows))
+	for _, row := range rows {
+		applied[row.Version] = row.AppliedAt
+	}
+
+	return applied, nil
+}
+
+// run applies (or reverts if up is false) a migration within a transaction, along with its version
+// recorded (or removed). Migrations already run by others in the meantime are skipped.
+func (m *migrator) run(migration Migration, up bool) error {
+	tx, err := m.db.Begin()
+	if err != nil {
+		return err
+	}
+
+	err = func() error {
+		if _, err := tx.NewQuery("SELECT pg_advisory_xact_lock({:key})").
+			Bind(dbx.Params{"key": lockKey}).Execute(); err != nil {
+			return err
+		}
+
+		var count int
+		if err := tx.Select("COUNT(*)").From(versionTable).
+			Where(dbx.HashExp{"version": migration.Version}).Row(&count); err != nil {
+			return err
+		}
+		if (count > 0) == up {
+			return nil
+		}
+
+		if _, err := tx.NewQuery("SELECT set_config('bsp.default_mcc', {:mcc}, true)").
+			Bind(dbx.Params{"mcc": m.defaultMcc}).Execute(); err != nil {
+			return err
+		}
+
+		if up {
+			log.Infof("applying migration %d_%s", migration.Version, migration.Name)
+			if _, err := tx.NewQuery(migration.Up).Execute(); err != nil {
+				return err
+			}
+			_, err := tx.Insert(versionTable, dbx.Params{
+				"version":    migration.Version,
+				"name":       migration.Name,
+				"applied_at": time.Now(),
+			}).Execute()
+			return err
+		}
+
+		log.Infof("reverting migration %d_%s", migration.Version, migration.Name)
+		if _, err := tx.NewQuery(migration.Down).Execute(); err != nil {
+			return err
+		}
+		_, err := tx.Delete(versionTable, dbx.HashExp{"version": migration.Version}).Execute()
+		return err
+	}()
+
+	if err != nil {
+		tx.Rollback()
+		return fmt.Errorf("migration %d_%s failed: %s", migration.Version, migration.Name, err)
+	}
+
+	return tx.Commit()
+}
+
+// NewMigrator creates an instance of migrator with all built-in migrations, which assume defaultMcc for stations
+// and signals identified without MCC
+func NewMigrator(db *dbx.DB, defaultMcc string) *migrator {
+	return &migrator{db: db, migrations: all, defaultMcc: defaultMcc}
+}
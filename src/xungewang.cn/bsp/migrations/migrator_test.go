@@ -0,0 +1,123 @@
+package migrations
+
+import (
+	"github.com/go-ozzo/ozzo-dbx"
+	_ "github.com/lib/pq"
+	"os"
+	"testing"
+	"time"
+)
+
+// testSchema is where migrations are tested, which is dropped afterwards
+const testSchema = "bsp_migrations_test"
+
+// TestMigrator_LegacyData migrates tables of the legacy layout, i.e., stations identified by 'mnc-lac-cid' and
+// signals recorded without MCC, which runs against the PostgreSQL database of BSP_TEST_DSN only
+func TestMigrator_LegacyData(t *testing.T) {
+	db := openTestDB(t)
+	defer closeTestDB(db)
+
+	m := &migrator{db: db, migrations: all[:1], defaultMcc: "460"}
+	if _, err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+	seen := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	for _, sql := range []string{
+		`INSERT INTO base_stations (id, lat, lng) VALUES ('0-6334-6', 30.5, 104.5), ('1-1-1', 1, 1),
+			('460-1-1-1', 30.6, 104.6), ('460-0-32838-60122', 30.7, 103.9), ('lte-460-0-6244-84115972', 30.5, 104.0)`,
+		`INSERT INTO unknown_signals (mnc, lac, cid, created_at) VALUES ('0', '6334', '7', '2017-01-01'),
+			('0', '6334', '7', '2017-01-02'), ('1', '2', '3', '2017-01-01')`,
+	} {
+		if _, err := db.NewQuery(sql).Execute(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	m.migrations = all
+	if _, err := m.Up(); err != nil {
+		t.Fatal(err)
+	}
+
+	var stations []struct {
+		Id    string  `db:"id"`
+		Radio string  `db:"radio"`
+		Mcc   string  `db:"mcc"`
+		Mnc   string  `db:"mnc"`
+		Lac   string  `db:"lac"`
+		Cid   string  `db:"cid"`
+		Lat   float64 `db:"lat"`
+	}
+	if err := db.Select("id", "radio", "mcc", "mnc", "lac", "cid", "lat").From("base_stations").
+		OrderBy("id").All(&stations); err != nil {
+		t.Fatal(err)
+	}
+	expectedStations := []string{
+		"460-0-32838-60122 gsm 460 0 32838 60122",
+		"460-0-6334-6 gsm 460 0 6334 6",
+		"460-1-1-1 gsm 460 1 1 1", // the one identified by MCC wins
+		"lte-460-0-6244-84115972 lte 460 0 6244 84115972",
+	}
+	if len(stations) != len(expectedStations) {
+		t.Fatalf("unexpected stations %+v", stations)
+	}
+	for i, station := range stations {
+		if got := station.Id + " " + station.Radio + " " + station.Mcc + " " + station.Mnc + " " + station.Lac + " " +
+			station.Cid; got != expectedStations[i] {
+			t.Errorf("got station %s, expecting %s", got, expectedStations[i])
+		}
+		if station.Id == "460-1-1-1" && station.Lat != 30.6 {
+			t.Errorf("station %s should be kept, got %+v", station.Id, station)
+		}
+	}
+
+	var signals []struct {
+		Id        string    `db:"id"`
+		Mcc       string    `db:"mcc"`
+		HitCount  int64     `db:"hit_count"`
+		FirstSeen time.Time `db:"first_seen"`
+	}
+	if err := db.Select("id", "mcc", "hit_count", "first_seen").From("unknown_signals").
+		OrderBy("id").All(&signals); err != nil {
+		t.Fatal(err)
+	}
+	if len(signals) != 2 || signals[0].Id != "460-0-6334-7" || signals[0].Mcc != "460" || signals[0].HitCount != 2 ||
+		!signals[0].FirstSeen.Equal(seen) || signals[1].Id != "460-1-2-3" || signals[1].Mcc != "460" {
+		t.Errorf("unexpected unknown signals %+v", signals)
+	}
+
+	if reverted, err := m.Down(len(all)); err != nil || len(reverted) != len(all) {
+		t.Errorf("reverted %d migrations, error %v", len(reverted), err)
+	}
+}
+
+// openTestDB connects to the database of BSP_TEST_DSN (skipping the test if not given), in a schema of its own
+func openTestDB(t *testing.T) *dbx.DB {
+	dsn := os.Getenv("BSP_TEST_DSN")
+	if dsn == "" {
+		t.Skip("BSP_TEST_DSN is not set")
+	}
+
+	db, err := dbx.MustOpen("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the search path is set per connection, hence only one is used
+	db.DB().SetMaxOpenConns(1)
+	for _, sql := range []string{
+		"DROP SCHEMA IF EXISTS " + testSchema + " CASCADE",
+		"CREATE SCHEMA " + testSchema,
+		"SET search_path TO " + testSchema,
+	} {
+		if _, err := db.NewQuery(sql).Execute(); err != nil {
+			db.Close()
+			t.Fatal(err)
+		}
+	}
+
+	return db
+}
+
+func closeTestDB(db *dbx.DB) {
+	db.NewQuery("DROP SCHEMA IF EXISTS " + testSchema + " CASCADE").Execute()
+	db.Close()
+}
@@ -0,0 +1,27 @@
+package models
+
+import "math"
+
+// Area is a location area (LAC for GSM and UMTS, TAC for LTE and NR), made up of the stations located in it
+type Area struct {
+	Radio string
+	Mcc   string
+	Mnc   string
+	Lac   string
+
+	// sum of positions of the stations as unit vectors in cartesian coordinates, which points to the centroid of
+	// them on the sphere. Unlike bounds of coordinates, it works for areas across the antimeridian as well.
+	X, Y, Z float64
+
+	// number of stations located in the area
+	Stations int
+}
+
+// Add adds a station located at (lat, lng) in degrees to the area
+func (area *Area) Add(lat, lng float64) {
+	lat, lng = lat*math.Pi/180, lng*math.Pi/180
+	area.X += math.Cos(lat) * math.Cos(lng)
+	area.Y += math.Cos(lat) * math.Sin(lng)
+	area.Z += math.Sin(lat)
+	area.Stations++
+}
@@ -0,0 +1,36 @@
+package models
+
+import "time"
+
+// sources of device positions
+const (
+	PositionSourceGNSS = "gnss" // reported by the device
+	PositionSourceLBS  = "lbs"  // computed from stations the device sees
+)
+
+// DevicePosition is a position of a tracker connected through gateways, e.g., JT/T 808
+type DevicePosition struct {
+	Id int64 `db:"id" json:"-"`
+
+	// id of the device in its protocol, e.g., terminal phone number of JT/T 808
+	DeviceId string `db:"device_id" json:"device_id"`
+	Protocol string `db:"protocol" json:"protocol"`
+
+	// one of the PositionSource* constants
+	Source string `db:"source" json:"source"`
+
+	// WGS-84 coordinates, along with the accuracy in meters (0 if unknown)
+	Lat      float64 `db:"lat" json:"lat"`
+	Lng      float64 `db:"lng" json:"lng"`
+	Accuracy float64 `db:"accuracy" json:"accuracy"`
+
+	// when the device is there, as reported by the device
+	LocatedAt time.Time `db:"located_at" json:"located_at"`
+	// when the report is received
+	ReceivedAt time.Time `db:"received_at" json:"received_at"`
+}
+
+// TableName tells the table device positions are stored in
+func (position DevicePosition) TableName() string {
+	return "device_positions"
+}
@@ -0,0 +1,60 @@
+package models
+
+import "time"
+
+// Observation is a cell seen by a device at a GPS fix, from which the location of the cell is learned
+type Observation struct {
+	Id int64 `db:"id"`
+
+	// id of the station observed, see BuildStationId
+	StationId string `db:"station_id"`
+
+	Radio string `db:"radio"`
+	Mcc   string `db:"mcc"`
+	Mnc   string `db:"mnc"`
+	Lac   string `db:"lac"`
+	Cid   string `db:"cid"`
+
+	// the GPS fix, along with its accuracy in meters (0 if unknown)
+	Lat      float64 `db:"lat"`
+	Lng      float64 `db:"lng"`
+	Accuracy float64 `db:"accuracy"`
+
+	// signal strength in dBm, 0 if unknown
+	Strength float64 `db:"strength"`
+
+	ObservedAt time.Time `db:"observed_at"`
+}
+
+// TableName tells the table observations are stored in
+func (observation Observation) TableName() string {
+	return "observations"
+}
+
+// StationLearning accumulates observations of a station, whose location is the weighted centroid of
+// the observations. Observations are summed up as weighted unit vectors (X, Y, Z) on the earth, so that
+// stations near the poles or the antimeridian are learned right.
+type StationLearning struct {
+	StationId string `db:"station_id"`
+
+	Radio string `db:"radio"`
+	Mcc   string `db:"mcc"`
+	Mnc   string `db:"mnc"`
+	Lac   string `db:"lac"`
+	Cid   string `db:"cid"`
+
+	X      float64 `db:"x"`
+	Y      float64 `db:"y"`
+	Z      float64 `db:"z"`
+	Weight float64 `db:"weight"`
+
+	// number of observations learned
+	Samples int `db:"samples"`
+
+	UpdatedAt time.Time `db:"updated_at"`
+}
+
+// TableName tells the table station learnings are stored in
+func (learning StationLearning) TableName() string {
+	return "station_learnings"
+}
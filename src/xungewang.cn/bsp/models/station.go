@@ -1,17 +1,109 @@
 package models
 
-import "github.com/go-ozzo/ozzo-validation"
+import (
+	"errors"
+	"github.com/go-ozzo/ozzo-validation"
+	"regexp"
+	"strings"
+)
+
+var (
+	// MCC is always made up of 3 digits
+	mccPattern = regexp.MustCompile(`^\d{3}$`)
+	// MNC, LAC and CID are decimal numbers
+	numberPattern = regexp.MustCompile(`^\d+$`)
+)
 
 // Station represents an base station.
 type Station struct {
-	Id  string  `db:"id"`
-	Lat float64 `db:"lat"`
-	Lng float64 `db:"lng"`
+	// see BuildStationId
+	Id string `db:"id" json:"id"`
+
+	// radio type, one of "gsm", "umts", "lte" and "nr"
+	Radio string `db:"radio" json:"radio"`
+
+	Mcc string `db:"mcc" json:"mcc"`
+	Mnc string `db:"mnc" json:"mnc"`
+	Lac string `db:"lac" json:"lac"` // TAC for LTE and NR
+	Cid string `db:"cid" json:"cid"` // ECI for LTE, NCI for NR
+
+	Lat float64 `db:"lat" json:"lat"`
+	Lng float64 `db:"lng" json:"lng"`
+
+	// number of crowdsourced observations the location is learned from, 0 if not learned
+	Samples int `db:"samples" json:"samples"`
+
+	// estimated range (in meters) of the station, 0 if unknown
+	Range int `db:"range" json:"range"`
+
+	// where the location comes from, one of the Source* constants, empty if unknown
+	Source string `db:"source" json:"source"`
+}
+
+// sources of station locations
+const (
+	SourceImport  = "import"
+	SourceLearned = "learned"
+	SourceManual  = "manual"
+)
+
+// TableName tells the table stations are stored in
+func (station Station) TableName() string {
+	return "base_stations"
 }
 
 // Validate validates the Station fields
 func (station Station) Validate() error {
 	return validation.ValidateStruct(&station,
 		validation.Field(&station.Id, validation.Required),
+		validation.Field(&station.Radio, validation.Required, validation.In("gsm", "umts", "lte", "nr")),
+		validation.Field(&station.Mcc, validation.Required, validation.Match(mccPattern)),
+		validation.Field(&station.Mnc, validation.Required, validation.Match(numberPattern)),
+		validation.Field(&station.Lac, validation.Required, validation.Match(numberPattern)),
+		validation.Field(&station.Cid, validation.Required, validation.Match(numberPattern)),
+		validation.Field(&station.Lat, validation.Min(-90.0), validation.Max(90.0), validation.By(notNullIsland(station))),
+		validation.Field(&station.Lng, validation.Min(-180.0), validation.Max(180.0)),
+		validation.Field(&station.Range, validation.Min(0)),
 	)
 }
+
+// (0, 0), a.k.a. Null Island, is where stations with missing coordinates end up, which is never a real station
+func notNullIsland(station Station) validation.RuleFunc {
+	return func(interface{}) error {
+		if station.Lat == 0 && station.Lng == 0 {
+			return errors.New("(0, 0) is not a valid location")
+		}
+		return nil
+	}
+}
+
+// BuildStationId builds id of a station. GSM stations are identified by 'mcc-mnc-lac-cid', while stations of
+// other radio types get prefixed with their radio type, e.g., 'lte-mcc-mnc-tac-eci'.
+func BuildStationId(radio, mcc, mnc, lac, cid string) string {
+	id := strings.Join([]string{mcc, mnc, lac, cid}, "-")
+	if radio == "" || radio == "gsm" {
+		return id
+	}
+
+	return radio + "-" + id
+}
+
+// StationFilter filters stations, with empty fields not taken into account
+type StationFilter struct {
+	Radio string
+	Mcc   string
+	Mnc   string
+	Lac   string
+
+	// bounding box, stations outside are filtered out. MinLng greater than MaxLng means the box
+	// crosses the antimeridian.
+	Bounds *Bounds
+}
+
+// Bounds is a bounding box of coordinates
+type Bounds struct {
+	MinLat float64
+	MinLng float64
+	MaxLat float64
+	MaxLng float64
+}
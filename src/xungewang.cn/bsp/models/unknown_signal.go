@@ -0,0 +1,38 @@
+package models
+
+import "time"
+
+// UnknownSignal is a cell that positioning requests report but no station matches, aggregated
+// over all its sightings
+type UnknownSignal struct {
+	// same as id of the station, see BuildStationId
+	Id string `db:"id" json:"id"`
+
+	Radio string `db:"radio" json:"radio"`
+	Mcc   string `db:"mcc" json:"mcc"`
+	Mnc   string `db:"mnc" json:"mnc"`
+	Lac   string `db:"lac" json:"lac"`
+	Cid   string `db:"cid" json:"cid"`
+
+	FirstSeen time.Time `db:"first_seen" json:"first_seen"`
+	LastSeen  time.Time `db:"last_seen" json:"last_seen"`
+	HitCount  int64     `db:"hit_count" json:"hit_count"`
+
+	// the strongest signal strength observed, in dBm. nil if none of the sightings comes with strength
+	MaxStrength *float64 `db:"max_strength" json:"max_strength"`
+}
+
+// TableName tells the table unknown signals are stored in
+func (signal UnknownSignal) TableName() string {
+	return "unknown_signals"
+}
+
+// UnknownSignalFilter filters unknown signals, with empty fields not taken into account
+type UnknownSignalFilter struct {
+	Radio string
+	Mcc   string
+	Mnc   string
+
+	// only those seen since then
+	Since *time.Time
+}
@@ -0,0 +1,23 @@
+package repos
+
+import (
+	"xungewang.cn/bsp/app"
+	"xungewang.cn/bsp/models"
+)
+
+type (
+	DevicePositionRepo interface {
+		Create(ctx app.RequestScope, position *models.DevicePosition) error
+	}
+
+	defaultDevicePositionRepo struct{}
+)
+
+func (repo *defaultDevicePositionRepo) Create(ctx app.RequestScope, position *models.DevicePosition) error {
+	return ctx.Db().Model(position).Insert()
+}
+
+// NewDevicePositionRepo create instance of DevicePositionRepo
+func NewDevicePositionRepo() *defaultDevicePositionRepo {
+	return &defaultDevicePositionRepo{}
+}
@@ -0,0 +1,208 @@
+package repos
+
+import (
+	"fmt"
+	"github.com/go-ozzo/ozzo-dbx"
+	"strings"
+	"time"
+	"xungewang.cn/bsp/app"
+	"xungewang.cn/bsp/models"
+)
+
+const (
+	// key of the advisory lock held while learning, so that observations are learned by one instance at a time
+	learningLockKey = 20170102
+
+	// maximum number of observations inserted by one statement, which keeps parameters bound (11 per observation)
+	// within the limit of PostgreSQL
+	observationsPerInsert = 1000
+)
+
+type (
+	// LearnFunc merges observations into learnings of their stations, keyed by station id. Learnings of
+	// stations never learned before are missing, while stations already located (if any) are given. It
+	// returns stations located by the learnings, which are to be saved.
+	LearnFunc func(observations []models.Observation, learnings map[string]*models.StationLearning,
+		stations map[string]*models.Station) []models.Station
+
+	ObservationRepo interface {
+		Create(ctx app.RequestScope, observations []models.Observation) error
+		// Learn learns up to limit pending observations with learn, and returns the number of observations
+		// learned. Nothing gets learned if another instance is learning.
+		Learn(ctx app.RequestScope, limit int, learn LearnFunc) (int, error)
+	}
+
+	defaultObservationRepo struct{}
+)
+
+// Create inserts observations with one statement, or within a transaction if they're more than one statement
+// takes, hence all or none of them get stored
+func (repo *defaultObservationRepo) Create(ctx app.RequestScope, observations []models.Observation) error {
+	if len(observations) <= observationsPerInsert {
+		return insertObservations(ctx.Db(), observations)
+	}
+
+	return ctx.Db().Transactional(func(tx *dbx.Tx) error {
+		for start := 0; start < len(observations); start += observationsPerInsert {
+			end := start + observationsPerInsert
+			if end > len(observations) {
+				end = len(observations)
+			}
+			if err := insertObservations(tx, observations[start:end]); err != nil {
+				return err
+			}
+		}
+		return nil
+	})
+}
+
+// insertObservations inserts observations with one statement
+func insertObservations(db dbx.Builder, observations []models.Observation) error {
+	if len(observations) == 0 {
+		return nil
+	}
+
+	values := make([]string, len(observations))
+	params := dbx.Params{}
+	for i, observation := range observations {
+		values[i] = fmt.Sprintf("({:station_id%[1]d}, {:radio%[1]d}, {:mcc%[1]d}, {:mnc%[1]d}, {:lac%[1]d}, {:cid%[1]d}, "+
+			"{:lat%[1]d}, {:lng%[1]d}, {:accuracy%[1]d}, {:strength%[1]d}, {:observed_at%[1]d})", i)
+		for column, value := range map[string]interface{}{
+			"station_id":  observation.StationId,
+			"radio":       observation.Radio,
+			"mcc":         observation.Mcc,
+			"mnc":         observation.Mnc,
+			"lac":         observation.Lac,
+			"cid":         observation.Cid,
+			"lat":         observation.Lat,
+			"lng":         observation.Lng,
+			"accuracy":    observation.Accuracy,
+			"strength":    observation.Strength,
+			"observed_at": observation.ObservedAt,
+		} {
+			params[fmt.Sprintf("%s%d", column, i)] = value
+		}
+	}
+
+	_, err := db.NewQuery("INSERT INTO " + models.Observation{}.TableName() +
+		" (station_id, radio, mcc, mnc, lac, cid, lat, lng, accuracy, strength, observed_at) VALUES " +
+		strings.Join(values, ", ")).Bind(params).Execute()
+
+	return err
+}
+
+// Learn runs within a transaction, in which learnings and stations get saved, stations learned get removed
+// from unknown signals, and observations get marked as learned all together. Stations located by hand are
+// never overwritten, nor are imported ones unless learned from more samples than they were imported with.
+func (repo *defaultObservationRepo) Learn(ctx app.RequestScope, limit int, learn LearnFunc) (int, error) {
+	var learned int
+	err := ctx.Db().Transactional(func(tx *dbx.Tx) error {
+		var locked bool
+		if err := tx.NewQuery("SELECT pg_try_advisory_xact_lock({:key})").
+			Bind(dbx.Params{"key": learningLockKey}).Row(&locked); err != nil || !locked {
+			return err
+		}
+
+		var observations []models.Observation
+		if err := tx.Select().From(models.Observation{}.TableName()).Where(dbx.NewExp("NOT learned")).
+			OrderBy("id").Limit(int64(limit)).All(&observations); err != nil || len(observations) == 0 {
+			return err
+		}
+
+		var observationIds, stationIds []interface{}
+		for _, observation := range observations {
+			observationIds = append(observationIds, observation.Id)
+			stationIds = append(stationIds, observation.StationId)
+		}
+
+		var existingLearnings []models.StationLearning
+		if err := tx.Select().From(models.StationLearning{}.TableName()).
+			Where(dbx.In("station_id", stationIds...)).All(&existingLearnings); err != nil {
+			return err
+		}
+		learnings := make(map[string]*models.StationLearning, len(existingLearnings))
+		for i := range existingLearnings {
+			learnings[existingLearnings[i].StationId] = &existingLearnings[i]
+		}
+
+		var existingStations []models.Station
+		if err := tx.Select().From(models.Station{}.TableName()).
+			Where(dbx.In("id", stationIds...)).All(&existingStations); err != nil {
+			return err
+		}
+		stations := make(map[string]*models.Station, len(existingStations))
+		for i := range existingStations {
+			stations[existingStations[i].Id] = &existingStations[i]
+		}
+
+		located := learn(observations, learnings, stations)
+
+		updatedAt := time.Now()
+		for _, learning := range learnings {
+			if _, err := tx.Upsert(models.StationLearning{}.TableName(), dbx.Params{
+				"station_id": learning.StationId,
+				"radio":      learning.Radio,
+				"mcc":        learning.Mcc,
+				"mnc":        learning.Mnc,
+				"lac":        learning.Lac,
+				"cid":        learning.Cid,
+				"x":          learning.X,
+				"y":          learning.Y,
+				"z":          learning.Z,
+				"weight":     learning.Weight,
+				"samples":    learning.Samples,
+				"updated_at": updatedAt,
+			}, "station_id").Execute(); err != nil {
+				return err
+			}
+		}
+
+		table := models.Station{}.TableName()
+		var locatedIds []interface{}
+		for _, station := range located {
+			if _, err := tx.NewQuery("INSERT INTO " + table +
+				" (id, radio, mcc, mnc, lac, cid, lat, lng, samples, source) VALUES " +
+				"({:id}, {:radio}, {:mcc}, {:mnc}, {:lac}, {:cid}, {:lat}, {:lng}, {:samples}, {:learned})" +
+				" ON CONFLICT (id) DO UPDATE SET lat = EXCLUDED.lat, lng = EXCLUDED.lng, samples = EXCLUDED.samples, " +
+				"source = EXCLUDED.source WHERE " + table + ".source <> {:manual} AND (" + table + ".source <> {:import}" +
+				" OR " + table + ".samples < EXCLUDED.samples)").Bind(dbx.Params{
+				"id":      station.Id,
+				"radio":   station.Radio,
+				"mcc":     station.Mcc,
+				"mnc":     station.Mnc,
+				"lac":     station.Lac,
+				"cid":     station.Cid,
+				"lat":     station.Lat,
+				"lng":     station.Lng,
+				"samples": station.Samples,
+				"learned": models.SourceLearned,
+				"manual":  models.SourceManual,
+				"import":  models.SourceImport,
+			}).Execute(); err != nil {
+				return err
+			}
+			locatedIds = append(locatedIds, station.Id)
+		}
+
+		if len(locatedIds) > 0 {
+			if _, err := tx.Delete(models.UnknownSignal{}.TableName(), dbx.In("id", locatedIds...)).Execute(); err != nil {
+				return err
+			}
+		}
+
+		if _, err := tx.Update(models.Observation{}.TableName(), dbx.Params{"learned": true},
+			dbx.In("id", observationIds...)).Execute(); err != nil {
+			return err
+		}
+
+		learned = len(observations)
+		return nil
+	})
+
+	return learned, err
+}
+
+// NewObservationRepo create instance of ObservationRepo
+func NewObservationRepo() *defaultObservationRepo {
+	return &defaultObservationRepo{}
+}
@@ -0,0 +1,114 @@
+package repos
+
+import (
+	"fmt"
+	"github.com/go-ozzo/ozzo-dbx"
+	_ "github.com/lib/pq"
+	"os"
+	"testing"
+	"time"
+	"xungewang.cn/bsp/app"
+	"xungewang.cn/bsp/migrations"
+	"xungewang.cn/bsp/models"
+)
+
+// testSchema is where repos are tested, which is dropped afterwards
+const testSchema = "bsp_repos_test"
+
+// TestObservationRepo_Learn saves stations learned, which runs against the PostgreSQL database of BSP_TEST_DSN only
+func TestObservationRepo_Learn(t *testing.T) {
+	db := openTestDB(t)
+	defer closeTestDB(db)
+
+	if _, err := migrations.NewMigrator(db, "460").Up(); err != nil {
+		t.Fatal(err)
+	}
+	for _, station := range []models.Station{
+		{Id: "460-0-32838-60122", Radio: "gsm", Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60122", Lat: 30.7, Lng: 103.9,
+			Source: models.SourceManual},
+		{Id: "460-0-32838-60123", Radio: "gsm", Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60123", Lat: 30.7, Lng: 103.9,
+			Samples: 10, Source: models.SourceImport},
+		{Id: "460-0-32838-60124", Radio: "gsm", Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60124", Lat: 30.7, Lng: 103.9,
+			Samples: 2, Source: models.SourceImport},
+	} {
+		station := station
+		if err := db.Model(&station).Insert(); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	ctx := app.NewDbScope(db)
+	repo := NewObservationRepo()
+	ids := []string{"460-0-32838-60122", "460-0-32838-60123", "460-0-32838-60124", "460-0-32838-60125"}
+	var observations []models.Observation
+	for _, id := range ids {
+		observations = append(observations, models.Observation{StationId: id, Radio: "gsm", Mcc: "460", Mnc: "0",
+			Lac: "32838", Cid: id[len("460-0-32838-"):], Lat: 30.8, Lng: 104.0, ObservedAt: time.Now()})
+	}
+	if err := repo.Create(ctx, observations); err != nil {
+		t.Fatal(err)
+	}
+
+	// every station is located at where it's observed, as if learned from 5 samples
+	learned, err := repo.Learn(ctx, 100, func(observations []models.Observation,
+		learnings map[string]*models.StationLearning, stations map[string]*models.Station) []models.Station {
+		var located []models.Station
+		for _, observation := range observations {
+			located = append(located, models.Station{Id: observation.StationId, Radio: observation.Radio,
+				Mcc: observation.Mcc, Mnc: observation.Mnc, Lac: observation.Lac, Cid: observation.Cid,
+				Lat: observation.Lat, Lng: observation.Lng, Samples: 5})
+		}
+		return located
+	})
+	if err != nil || learned != len(ids) {
+		t.Fatalf("learned %d observations, error %v", learned, err)
+	}
+
+	expected := map[string]string{
+		"460-0-32838-60122": "manual 30.7 0",  // located by hand
+		"460-0-32838-60123": "import 30.7 10", // imported with more samples
+		"460-0-32838-60124": "learned 30.8 5",
+		"460-0-32838-60125": "learned 30.8 5",
+	}
+	for _, id := range ids {
+		var station models.Station
+		if err := db.Select().Model(id, &station); err != nil {
+			t.Fatal(err)
+		}
+		if got := fmt.Sprintf("%s %v %d", station.Source, station.Lat, station.Samples); got != expected[id] {
+			t.Errorf("station %s: got %s, expecting %s", id, got, expected[id])
+		}
+	}
+}
+
+// openTestDB connects to the database of BSP_TEST_DSN (skipping the test if not given), in a schema of its own
+func openTestDB(t *testing.T) *dbx.DB {
+	dsn := os.Getenv("BSP_TEST_DSN")
+	if dsn == "" {
+		t.Skip("BSP_TEST_DSN is not set")
+	}
+
+	db, err := dbx.MustOpen("postgres", dsn)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the search path is set per connection, hence only one is used
+	db.DB().SetMaxOpenConns(1)
+	for _, sql := range []string{
+		"DROP SCHEMA IF EXISTS " + testSchema + " CASCADE",
+		"CREATE SCHEMA " + testSchema,
+		"SET search_path TO " + testSchema,
+	} {
+		if _, err := db.NewQuery(sql).Execute(); err != nil {
+			db.Close()
+			t.Fatal(err)
+		}
+	}
+
+	return db
+}
+
+func closeTestDB(db *dbx.DB) {
+	db.NewQuery("DROP SCHEMA IF EXISTS " + testSchema + " CASCADE").Execute()
+	db.Close()
+}
@@ -1,9 +1,9 @@
 package repos
 
 import (
-	log "github.com/Sirupsen/logrus"
+	"fmt"
 	"github.com/go-ozzo/ozzo-dbx"
-	"time"
+	"strings"
 	"xungewang.cn/bsp/apis"
 	"xungewang.cn/bsp/app"
 	"xungewang.cn/bsp/models"
@@ -12,40 +12,117 @@ import (
 type (
 	PositionRepo interface {
 		FindStations(ctx app.RequestScope, signals map[string]apis.Signal) ([]models.Station, error)
-		RecordUnknownSignals(ctx app.RequestScope, signals []apis.Signal)
+		RecordUnknownSignals(ctx app.RequestScope, signals []models.UnknownSignal) error
+		FindAreas(ctx app.RequestScope, signals []apis.Signal) ([]models.Area, error)
 	}
 
 	defaultPositionRepo struct{}
 )
 
 func (repo *defaultPositionRepo) FindStations(ctx app.RequestScope, signals map[string]apis.Signal) ([]models.Station, error) {
-	// we collect station ids, i.e., keys of signals map
+	// we collect station ids, i.e., keys of signals map, which are built as 'mcc-mnc-lac-cid' so that
+	// cells sharing the same mnc, lac and cid in different countries won't collide. Ids are grouped by
+	// radio type, since stations of each radio type are looked up separately.
 	// Note: dbx.In() takes ...interface{} as the second argument, so we have to convert ids as []interface.
-	ids := make([]interface{}, 0, len(signals))
-	for id := range signals {
-		ids = append(ids, id)
+	idsOfRadio := make(map[string][]interface{})
+	for id, signal := range signals {
+		idsOfRadio[signal.Radio] = append(idsOfRadio[signal.Radio], id)
 	}
 
 	// query against database to find stations
-	var stations []models.Station // note that dbx reject slice of pointers to structure as its argument
-	err := ctx.Db().Select("id", "lat", "lng").From("base_stations").
-		Where(dbx.In("id", ids...)).Limit(int64(len(ids))).All(&stations)
+	stations := make([]models.Station, 0, len(signals))
+	for radio, ids := range idsOfRadio {
+		var found []models.Station // note that dbx reject slice of pointers to structure as its argument
+		if err := ctx.Db().Select("id", "radio", "lat", "lng").From("base_stations").
+			Where(dbx.And(dbx.HashExp{"radio": radio}, dbx.In("id", ids...))).Limit(int64(len(ids))).
+			All(&found); err != nil {
+			return nil, err
+		}
+
+		stations = append(stations, found...)
+	}
+
+	return stations, nil
+}
+
+// RecordUnknownSignals upserts unknown signals (aggregated by cell, hence no duplicates) with one statement. Hit
+// counts of cells are added up, first/last seen times extended and the strongest signal strength kept.
+func (repo *defaultPositionRepo) RecordUnknownSignals(ctx app.RequestScope, signals []models.UnknownSignal) error {
+	if len(signals) == 0 {
+		return nil
+	}
+
+	values := make([]string, len(signals))
+	params := dbx.Params{}
+	for i, signal := range signals {
+		values[i] = fmt.Sprintf("({:id%[1]d}, {:radio%[1]d}, {:mcc%[1]d}, {:mnc%[1]d}, {:lac%[1]d}, {:cid%[1]d}, "+
+			"{:first_seen%[1]d}, {:last_seen%[1]d}, {:hit_count%[1]d}, {:max_strength%[1]d})", i)
+		for column, value := range map[string]interface{}{
+			"id":           signal.Id,
+			"radio":        signal.Radio,
+			"mcc":          signal.Mcc,
+			"mnc":          signal.Mnc,
+			"lac":          signal.Lac,
+			"cid":          signal.Cid,
+			"first_seen":   signal.FirstSeen,
+			"last_seen":    signal.LastSeen,
+			"hit_count":    signal.HitCount,
+			"max_strength": signal.MaxStrength,
+		} {
+			params[fmt.Sprintf("%s%d", column, i)] = value
+		}
+	}
+
+	table := models.UnknownSignal{}.TableName()
+	_, err := ctx.Db().NewQuery("INSERT INTO " + table +
+		" (id, radio, mcc, mnc, lac, cid, first_seen, last_seen, hit_count, max_strength) VALUES " +
+		strings.Join(values, ", ") + " ON CONFLICT (id) DO UPDATE SET " +
+		"first_seen = LEAST(" + table + ".first_seen, EXCLUDED.first_seen), " +
+		"last_seen = GREATEST(" + table + ".last_seen, EXCLUDED.last_seen), " +
+		"hit_count = " + table + ".hit_count + EXCLUDED.hit_count, " +
+		"max_strength = GREATEST(" + table + ".max_strength, EXCLUDED.max_strength)").Bind(params).Execute()
 
-	return stations, err
+	return err
 }
 
-func (repo *defaultPositionRepo) RecordUnknownSignals(ctx app.RequestScope, signals []apis.Signal) {
-	createdAt := time.Now().Format("2006-01-02 15:04:05")
-	for _, signal := range signals {
-		if _, err := ctx.Db().Insert("unknown_signals", dbx.Params{
-			"mnc":        signal.Mnc,
-			"lac":        signal.Lac,
-			"cid":        signal.Cid,
-			"created_at": createdAt,
-		}).Execute(); err != nil {
-			log.Errorf("failed to persist unknown signal(%s): %s", signal, err)
+// FindAreas finds areas that signals are in, each bounded by stations located in it
+func (repo *defaultPositionRepo) FindAreas(ctx app.RequestScope, signals []apis.Signal) ([]models.Area, error) {
+	if len(signals) == 0 {
+		return nil, nil
+	}
+
+	values := make([]string, len(signals))
+	params := dbx.Params{}
+	for i, signal := range signals {
+		values[i] = fmt.Sprintf("({:radio%[1]d}, {:mcc%[1]d}, {:mnc%[1]d}, {:lac%[1]d})", i)
+		params[fmt.Sprintf("radio%d", i)] = signal.Radio
+		params[fmt.Sprintf("mcc%d", i)] = signal.Mcc
+		params[fmt.Sprintf("mnc%d", i)] = signal.Mnc
+		params[fmt.Sprintf("lac%d", i)] = signal.Lac
+	}
+
+	// positions of stations are summed up as unit vectors, the same as models.Area.Add does
+	rows, err := ctx.Db().NewQuery("SELECT radio, mcc, mnc, lac, " +
+		"SUM(COS(RADIANS(lat)) * COS(RADIANS(lng))), SUM(COS(RADIANS(lat)) * SIN(RADIANS(lng))), " +
+		"SUM(SIN(RADIANS(lat))), COUNT(*) FROM " + models.Station{}.TableName() +
+		" WHERE (radio, mcc, mnc, lac) IN (" + strings.Join(values, ", ") + ") GROUP BY radio, mcc, mnc, lac").
+		Bind(params).Rows()
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var areas []models.Area
+	for rows.Next() {
+		var area models.Area
+		if err := rows.Scan(&area.Radio, &area.Mcc, &area.Mnc, &area.Lac,
+			&area.X, &area.Y, &area.Z, &area.Stations); err != nil {
+			return nil, err
 		}
+		areas = append(areas, area)
 	}
+
+	return areas, rows.Err()
 }
 
 // NewPositionRepo create instance of PositionRepo
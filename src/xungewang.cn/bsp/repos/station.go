@@ -0,0 +1,124 @@
+package repos
+
+import (
+	"fmt"
+	"github.com/go-ozzo/ozzo-dbx"
+	"strings"
+	"xungewang.cn/bsp/app"
+	"xungewang.cn/bsp/models"
+)
+
+type (
+	StationRepo interface {
+		Get(ctx app.RequestScope, id string) (*models.Station, error)
+		Count(ctx app.RequestScope, filter *models.StationFilter) (int, error)
+		Query(ctx app.RequestScope, filter *models.StationFilter, offset, limit int) ([]models.Station, error)
+		Create(ctx app.RequestScope, station *models.Station) error
+		Update(ctx app.RequestScope, station *models.Station) error
+		Delete(ctx app.RequestScope, id string) error
+		// Upsert creates stations, or updates coordinates, range, samples and source of those already existing
+		Upsert(ctx app.RequestScope, stations []models.Station) error
+	}
+
+	defaultStationRepo struct{}
+)
+
+func (repo *defaultStationRepo) Get(ctx app.RequestScope, id string) (*models.Station, error) {
+	var station models.Station
+	err := ctx.Db().Select().Model(id, &station)
+
+	return &station, err
+}
+
+func (repo *defaultStationRepo) Count(ctx app.RequestScope, filter *models.StationFilter) (int, error) {
+	var count int
+	err := ctx.Db().Select("COUNT(*)").From(models.Station{}.TableName()).Where(buildStationFilter(filter)).Row(&count)
+
+	return count, err
+}
+
+func (repo *defaultStationRepo) Query(ctx app.RequestScope, filter *models.StationFilter, offset, limit int) ([]models.Station, error) {
+	stations := []models.Station{}
+	err := ctx.Db().Select().Where(buildStationFilter(filter)).OrderBy("id").
+		Offset(int64(offset)).Limit(int64(limit)).All(&stations)
+
+	return stations, err
+}
+
+func (repo *defaultStationRepo) Create(ctx app.RequestScope, station *models.Station) error {
+	return ctx.Db().Model(station).Insert()
+}
+
+func (repo *defaultStationRepo) Update(ctx app.RequestScope, station *models.Station) error {
+	return ctx.Db().Model(station).Update()
+}
+
+func (repo *defaultStationRepo) Delete(ctx app.RequestScope, id string) error {
+	station, err := repo.Get(ctx, id)
+	if err != nil {
+		return err
+	}
+
+	return ctx.Db().Model(station).Delete()
+}
+
+// Upsert writes all stations with one statement. Stations should have distinct ids, since a row
+// can't be affected twice by the same statement. Stations located by hand (of source manual) are kept as they are.
+func (repo *defaultStationRepo) Upsert(ctx app.RequestScope, stations []models.Station) error {
+	if len(stations) == 0 {
+		return nil
+	}
+
+	values := make([]string, len(stations))
+	params := dbx.Params{}
+	for i, station := range stations {
+		values[i] = fmt.Sprintf("({:id%[1]d}, {:radio%[1]d}, {:mcc%[1]d}, {:mnc%[1]d}, {:lac%[1]d}, {:cid%[1]d}, "+
+			"{:lat%[1]d}, {:lng%[1]d}, {:range%[1]d}, {:samples%[1]d}, {:source%[1]d})", i)
+		for column, value := range map[string]interface{}{
+			"id": station.Id, "radio": station.Radio, "mcc": station.Mcc, "mnc": station.Mnc,
+			"lac": station.Lac, "cid": station.Cid, "lat": station.Lat, "lng": station.Lng,
+			"range": station.Range, "samples": station.Samples, "source": station.Source,
+		} {
+			params[fmt.Sprintf("%s%d", column, i)] = value
+		}
+	}
+	params["manual"] = models.SourceManual
+
+	table := models.Station{}.TableName()
+	_, err := ctx.Db().NewQuery("INSERT INTO " + table +
+		" (id, radio, mcc, mnc, lac, cid, lat, lng, range, samples, source) VALUES " + strings.Join(values, ", ") +
+		" ON CONFLICT (id) DO UPDATE SET lat = EXCLUDED.lat, lng = EXCLUDED.lng, range = EXCLUDED.range, " +
+		"samples = EXCLUDED.samples, source = EXCLUDED.source WHERE " + table + ".source <> {:manual}").Bind(params).Execute()
+
+	return err
+}
+
+// build where clause from filter
+func buildStationFilter(filter *models.StationFilter) dbx.Expression {
+	conditions := dbx.HashExp{}
+	for column, value := range map[string]string{
+		"radio": filter.Radio, "mcc": filter.Mcc, "mnc": filter.Mnc, "lac": filter.Lac,
+	} {
+		if value != "" {
+			conditions[column] = value
+		}
+	}
+
+	if filter.Bounds == nil {
+		return conditions
+	}
+
+	bounds := filter.Bounds
+	lngCondition := dbx.Between("lng", bounds.MinLng, bounds.MaxLng)
+	if bounds.MinLng > bounds.MaxLng { // across the antimeridian
+		lngCondition = dbx.NewExp("lng >= {:min_lng} OR lng <= {:max_lng}",
+			dbx.Params{"min_lng": bounds.MinLng, "max_lng": bounds.MaxLng})
+	}
+
+	return dbx.And(conditions, dbx.Between("lat", bounds.MinLat, bounds.MaxLat), lngCondition)
+}
+
+// NewStationRepo create instance of StationRepo
+func NewStationRepo() *defaultStationRepo {
+	return &defaultStationRepo{}
+}
@@ -0,0 +1,182 @@
+package repos
+
+import (
+	"container/list"
+	"sync"
+	"time"
+	"xungewang.cn/bsp/apis"
+	"xungewang.cn/bsp/app"
+	"xungewang.cn/bsp/models"
+)
+
+type (
+	// StationCache is a PositionRepo decorator caching stations found by id, in a LRU cache whose entries expire
+	// after ttl. Stations not found are cached as well, so that unknown cells don't hit the database over and over.
+	StationCache struct {
+		PositionRepo
+
+		size int
+		ttl  time.Duration
+		now  func() time.Time
+
+		mutex   sync.Mutex
+		entries map[string]*list.Element
+		lru     *list.List // of *cacheEntry, the most recently used at front
+
+		// increased on every invalidation, so that stations found before it don't get cached after it
+		generation uint64
+
+		hits        uint64
+		misses      uint64
+		evictions   uint64
+		expirations uint64
+	}
+
+	cacheEntry struct {
+		id        string
+		station   *models.Station // nil if not found
+		expiresAt time.Time
+	}
+
+	// StationCacheStats tells how StationCache is doing
+	StationCacheStats struct {
+		Size     int    `json:"size"`
+		Capacity int    `json:"capacity"`
+		Hits     uint64 `json:"hits"`
+		Misses   uint64 `json:"misses"`
+		// entries evicted for the cache being full, and those dropped for being expired
+		Evictions   uint64 `json:"evictions"`
+		Expirations uint64 `json:"expirations"`
+	}
+)
+
+// FindStations finds stations from the cache, and those missed from the underlying repo
+func (cache *StationCache) FindStations(ctx app.RequestScope, signals map[string]apis.Signal) ([]models.Station, error) {
+	stations := make([]models.Station, 0, len(signals))
+	missed := make(map[string]apis.Signal)
+
+	cache.mutex.Lock()
+	now, generation := cache.now(), cache.generation
+	for id, signal := range signals {
+		if entry, ok := cache.get(id, now); ok {
+			if entry.station != nil {
+				stations = append(stations, *entry.station)
+			}
+		} else {
+			missed[id] = signal
+		}
+	}
+	cache.mutex.Unlock()
+
+	if len(missed) == 0 {
+		return stations, nil
+	}
+
+	found, err := cache.PositionRepo.FindStations(ctx, missed)
+	if err != nil {
+		return nil, err
+	}
+	stations = append(stations, found...)
+
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	if generation != cache.generation { // invalidated meanwhile, what's found may be stale
+		return stations, nil
+	}
+
+	expiresAt := cache.now().Add(cache.ttl)
+	for i := range found {
+		station := found[i]
+		cache.put(station.Id, &station, expiresAt)
+		delete(missed, station.Id)
+	}
+	for id := range missed {
+		cache.put(id, nil, expiresAt)
+	}
+
+	return stations, nil
+}
+
+// Invalidate removes stations from the cache, which is called once they're changed
+func (cache *StationCache) Invalidate(ids ...string) {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	cache.generation++
+	for _, id := range ids {
+		if element, ok := cache.entries[id]; ok {
+			cache.remove(element)
+		}
+	}
+}
+
+// Stats returns current stats of the cache
+func (cache *StationCache) Stats() StationCacheStats {
+	cache.mutex.Lock()
+	defer cache.mutex.Unlock()
+
+	return StationCacheStats{
+		Size:        cache.lru.Len(),
+		Capacity:    cache.size,
+		Hits:        cache.hits,
+		Misses:      cache.misses,
+		Evictions:   cache.evictions,
+		Expirations: cache.expirations,
+	}
+}
+
+// get looks up an entry not expired, which should be called with mutex held
+func (cache *StationCache) get(id string, now time.Time) (*cacheEntry, bool) {
+	element, ok := cache.entries[id]
+	if !ok {
+		cache.misses++
+		return nil, false
+	}
+
+	entry := element.Value.(*cacheEntry)
+	if !now.Before(entry.expiresAt) {
+		cache.remove(element)
+		cache.expirations++
+		cache.misses++
+		return nil, false
+	}
+
+	cache.lru.MoveToFront(element)
+	cache.hits++
+	return entry, true
+}
+
+// put adds or replaces an entry, with the least recently used one evicted if the cache is full. It should be
+// called with mutex held.
+func (cache *StationCache) put(id string, station *models.Station, expiresAt time.Time) {
+	if element, ok := cache.entries[id]; ok {
+		entry := element.Value.(*cacheEntry)
+		entry.station, entry.expiresAt = station, expiresAt
+		cache.lru.MoveToFront(element)
+		return
+	}
+
+	if cache.lru.Len() >= cache.size {
+		cache.remove(cache.lru.Back())
+		cache.evictions++
+	}
+	cache.entries[id] = cache.lru.PushFront(&cacheEntry{id, station, expiresAt})
+}
+
+func (cache *StationCache) remove(element *list.Element) {
+	cache.lru.Remove(element)
+	delete(cache.entries, element.Value.(*cacheEntry).id)
+}
+
+// NewStationCache creates a StationCache in front of repo, which holds up to size stations for ttl
+func NewStationCache(repo PositionRepo, size int, ttl time.Duration) *StationCache {
+	return &StationCache{
+		PositionRepo: repo,
+		size:         size,
+		ttl:          ttl,
+		now:          time.Now,
+		entries:      make(map[string]*list.Element, size),
+		lru:          list.New(),
+	}
+}
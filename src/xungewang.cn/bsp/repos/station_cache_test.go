@@ -0,0 +1,128 @@
+package repos
+
+import (
+	"testing"
+	"time"
+	"xungewang.cn/bsp/apis"
+	"xungewang.cn/bsp/app"
+	"xungewang.cn/bsp/models"
+)
+
+// mockPositionRepo finds stations from a map, and counts stations looked up
+type mockPositionRepo struct {
+	stations map[string]models.Station
+	lookups  int
+}
+
+func (repo *mockPositionRepo) FindStations(ctx app.RequestScope, signals map[string]apis.Signal) ([]models.Station, error) {
+	var stations []models.Station
+	for id := range signals {
+		repo.lookups++
+		if station, ok := repo.stations[id]; ok {
+			stations = append(stations, station)
+		}
+	}
+	return stations, nil
+}
+func (repo *mockPositionRepo) RecordUnknownSignals(ctx app.RequestScope, signals []models.UnknownSignal) error {
+	return nil
+}
+func (repo *mockPositionRepo) FindAreas(ctx app.RequestScope, signals []apis.Signal) ([]models.Area, error) {
+	return nil, nil
+}
+
+var (
+	known   = apis.Signal{Radio: apis.RadioGSM, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60122"}
+	unknown = apis.Signal{Radio: apis.RadioGSM, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60123"}
+	other   = apis.Signal{Radio: apis.RadioGSM, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60124"}
+)
+
+func newMockPositionRepo() *mockPositionRepo {
+	return &mockPositionRepo{stations: map[string]models.Station{
+		"460-0-32838-60122": {Id: "460-0-32838-60122", Lat: 30.732796, Lng: 103.962357},
+		"460-0-32838-60124": {Id: "460-0-32838-60124", Lat: 30.734688, Lng: 103.961433},
+	}}
+}
+
+func TestStationCache_FindStations(t *testing.T) {
+	repo := newMockPositionRepo()
+	cache := NewStationCache(repo, 10, time.Minute)
+	signals := map[string]apis.Signal{"460-0-32838-60122": known, "460-0-32838-60123": unknown}
+
+	for i := 0; i < 3; i++ {
+		stations, err := cache.FindStations(nil, signals)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(stations) != 1 || stations[0].Lat != 30.732796 {
+			t.Errorf("unexpected stations: %v", stations)
+		}
+	}
+
+	// both the station found and the one not found are cached
+	if repo.lookups != 2 {
+		t.Errorf("stations should be looked up once, got %d lookups", repo.lookups)
+	}
+	if stats := cache.Stats(); stats.Hits != 4 || stats.Misses != 2 || stats.Size != 2 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestStationCache_FindStations_Expired(t *testing.T) {
+	repo := newMockPositionRepo()
+	cache := NewStationCache(repo, 10, time.Minute)
+	now := time.Now()
+	cache.now = func() time.Time { return now }
+
+	signals := map[string]apis.Signal{"460-0-32838-60122": known}
+	cache.FindStations(nil, signals)
+
+	now = now.Add(time.Minute)
+	if stations, _ := cache.FindStations(nil, signals); len(stations) != 1 || repo.lookups != 2 {
+		t.Errorf("expired station should be looked up again, got %v with %d lookups", stations, repo.lookups)
+	}
+	if stats := cache.Stats(); stats.Expirations != 1 || stats.Hits != 0 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestStationCache_FindStations_Evicted(t *testing.T) {
+	repo := newMockPositionRepo()
+	cache := NewStationCache(repo, 2, time.Minute)
+
+	cache.FindStations(nil, map[string]apis.Signal{"460-0-32838-60122": known})
+	cache.FindStations(nil, map[string]apis.Signal{"460-0-32838-60123": unknown})
+	cache.FindStations(nil, map[string]apis.Signal{"460-0-32838-60122": known}) // the unknown one is the least recent
+	cache.FindStations(nil, map[string]apis.Signal{"460-0-32838-60124": other})
+
+	if stats := cache.Stats(); stats.Evictions != 1 || stats.Size != 2 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+
+	repo.lookups = 0
+	cache.FindStations(nil, map[string]apis.Signal{"460-0-32838-60122": known})
+	if repo.lookups != 0 {
+		t.Error("the recently used station shouldn't be evicted")
+	}
+	cache.FindStations(nil, map[string]apis.Signal{"460-0-32838-60123": unknown})
+	if repo.lookups != 1 {
+		t.Error("the least recently used station should be evicted")
+	}
+}
+
+func TestStationCache_Invalidate(t *testing.T) {
+	repo := newMockPositionRepo()
+	cache := NewStationCache(repo, 10, time.Minute)
+	signals := map[string]apis.Signal{"460-0-32838-60123": unknown}
+
+	if stations, _ := cache.FindStations(nil, signals); len(stations) != 0 {
+		t.Fatalf("unexpected stations: %v", stations)
+	}
+
+	// the station gets created, which is cached as not found
+	repo.stations["460-0-32838-60123"] = models.Station{Id: "460-0-32838-60123", Lat: 30.730850, Lng: 103.965279}
+	cache.Invalidate("460-0-32838-60123")
+	if stations, _ := cache.FindStations(nil, signals); len(stations) != 1 {
+		t.Errorf("station created should be found once invalidated, got %v", stations)
+	}
+}
@@ -0,0 +1,178 @@
+package repos
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"sync"
+	"sync/atomic"
+	"time"
+	"xungewang.cn/bsp/apis"
+	"xungewang.cn/bsp/app"
+	"xungewang.cn/bsp/models"
+)
+
+type (
+	// StationIndex is a PositionRepo finding stations from an in-memory snapshot of all stations, rather than
+	// querying against the database for every request. Snapshots are reloaded as a whole and swapped atomically,
+	// hence requests always see a consistent snapshot, and keep being served while the database is down.
+	// Areas (which positioning falls back on) are indexed as well, while unknown signals are still recorded into
+	// the database.
+	StationIndex struct {
+		defaultPositionRepo
+
+		snapshot atomic.Value // *stationSnapshot
+		loading  sync.Mutex   // one reload at a time
+	}
+
+	stationSnapshot struct {
+		stations map[string]indexedStation
+		// areas keyed by radio type, MCC, MNC and LAC, see areaKey
+		areas    map[string]*models.Area
+		loadedAt time.Time
+		loadTime time.Duration
+	}
+
+	// station as compact as positioning needs
+	indexedStation struct {
+		radio    string
+		lat, lng float64
+	}
+
+	// StationIndexStats tells how StationIndex is doing
+	StationIndexStats struct {
+		Stations int       `json:"stations"`
+		LoadedAt time.Time `json:"loaded_at"`
+		// seconds taken to load the snapshot
+		LoadTime float64 `json:"load_time"`
+	}
+)
+
+func (index *StationIndex) FindStations(ctx app.RequestScope, signals map[string]apis.Signal) ([]models.Station, error) {
+	snapshot := index.current()
+
+	stations := make([]models.Station, 0, len(signals))
+	for id, signal := range signals {
+		if station, ok := snapshot.stations[id]; ok && station.radio == signal.Radio {
+			stations = append(stations, models.Station{Id: id, Radio: station.radio, Lat: station.lat, Lng: station.lng})
+		}
+	}
+
+	return stations, nil
+}
+
+// FindAreas finds areas that signals are in from the snapshot, the same way defaultPositionRepo does
+func (index *StationIndex) FindAreas(ctx app.RequestScope, signals []apis.Signal) ([]models.Area, error) {
+	snapshot := index.current()
+
+	var areas []models.Area
+	found := make(map[string]bool, len(signals))
+	for _, signal := range signals {
+		key := areaKey(signal.Radio, signal.Mcc, signal.Mnc, signal.Lac)
+		if area, ok := snapshot.areas[key]; ok && !found[key] {
+			found[key] = true
+			areas = append(areas, *area)
+		}
+	}
+
+	return areas, nil
+}
+
+// Reload loads all stations into a new snapshot, which replaces the current one once loaded. The current one is
+// kept if loading fails. It returns the number of stations loaded.
+func (index *StationIndex) Reload(ctx app.RequestScope) (int, error) {
+	index.loading.Lock()
+	defer index.loading.Unlock()
+
+	start := time.Now()
+	rows, err := ctx.Db().Select("id", "radio", "mcc", "mnc", "lac", "lat", "lng").From(models.Station{}.TableName()).Rows()
+	if err != nil {
+		return 0, err
+	}
+	defer rows.Close()
+
+	// size the new snapshot as the current one to avoid growing the map over and over
+	current := index.current()
+	stations := make(map[string]indexedStation, len(current.stations))
+	areas := make(map[string]*models.Area, len(current.areas))
+	radios := make(map[string]string) // radio types interned, which are shared among stations
+	for rows.Next() {
+		var id, radio, mcc, mnc, lac string
+		var lat, lng float64
+		if err := rows.Scan(&id, &radio, &mcc, &mnc, &lac, &lat, &lng); err != nil {
+			return 0, err
+		}
+
+		if interned, ok := radios[radio]; ok {
+			radio = interned
+		} else {
+			radios[radio] = radio
+		}
+		stations[id] = indexedStation{radio, lat, lng}
+		addToArea(areas, radio, mcc, mnc, lac, lat, lng)
+	}
+	if err := rows.Err(); err != nil {
+		return 0, err
+	}
+
+	snapshot := &stationSnapshot{stations: stations, areas: areas, loadedAt: time.Now(), loadTime: time.Since(start)}
+	index.snapshot.Store(snapshot)
+	log.Infof("%d stations of %d areas loaded into index in %s", len(stations), len(areas), snapshot.loadTime)
+
+	return len(stations), nil
+}
+
+// Start reloads the index every interval, until stop is closed
+func (index *StationIndex) Start(ctx app.RequestScope, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			if _, err := index.Reload(ctx); err != nil {
+				log.Errorf("failed to reload station index, the one loaded at %s is kept: %s",
+					index.current().loadedAt, err)
+			}
+		}
+	}
+}
+
+// Stats returns stats of the current snapshot
+func (index *StationIndex) Stats() StationIndexStats {
+	snapshot := index.current()
+
+	return StationIndexStats{
+		Stations: len(snapshot.stations),
+		LoadedAt: snapshot.loadedAt,
+		LoadTime: snapshot.loadTime.Seconds(),
+	}
+}
+
+// addToArea adds the station to the area it's in, which is created if not there yet
+func addToArea(areas map[string]*models.Area, radio, mcc, mnc, lac string, lat, lng float64) {
+	key := areaKey(radio, mcc, mnc, lac)
+	area, ok := areas[key]
+	if !ok {
+		area = &models.Area{Radio: radio, Mcc: mcc, Mnc: mnc, Lac: lac}
+		areas[key] = area
+	}
+
+	area.Add(lat, lng)
+}
+
+func areaKey(radio, mcc, mnc, lac string) string {
+	return radio + "-" + mcc + "-" + mnc + "-" + lac
+}
+
+func (index *StationIndex) current() *stationSnapshot {
+	return index.snapshot.Load().(*stationSnapshot)
+}
+
+// NewStationIndex creates an empty StationIndex, which should be loaded by Reload before use
+func NewStationIndex() *StationIndex {
+	index := &StationIndex{}
+	index.snapshot.Store(&stationSnapshot{stations: map[string]indexedStation{}, areas: map[string]*models.Area{}})
+
+	return index
+}
@@ -0,0 +1,71 @@
+package repos
+
+import (
+	"testing"
+	"xungewang.cn/bsp/apis"
+	"xungewang.cn/bsp/models"
+)
+
+func TestStationIndex_FindStations(t *testing.T) {
+	index := NewStationIndex()
+	index.snapshot.Store(&stationSnapshot{stations: map[string]indexedStation{
+		"460-0-32838-60122":        {"gsm", 30.732796, 103.962357},
+		"lte-460-0-6334-127502337": {"lte", 30.572815, 104.065735},
+	}})
+
+	stations, err := index.FindStations(nil, map[string]apis.Signal{
+		"460-0-32838-60122":        {Radio: apis.RadioGSM, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60122"},
+		"lte-460-0-6334-127502337": {Radio: apis.RadioLTE, Mcc: "460", Mnc: "0", Lac: "6334", Cid: "127502337"},
+		"460-0-32838-60123":        {Radio: apis.RadioGSM, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60123"}, // unknown
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(stations) != 2 {
+		t.Fatalf("2 stations expected, got %v", stations)
+	}
+	for _, station := range stations {
+		if station.Id == "460-0-32838-60122" && (station.Lat != 30.732796 || station.Lng != 103.962357) {
+			t.Errorf("unexpected station: %v", station)
+		}
+	}
+
+	if stats := index.Stats(); stats.Stations != 2 {
+		t.Errorf("unexpected stats: %+v", stats)
+	}
+}
+
+func TestStationIndex_FindStations_Empty(t *testing.T) {
+	stations, err := NewStationIndex().FindStations(nil, map[string]apis.Signal{
+		"460-0-32838-60122": {Radio: apis.RadioGSM, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60122"},
+	})
+	if err != nil || len(stations) != 0 {
+		t.Errorf("no station should be found from an empty index, got %v, %v", stations, err)
+	}
+}
+
+func TestStationIndex_FindAreas(t *testing.T) {
+	areas := map[string]*models.Area{}
+	addToArea(areas, "gsm", "460", "0", "32838", 30.732796, 103.962357)
+	addToArea(areas, "gsm", "460", "0", "32838", 30.734688, 103.961433)
+	addToArea(areas, "lte", "460", "0", "32838", 30.572815, 104.065735)
+	index := NewStationIndex()
+	index.snapshot.Store(&stationSnapshot{areas: areas})
+
+	found, err := index.FindAreas(nil, []apis.Signal{
+		{Radio: apis.RadioGSM, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "1"},
+		{Radio: apis.RadioGSM, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "2"},  // the same area
+		{Radio: apis.RadioUMTS, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "1"}, // unknown
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := models.Area{Radio: "gsm", Mcc: "460", Mnc: "0", Lac: "32838"}
+	expected.Add(30.732796, 103.962357)
+	expected.Add(30.734688, 103.961433)
+	if len(found) != 1 || found[0] != expected {
+		t.Errorf("unexpected areas %+v", found)
+	}
+}
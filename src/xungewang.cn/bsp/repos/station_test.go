@@ -0,0 +1,44 @@
+package repos
+
+import (
+	"github.com/go-ozzo/ozzo-dbx"
+	"testing"
+	"xungewang.cn/bsp/models"
+)
+
+func TestBuildStationFilter(t *testing.T) {
+	db := dbx.NewFromDB(nil, "postgres") // only to quote names, never connected
+	tests := []struct {
+		tag      string
+		filter   models.StationFilter
+		sql      string
+		expected dbx.Params
+	}{
+		{"all", models.StationFilter{}, "", dbx.Params{}},
+		{"identifiers", models.StationFilter{Radio: "gsm", Mcc: "460", Mnc: "0", Lac: "32838"},
+			`"lac"={:p0} AND "mcc"={:p1} AND "mnc"={:p2} AND "radio"={:p3}`,
+			dbx.Params{"p0": "32838", "p1": "460", "p2": "0", "p3": "gsm"}},
+		{"bbox and radio", models.StationFilter{Radio: "lte", Bounds: &models.Bounds{MinLat: 30, MinLng: 103, MaxLat: 31,
+			MaxLng: 105}}, `("radio"={:p0}) AND ("lat" BETWEEN {:p1} AND {:p2}) AND ("lng" BETWEEN {:p3} AND {:p4})`,
+			dbx.Params{"p0": "lte", "p1": 30.0, "p2": 31.0, "p3": 103.0, "p4": 105.0}},
+		{"bbox across the antimeridian", models.StationFilter{Bounds: &models.Bounds{MinLat: 60, MinLng: 170, MaxLat: 70,
+			MaxLng: -170}}, `("lat" BETWEEN {:p0} AND {:p1}) AND (lng >= {:min_lng} OR lng <= {:max_lng})`,
+			dbx.Params{"p0": 60.0, "p1": 70.0, "min_lng": 170.0, "max_lng": -170.0}},
+	}
+	for _, test := range tests {
+		params := dbx.Params{}
+		if sql := buildStationFilter(&test.filter).Build(db, params); sql != test.sql {
+			t.Errorf("%s: built %s, expecting %s", test.tag, sql, test.sql)
+		}
+		if len(params) != len(test.expected) {
+			t.Errorf("%s: bound %v, expecting %v", test.tag, params, test.expected)
+			continue
+		}
+		for name, value := range test.expected {
+			if params[name] != value {
+				t.Errorf("%s: bound %v, expecting %v", test.tag, params, test.expected)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,59 @@
+package repos
+
+import (
+	"github.com/go-ozzo/ozzo-dbx"
+	"xungewang.cn/bsp/app"
+	"xungewang.cn/bsp/models"
+)
+
+type (
+	UnknownSignalRepo interface {
+		Count(ctx app.RequestScope, filter *models.UnknownSignalFilter) (int, error)
+		// QueryTop queries unknown signals in descending order of hit count
+		QueryTop(ctx app.RequestScope, filter *models.UnknownSignalFilter, offset, limit int) ([]models.UnknownSignal, error)
+	}
+
+	defaultUnknownSignalRepo struct{}
+)
+
+func (repo *defaultUnknownSignalRepo) Count(ctx app.RequestScope, filter *models.UnknownSignalFilter) (int, error) {
+	var count int
+	err := ctx.Db().Select("COUNT(*)").From(models.UnknownSignal{}.TableName()).
+		Where(buildUnknownSignalFilter(filter)).Row(&count)
+
+	return count, err
+}
+
+func (repo *defaultUnknownSignalRepo) QueryTop(ctx app.RequestScope, filter *models.UnknownSignalFilter, offset, limit int) ([]models.UnknownSignal, error) {
+	signals := []models.UnknownSignal{}
+	err := selectTopUnknownSignals(ctx.Db(), filter, offset, limit).All(&signals)
+
+	return signals, err
+}
+
+// build the query of unknown signals in descending order of hit count, then by id
+func selectTopUnknownSignals(db dbx.Builder, filter *models.UnknownSignalFilter, offset, limit int) *dbx.SelectQuery {
+	return db.Select().From(models.UnknownSignal{}.TableName()).Where(buildUnknownSignalFilter(filter)).
+		OrderBy("hit_count DESC", "id").Offset(int64(offset)).Limit(int64(limit))
+}
+
+// build where clause from filter
+func buildUnknownSignalFilter(filter *models.UnknownSignalFilter) dbx.Expression {
+	conditions := dbx.HashExp{}
+	for column, value := range map[string]string{"radio": filter.Radio, "mcc": filter.Mcc, "mnc": filter.Mnc} {
+		if value != "" {
+			conditions[column] = value
+		}
+	}
+
+	if filter.Since == nil {
+		return conditions
+	}
+
+	return dbx.And(conditions, dbx.NewExp("last_seen >= {:since}", dbx.Params{"since": *filter.Since}))
+}
+
+// NewUnknownSignalRepo create instance of UnknownSignalRepo
+func NewUnknownSignalRepo() *defaultUnknownSignalRepo {
+	return &defaultUnknownSignalRepo{}
+}
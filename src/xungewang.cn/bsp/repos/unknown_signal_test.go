@@ -0,0 +1,47 @@
+package repos
+
+import (
+	"github.com/go-ozzo/ozzo-dbx"
+	"testing"
+	"time"
+	"xungewang.cn/bsp/models"
+)
+
+func TestSelectTopUnknownSignals(t *testing.T) {
+	db := dbx.NewFromDB(nil, "postgres") // only to quote names, never connected
+	since := time.Date(2017, 1, 2, 0, 0, 0, 0, time.UTC)
+	tests := []struct {
+		tag      string
+		filter   models.UnknownSignalFilter
+		sql      string
+		expected dbx.Params
+	}{
+		{"all", models.UnknownSignalFilter{},
+			`SELECT * FROM "unknown_signals" ORDER BY "hit_count" DESC, "id" LIMIT 10 OFFSET 20`, dbx.Params{}},
+		{"network", models.UnknownSignalFilter{Radio: "gsm", Mcc: "460", Mnc: "1"},
+			`SELECT * FROM "unknown_signals" WHERE "mcc"={:p0} AND "mnc"={:p1} AND "radio"={:p2} ` +
+				`ORDER BY "hit_count" DESC, "id" LIMIT 10 OFFSET 20`,
+			dbx.Params{"p0": "460", "p1": "1", "p2": "gsm"}},
+		{"since", models.UnknownSignalFilter{Mcc: "460", Since: &since},
+			`SELECT * FROM "unknown_signals" WHERE ("mcc"={:p0}) AND (last_seen >= {:since}) ` +
+				`ORDER BY "hit_count" DESC, "id" LIMIT 10 OFFSET 20`,
+			dbx.Params{"p0": "460", "since": since}},
+	}
+	for _, test := range tests {
+		query := selectTopUnknownSignals(db, &test.filter, 20, 10).Build()
+		if sql := query.SQL(); sql != test.sql {
+			t.Errorf("%s: built %s, expecting %s", test.tag, sql, test.sql)
+		}
+		params := query.Params()
+		if len(params) != len(test.expected) {
+			t.Errorf("%s: bound %v, expecting %v", test.tag, params, test.expected)
+			continue
+		}
+		for name, value := range test.expected {
+			if params[name] != value {
+				t.Errorf("%s: bound %v, expecting %v", test.tag, params, test.expected)
+				break
+			}
+		}
+	}
+}
@@ -0,0 +1,36 @@
+package services
+
+import (
+	"database/sql"
+	"xungewang.cn/bsp/apis"
+	"xungewang.cn/bsp/app"
+	"xungewang.cn/bsp/errors"
+	"xungewang.cn/bsp/models"
+	"xungewang.cn/bsp/repos"
+)
+
+// cellService should implements apis.cellService interface
+type cellService struct {
+	repo     repos.StationRepo
+	unknowns *UnknownSignalWriter
+}
+
+// Lookup finds the station of a single cell. Cells not found are recorded as unknown signals, the same way
+// positioning does.
+func (service *cellService) Lookup(ctx app.RequestScope, signal apis.Signal) (*models.Station, error) {
+	station, err := service.repo.Get(ctx, buildStationId(signal))
+	if err == sql.ErrNoRows {
+		service.unknowns.Write([]apis.Signal{signal})
+		return nil, errors.NotFound("cell not found")
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return station, nil
+}
+
+// NewCellService create an instance of cellService
+func NewCellService(repo repos.StationRepo, unknowns *UnknownSignalWriter) *cellService {
+	return &cellService{repo, unknowns}
+}
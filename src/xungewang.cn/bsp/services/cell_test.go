@@ -0,0 +1,34 @@
+package services
+
+import (
+	"testing"
+	"time"
+	"xungewang.cn/bsp/apis"
+	"xungewang.cn/bsp/errors"
+	"xungewang.cn/bsp/models"
+)
+
+func TestCellService_Lookup(t *testing.T) {
+	repo := &mockStationRepo{stations: map[string]models.Station{
+		"460-0-32838-60122": {Id: "460-0-32838-60122", Radio: "gsm", Lat: 30.732796, Lng: 103.962357, Range: 1000,
+			Source: models.SourceImport},
+	}}
+	positionRepo := &mockPositionRepo{}
+	unknowns := NewUnknownSignalWriter(positionRepo, nil, 100, 10, time.Second)
+	service := NewCellService(repo, unknowns)
+
+	station, err := service.Lookup(nil, apis.Signal{Radio: apis.RadioGSM, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60122"})
+	if err != nil || station.Lat != 30.732796 || station.Range != 1000 || station.Source != models.SourceImport {
+		t.Errorf("unexpected station %v, error %v", station, err)
+	}
+
+	_, err = service.Lookup(nil, apis.Signal{Radio: apis.RadioLTE, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60122"})
+	if apiError, ok := err.(*errors.APIError); !ok || apiError.Status != 404 {
+		t.Errorf("not found expected, got %v", err)
+	}
+
+	unknowns.Close()
+	if unknown := positionRepo.unknownSignals; len(unknown) != 1 || unknown[0].Id != "lte-460-0-32838-60122" {
+		t.Errorf("unexpected unknown signals: %v", unknown)
+	}
+}
@@ -0,0 +1,32 @@
+package services
+
+import (
+	"xungewang.cn/bsp/app"
+	"xungewang.cn/bsp/models"
+	"xungewang.cn/bsp/repos"
+)
+
+// devicePositionService stores positions of trackers, and queues them to the forwarder (if given), so that
+// trackers never wait for the platform positions are forwarded to
+type devicePositionService struct {
+	repo      repos.DevicePositionRepo
+	forwarder *PositionForwarder
+}
+
+// Record stores the position and queues it to be forwarded
+func (service *devicePositionService) Record(ctx app.RequestScope, position *models.DevicePosition) error {
+	if err := service.repo.Create(ctx, position); err != nil {
+		return err
+	}
+
+	if service.forwarder != nil {
+		service.forwarder.Forward(position)
+	}
+
+	return nil
+}
+
+// NewDevicePositionService create an instance of devicePositionService, with forwarder optional
+func NewDevicePositionService(repo repos.DevicePositionRepo, forwarder *PositionForwarder) *devicePositionService {
+	return &devicePositionService{repo: repo, forwarder: forwarder}
+}
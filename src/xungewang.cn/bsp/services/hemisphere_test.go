@@ -0,0 +1,85 @@
+package services
+
+import (
+	"math"
+	"testing"
+	"xungewang.cn/bsp/app"
+	"xungewang.cn/bsp/models"
+)
+
+// fixtures of cells around the world. Each place has stations placed symmetrically around its center with equal
+// signal strength, hence the center is where the device is expected to be positioned.
+var hemisphereFixtures = []struct {
+	place    string
+	lat, lng float64 // center of stations
+}{
+	{"Chengdu, China (N/E)", 30.732924, 103.962488},
+	{"New York, USA (N/W)", 40.758896, -73.985130},
+	{"Sao Paulo, Brazil (S/W)", -23.561414, -46.655882},
+	{"Sydney, Australia (S/E)", -33.856784, 151.215297},
+	{"Nairobi, Kenya (S/E, near equator)", -1.286389, 36.817223},
+	{"Quito, Ecuador (S/W, near equator)", -0.180653, -78.467834},
+	{"London, UK (across Greenwich)", 51.477928, 0.0},
+	{"Reykjavik, Iceland (far north)", 64.146582, -21.942635},
+	{"Taveuni, Fiji (across antimeridian)", -16.8, 180.0},
+	{"Chukotka, Russia (across antimeridian)", 65.0, -180.0},
+}
+
+// surround (lat, lng) with 4 stations 0.01 degree away in each direction, which may cross the antimeridian
+func stationsAround(lat, lng float64) []*SignalAwareStation {
+	offsets := [][2]float64{{0.01, 0}, {-0.01, 0}, {0, 0.01}, {0, -0.01}}
+	stations := make([]*SignalAwareStation, len(offsets))
+	for i, offset := range offsets {
+		stations[i] = &SignalAwareStation{
+			&models.Station{Id: string('a' + rune(i)), Lat: lat + offset[0], Lng: normalizeLng(lng + offset[1])}, -80,
+		}
+	}
+
+	return stations
+}
+
+func TestDoComputePosition_AroundTheWorld(t *testing.T) {
+	locators := NewLocatorRegistry(NewPathLossModels(app.PathLossConfig{}), AlgorithmWeightedCentroid)
+	for algorithm, locator := range locators.locators {
+		for _, fixture := range hemisphereFixtures {
+			r, err := doComputePosition(stationsAround(fixture.lat, fixture.lng), locator, NewOutlierFilter(3000, 1), nil)
+			if err != nil {
+				t.Errorf("%s (%s): %s", fixture.place, algorithm, err)
+				continue
+			}
+
+			if d := haversine(r.Lat, r.Lng, fixture.lat, fixture.lng); d > 5 {
+				t.Errorf("%s (%s): position %s is %f m away from the expected", fixture.place, algorithm, r, d)
+			}
+			if len(r.Rejected) != 0 {
+				t.Errorf("%s (%s): no station should be rejected", fixture.place, algorithm)
+			}
+			if r.Lng < -180 || r.Lng >= 180 {
+				t.Errorf("%s (%s): longitude %f out of range", fixture.place, algorithm, r.Lng)
+			}
+		}
+	}
+}
+
+func TestSphericalCentroid_Quadrants(t *testing.T) {
+	for _, fixture := range hemisphereFixtures {
+		station := &SignalAwareStation{&models.Station{Lat: fixture.lat, Lng: fixture.lng}, -80}
+		lat, lng := sphericalCentroid([]*SignalAwareStation{station}, nil)
+
+		if math.Abs(lat-fixture.lat) > EPSILON || math.Abs(normalizeLng(lng-fixture.lng)) > EPSILON {
+			t.Errorf("%s: centroid of a single station should be itself, got (%f, %f)", fixture.place, lat, lng)
+		}
+		if lng < -180 || lng >= 180 {
+			t.Errorf("%s: longitude %f out of range", fixture.place, lng)
+		}
+	}
+}
+
+func TestNormalizeLng(t *testing.T) {
+	cases := map[float64]float64{0: 0, 179.5: 179.5, 180: -180, 180.5: -179.5, -180.5: 179.5, 540: -180, -73.9: -73.9}
+	for lng, expected := range cases {
+		if normalized := normalizeLng(lng); math.Abs(normalized-expected) > EPSILON {
+			t.Errorf("%f should be normalized to %f, got %f", lng, expected, normalized)
+		}
+	}
+}
@@ -0,0 +1,268 @@
+package services
+
+import (
+	"encoding/csv"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"io"
+	"strconv"
+	"strings"
+	"xungewang.cn/bsp/app"
+	"xungewang.cn/bsp/coord"
+	"xungewang.cn/bsp/models"
+	"xungewang.cn/bsp/repos"
+)
+
+const (
+	// default and maximum number of stations written in one statement. Each station takes 11 bind
+	// parameters, while PostgreSQL allows at most 65535 of them in a statement.
+	DefaultImportBatchSize = 1000
+	MaxImportBatchSize     = 5900
+)
+
+// columns of cell exports that matter to us. OpenCelliD (cell_towers.csv) and Mozilla Location
+// Service share the same layout:
+//
+//	radio,mcc,net,area,cell,unit,lon,lat,range,samples,changeable,created,updated,averageSignal
+var importColumns = []string{"radio", "mcc", "net", "area", "cell", "lon", "lat"}
+
+// columns imported if present, which are left out by some exports
+var optionalImportColumns = []string{"range", "samples"}
+
+// ImportFilter filters cells being imported, with empty fields not taken into account
+type ImportFilter struct {
+	Radios []string
+	Mccs   []string
+	Mncs   []string
+}
+
+func (filter *ImportFilter) accept(station *models.Station) bool {
+	return filter == nil || (matchAny(filter.Radios, station.Radio) && matchAny(filter.Mccs, station.Mcc) &&
+		matchAny(filter.Mncs, station.Mnc))
+}
+
+func matchAny(candidates []string, value string) bool {
+	if len(candidates) == 0 {
+		return true
+	}
+
+	for _, candidate := range candidates {
+		if candidate == value {
+			return true
+		}
+	}
+
+	return false
+}
+
+// ImportProgress tells how far an import goes
+type ImportProgress struct {
+	Read     int // rows read, header excluded
+	Imported int // stations written
+	Filtered int // rows rejected by filter
+	Invalid  int // rows not parsable or of unsupported radio type (e.g., CDMA)
+}
+
+func (progress ImportProgress) String() string {
+	return fmt.Sprintf("%d read, %d imported, %d filtered, %d invalid",
+		progress.Read, progress.Imported, progress.Filtered, progress.Invalid)
+}
+
+// stationImporter streams cell exports into stations in batches
+type stationImporter struct {
+	repo      repos.StationRepo
+	batchSize int
+	// coordinate type of exports, see coord package
+	coordType string
+	// called after each batch written
+	progress func(ImportProgress)
+}
+
+// Import reads cells from reader and upserts them as stations. Progress so far is returned along with
+// error if any, so that a failed import tells where it stops.
+func (importer *stationImporter) Import(ctx app.RequestScope, reader io.Reader, filter *ImportFilter) (ImportProgress, error) {
+	var progress ImportProgress
+
+	records := csv.NewReader(reader)
+	records.FieldsPerRecord = -1
+	records.ReuseRecord = true
+
+	header, err := records.Read()
+	if err != nil {
+		return progress, fmt.Errorf("failed to read header: %s", err)
+	}
+	indexes, err := indexImportColumns(header)
+	if err != nil {
+		return progress, err
+	}
+
+	batch := newStationBatch(importer.batchSize)
+	flush := func() error {
+		if err := importer.repo.Upsert(ctx, batch.stations); err != nil {
+			return err
+		}
+		progress.Imported += len(batch.stations)
+		batch.reset()
+
+		if importer.progress != nil {
+			importer.progress(progress)
+		}
+		return nil
+	}
+
+	for {
+		record, err := records.Read()
+		if err == io.EOF {
+			break
+		}
+		if _, ok := err.(*csv.ParseError); err != nil && !ok {
+			return progress, err
+		}
+		progress.Read++
+
+		var station *models.Station
+		if err == nil {
+			station, err = parseImportRecord(record, indexes, importer.coordType)
+		}
+		if err != nil {
+			log.Debugf("invalid row %d: %s", progress.Read, err)
+			progress.Invalid++
+			continue
+		}
+		if !filter.accept(station) {
+			progress.Filtered++
+			continue
+		}
+
+		if batch.add(station) {
+			if err := flush(); err != nil {
+				return progress, err
+			}
+		}
+	}
+
+	if len(batch.stations) > 0 {
+		if err := flush(); err != nil {
+			return progress, err
+		}
+	}
+
+	return progress, nil
+}
+
+// indexImportColumns locates columns we're interested in from the header
+func indexImportColumns(header []string) (map[string]int, error) {
+	positions := map[string]int{}
+	for i, column := range header {
+		positions[strings.TrimSpace(strings.ToLower(column))] = i
+	}
+
+	indexes := map[string]int{}
+	for _, column := range importColumns {
+		index, ok := positions[column]
+		if !ok {
+			return nil, fmt.Errorf("column '%s' missing, header should be like '%s'", column,
+				"radio,mcc,net,area,cell,unit,lon,lat,...")
+		}
+		indexes[column] = index
+	}
+	for _, column := range optionalImportColumns {
+		if index, ok := positions[column]; ok {
+			indexes[column] = index
+		}
+	}
+
+	return indexes, nil
+}
+
+// parseImportRecord parses a row of cell exports into a station, whose coordinates are converted from coordType
+// to WGS-84
+func parseImportRecord(record []string, indexes map[string]int, coordType string) (*models.Station, error) {
+	field := func(column string) string {
+		if index, ok := indexes[column]; ok && index < len(record) {
+			return strings.TrimSpace(record[index])
+		}
+		return ""
+	}
+
+	lat, err := strconv.ParseFloat(field("lat"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid lat: %s", err)
+	}
+	lng, err := strconv.ParseFloat(field("lon"), 64)
+	if err != nil {
+		return nil, fmt.Errorf("invalid lon: %s", err)
+	}
+	if lat, lng, err = coord.Convert(lat, lng, coordType, coord.WGS84); err != nil {
+		return nil, err
+	}
+
+	station := &models.Station{
+		Radio:  strings.ToLower(field("radio")),
+		Mcc:    field("mcc"),
+		Mnc:    field("net"),
+		Lac:    field("area"),
+		Cid:    field("cell"),
+		Lat:    lat,
+		Lng:    lng,
+		Source: models.SourceImport,
+	}
+	if value := field("range"); value != "" {
+		if station.Range, err = strconv.Atoi(value); err != nil {
+			return nil, fmt.Errorf("invalid range: %s", err)
+		}
+	}
+	if value := field("samples"); value != "" {
+		if station.Samples, err = strconv.Atoi(value); err != nil {
+			return nil, fmt.Errorf("invalid samples: %s", err)
+		}
+	}
+	station.Id = models.BuildStationId(station.Radio, station.Mcc, station.Mnc, station.Lac, station.Cid)
+
+	return station, station.Validate()
+}
+
+// stationBatch collects stations to be written together. Exports may list a cell more than once,
+// in which case the last one wins.
+type stationBatch struct {
+	size     int
+	stations []models.Station
+	indexes  map[string]int
+}
+
+func newStationBatch(size int) *stationBatch {
+	batch := &stationBatch{size: size}
+	batch.reset()
+
+	return batch
+}
+
+// add adds station to the batch, and tells whether the batch is full
+func (batch *stationBatch) add(station *models.Station) bool {
+	if index, ok := batch.indexes[station.Id]; ok {
+		batch.stations[index] = *station
+	} else {
+		batch.indexes[station.Id] = len(batch.stations)
+		batch.stations = append(batch.stations, *station)
+	}
+
+	return len(batch.stations) >= batch.size
+}
+
+func (batch *stationBatch) reset() {
+	batch.stations = make([]models.Station, 0, batch.size)
+	batch.indexes = map[string]int{}
+}
+
+// NewStationImporter creates an instance of stationImporter, which reads exports in coordType (WGS-84 if empty),
+// writes batchSize stations at a time, and calls progress after each batch written
+func NewStationImporter(repo repos.StationRepo, batchSize int, coordType string, progress func(ImportProgress)) *stationImporter {
+	if batchSize <= 0 {
+		batchSize = DefaultImportBatchSize
+	}
+	if batchSize > MaxImportBatchSize {
+		batchSize = MaxImportBatchSize
+	}
+
+	return &stationImporter{repo: repo, batchSize: batchSize, coordType: coordType, progress: progress}
+}
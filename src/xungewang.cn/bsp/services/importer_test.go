@@ -0,0 +1,167 @@
+package services
+
+import (
+	"database/sql"
+	"math"
+	"sort"
+	"strings"
+	"testing"
+	"xungewang.cn/bsp/app"
+	"xungewang.cn/bsp/coord"
+	"xungewang.cn/bsp/models"
+)
+
+type mockStationRepo struct {
+	stations      map[string]models.Station
+	batches       int
+	filter        *models.StationFilter // of the last count or query
+	offset, limit int
+}
+
+func (repo *mockStationRepo) Get(ctx app.RequestScope, id string) (*models.Station, error) {
+	station, ok := repo.stations[id]
+	if !ok {
+		return nil, sql.ErrNoRows
+	}
+	return &station, nil
+}
+func (repo *mockStationRepo) Count(ctx app.RequestScope, filter *models.StationFilter) (int, error) {
+	repo.filter = filter
+	return len(repo.stations), nil
+}
+
+// Query returns all stations ordered by id, with the filter and page recorded. Filtering is left to repos.
+func (repo *mockStationRepo) Query(ctx app.RequestScope, filter *models.StationFilter, offset, limit int) ([]models.Station, error) {
+	repo.filter, repo.offset, repo.limit = filter, offset, limit
+	var ids []string
+	for id := range repo.stations {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	stations := make([]models.Station, len(ids))
+	for i, id := range ids {
+		stations[i] = repo.stations[id]
+	}
+	return stations, nil
+}
+func (repo *mockStationRepo) Create(ctx app.RequestScope, station *models.Station) error {
+	repo.stations[station.Id] = *station
+	return nil
+}
+func (repo *mockStationRepo) Update(ctx app.RequestScope, station *models.Station) error {
+	repo.stations[station.Id] = *station
+	return nil
+}
+func (repo *mockStationRepo) Delete(ctx app.RequestScope, id string) error {
+	delete(repo.stations, id)
+	return nil
+}
+
+func (repo *mockStationRepo) Upsert(ctx app.RequestScope, stations []models.Station) error {
+	ids := map[string]bool{}
+	for _, station := range stations {
+		if ids[station.Id] {
+			panic("duplicated station in one batch: " + station.Id)
+		}
+		ids[station.Id] = true
+		// stations located by hand are kept, as the repo does
+		if existing, ok := repo.stations[station.Id]; !ok || existing.Source != models.SourceManual {
+			repo.stations[station.Id] = station
+		}
+	}
+	repo.batches++
+	return nil
+}
+
+const cellExport = `radio,mcc,net,area,cell,unit,lon,lat,range,samples,changeable,created,updated,averageSignal
+GSM,460,0,32838,60122,0,103.962357,30.732796,1000,5,1,1459692008,1459692008,0
+GSM,460,0,32838,60123,0,103.961433,30.734688,1000,5,1,1459692008,1459692008,0
+LTE,460,0,6244,84115972,0,104.065735,30.572815,1000,5,1,1459692008,1459692008,0
+UMTS,460,1,41136,25330944,0,121.473701,31.230416,1000,5,1,1459692008,1459692008,0
+CDMA,460,3,13824,3281,0,113.264434,23.129162,1000,5,1,1459692008,1459692008,0
+GSM,460,0,32838,60124,0,0,0,1000,5,1,1459692008,1459692008,0
+GSM,460,0,32838,60122,0,103.962457,30.732896,1000,5,1,1459692009,1459692009,0
+GSM,310,260,1,2,0,-122.419416,37.774929,1000,5,1,1459692008,1459692008,0
+`
+
+func TestStationImporter_Import(t *testing.T) {
+	repo := &mockStationRepo{stations: map[string]models.Station{}}
+	var reported []ImportProgress
+	importer := NewStationImporter(repo, 2, "", func(progress ImportProgress) {
+		reported = append(reported, progress)
+	})
+
+	progress, err := importer.Import(nil, strings.NewReader(cellExport), &ImportFilter{Mccs: []string{"460"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// CDMA and Null Island are invalid, while the US one is filtered out. The duplicated GSM cell
+	// arrives in a later batch, which updates the first one.
+	expected := ImportProgress{Read: 8, Imported: 5, Filtered: 1, Invalid: 2}
+	if progress != expected {
+		t.Errorf("unexpected progress: %s", progress)
+	}
+	if len(repo.stations) != 4 || repo.batches != 3 || len(reported) != 3 {
+		t.Errorf("unexpected stations %d, batches %d, reported %d", len(repo.stations), repo.batches, len(reported))
+	}
+
+	if station := repo.stations["460-0-32838-60122"]; station.Lat != 30.732896 || station.Radio != "gsm" ||
+		station.Range != 1000 || station.Samples != 5 || station.Source != models.SourceImport {
+		t.Errorf("unexpected station: %v", station)
+	}
+	if _, ok := repo.stations["lte-460-0-6244-84115972"]; !ok {
+		t.Error("lte station not imported")
+	}
+	if _, ok := repo.stations["umts-460-1-41136-25330944"]; !ok {
+		t.Error("umts station not imported")
+	}
+}
+
+func TestStationImporter_Import_Filter(t *testing.T) {
+	manual := models.Station{Id: "lte-460-0-6244-84115972", Radio: "lte", Mcc: "460", Mnc: "0", Lac: "6244",
+		Cid: "84115972", Lat: 30.5, Lng: 104, Source: models.SourceManual}
+	repo := &mockStationRepo{stations: map[string]models.Station{manual.Id: manual}}
+	importer := NewStationImporter(repo, 0, "", nil)
+
+	progress, err := importer.Import(nil, strings.NewReader(cellExport),
+		&ImportFilter{Radios: []string{"gsm", "lte"}, Mncs: []string{"0"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// duplicated GSM cell lands in the same batch, in which case the last one wins
+	if progress.Imported != 3 || progress.Filtered != 2 || repo.batches != 1 {
+		t.Errorf("unexpected progress: %s, batches %d", progress, repo.batches)
+	}
+	if station := repo.stations["460-0-32838-60122"]; station.Lat != 30.732896 {
+		t.Errorf("unexpected station: %v", station)
+	}
+	if station := repo.stations[manual.Id]; station != manual {
+		t.Errorf("station located by hand should be kept, got %v", station)
+	}
+}
+
+func TestStationImporter_Import_BadHeader(t *testing.T) {
+	importer := NewStationImporter(&mockStationRepo{stations: map[string]models.Station{}}, 0, "", nil)
+	if _, err := importer.Import(nil, strings.NewReader("mcc,mnc,lac,cid\n460,0,1,2\n"), nil); err == nil {
+		t.Error("header without radio, lon and lat should be rejected")
+	}
+}
+
+func TestStationImporter_Import_CoordType(t *testing.T) {
+	repo := &mockStationRepo{stations: map[string]models.Station{}}
+	importer := NewStationImporter(repo, 0, coord.GCJ02, nil)
+
+	if _, err := importer.Import(nil, strings.NewReader(cellExport), &ImportFilter{Mccs: []string{"460"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	// stations are stored in WGS-84, which convert back to what's imported
+	station := repo.stations["460-0-32838-60122"]
+	if lat, lng := coord.WGS84ToGCJ02(station.Lat, station.Lng); math.Abs(lat-30.732896) > EPSILON ||
+		math.Abs(lng-103.962457) > EPSILON || station.Lat == 30.732896 {
+		t.Errorf("unexpected station converted from GCJ-02: %v", station)
+	}
+}
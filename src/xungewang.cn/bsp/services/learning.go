@@ -0,0 +1,134 @@
+package services
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"math"
+	"time"
+	"xungewang.cn/bsp/app"
+	"xungewang.cn/bsp/models"
+	"xungewang.cn/bsp/repos"
+)
+
+// stationLearner learns locations of stations from crowdsourced observations in the background.
+//
+// A station is located at the weighted centroid of its observations, where an observation weighs the inverse
+// of its expected distance to the station, i.e., the distance estimated from signal strength (stationRange if
+// unknown) plus the accuracy of the GPS fix. Stations already located (e.g., imported) before learned count
+// as one observation of unknown strength. Stations are saved once they're learned from minSamples observations.
+type stationLearner struct {
+	repo       repos.ObservationRepo
+	pathLoss   *pathLossModels
+	minSamples int
+	batchSize  int
+}
+
+// Start learns pending observations every interval, until stop is closed
+func (learner *stationLearner) Start(ctx app.RequestScope, interval time.Duration, stop <-chan struct{}) {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-stop:
+			return
+		case <-ticker.C:
+			learner.LearnAll(ctx)
+		}
+	}
+}
+
+// LearnAll learns pending observations batch by batch, until there's none left
+func (learner *stationLearner) LearnAll(ctx app.RequestScope) {
+	total := 0
+	for {
+		learned, err := learner.repo.Learn(ctx, learner.batchSize, learner.learn)
+		if err != nil {
+			log.Errorf("failed to learn observations: %s", err)
+			break
+		}
+
+		total += learned
+		if learned < learner.batchSize {
+			break
+		}
+	}
+
+	if total > 0 {
+		log.Infof("%d observations learned", total)
+	}
+}
+
+// learn implements repos.LearnFunc
+func (learner *stationLearner) learn(observations []models.Observation, learnings map[string]*models.StationLearning,
+	stations map[string]*models.Station) []models.Station {
+	touched := make(map[string]bool)
+	for _, observation := range observations {
+		learning, ok := learnings[observation.StationId]
+		if !ok {
+			learning = newStationLearning(observation, stations[observation.StationId])
+			learnings[observation.StationId] = learning
+		}
+
+		distance := stationRange
+		if observation.Strength != 0 {
+			distance = learner.pathLoss.of(observation.Radio).distance(observation.Strength)
+		}
+		accumulate(learning, observation.Lat, observation.Lng, 1/(distance+observation.Accuracy))
+		learning.Samples++
+		touched[observation.StationId] = true
+	}
+
+	var located []models.Station
+	for id := range touched {
+		learning := learnings[id]
+		if learning.Samples < learner.minSamples {
+			continue
+		}
+
+		lat, lng := math.Atan2(learning.Z, math.Hypot(learning.X, learning.Y))/d2R, math.Atan2(learning.Y, learning.X)/d2R
+		located = append(located, models.Station{
+			Id:      learning.StationId,
+			Radio:   learning.Radio,
+			Mcc:     learning.Mcc,
+			Mnc:     learning.Mnc,
+			Lac:     learning.Lac,
+			Cid:     learning.Cid,
+			Lat:     lat,
+			Lng:     lng,
+			Samples: learning.Samples,
+		})
+	}
+
+	return located
+}
+
+// newStationLearning starts learning a station, with its current location (if any) taken into account
+func newStationLearning(observation models.Observation, station *models.Station) *models.StationLearning {
+	learning := &models.StationLearning{
+		StationId: observation.StationId,
+		Radio:     observation.Radio,
+		Mcc:       observation.Mcc,
+		Mnc:       observation.Mnc,
+		Lac:       observation.Lac,
+		Cid:       observation.Cid,
+	}
+	if station != nil {
+		accumulate(learning, station.Lat, station.Lng, 1/stationRange)
+	}
+
+	return learning
+}
+
+// accumulate adds (lat, lng) as an unit vector with weight to learning
+func accumulate(learning *models.StationLearning, lat, lng, weight float64) {
+	lat, lng = lat*d2R, lng*d2R
+	learning.X += math.Cos(lat) * math.Cos(lng) * weight
+	learning.Y += math.Cos(lat) * math.Sin(lng) * weight
+	learning.Z += math.Sin(lat) * weight
+	learning.Weight += weight
+}
+
+// NewStationLearner creates an instance of stationLearner, which learns batchSize observations at a time
+func NewStationLearner(repo repos.ObservationRepo, pathLoss *pathLossModels, minSamples, batchSize int) *stationLearner {
+	return &stationLearner{repo: repo, pathLoss: pathLoss, minSamples: minSamples, batchSize: batchSize}
+}
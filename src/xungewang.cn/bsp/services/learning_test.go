@@ -0,0 +1,80 @@
+package services
+
+import (
+	"testing"
+	"xungewang.cn/bsp/app"
+	"xungewang.cn/bsp/models"
+)
+
+// observations of a cell at each fixture, surrounding the fixture symmetrically with equal strength
+func observationsAround(lat, lng float64) []models.Observation {
+	var observations []models.Observation
+	for _, station := range stationsAround(lat, lng) {
+		observations = append(observations, models.Observation{
+			StationId: "460-0-1-1", Radio: "gsm", Mcc: "460", Mnc: "0", Lac: "1", Cid: "1",
+			Lat: station.Lat, Lng: station.Lng, Accuracy: 10, Strength: -80,
+		})
+	}
+
+	return observations
+}
+
+func TestStationLearner_Learn(t *testing.T) {
+	learner := NewStationLearner(nil, NewPathLossModels(app.PathLossConfig{}), 3, 100)
+	for _, fixture := range hemisphereFixtures {
+		learnings := map[string]*models.StationLearning{}
+		located := learner.learn(observationsAround(fixture.lat, fixture.lng), learnings, map[string]*models.Station{})
+
+		if len(located) != 1 || located[0].Samples != 4 || learnings["460-0-1-1"].Samples != 4 {
+			t.Errorf("%s: unexpected stations located %v", fixture.place, located)
+			continue
+		}
+		if d := haversine(located[0].Lat, located[0].Lng, fixture.lat, fixture.lng); d > 5 {
+			t.Errorf("%s: station %v is %f m away from the expected", fixture.place, located[0], d)
+		}
+		if located[0].Id != "460-0-1-1" || located[0].Mcc != "460" {
+			t.Errorf("%s: identifiers of station not learned: %v", fixture.place, located[0])
+		}
+	}
+}
+
+func TestStationLearner_Learn_MinSamples(t *testing.T) {
+	learner := NewStationLearner(nil, NewPathLossModels(app.PathLossConfig{}), 3, 100)
+	observations := observationsAround(30.732924, 103.962488)
+
+	// not enough samples, but learned anyway
+	learnings := map[string]*models.StationLearning{}
+	if located := learner.learn(observations[:2], learnings, map[string]*models.Station{}); len(located) != 0 {
+		t.Errorf("station shouldn't be located with 2 samples: %v", located)
+	}
+	if learnings["460-0-1-1"] == nil || learnings["460-0-1-1"].Samples != 2 {
+		t.Fatalf("observations should be learned: %v", learnings)
+	}
+
+	// later observations build on the learning
+	located := learner.learn(observations[2:], learnings, map[string]*models.Station{})
+	if len(located) != 1 || located[0].Samples != 4 {
+		t.Fatalf("station should be located with 4 samples: %v", located)
+	}
+	if d := haversine(located[0].Lat, located[0].Lng, 30.732924, 103.962488); d > 5 {
+		t.Errorf("station %v is %f m away from the expected", located[0], d)
+	}
+}
+
+func TestStationLearner_Learn_Refine(t *testing.T) {
+	learner := NewStationLearner(nil, NewPathLossModels(app.PathLossConfig{}), 1, 100)
+
+	// a station imported 0.02 degree away from where it's observed, whose location is refined towards
+	// the observations, which weigh more as they're supposed to be close to the station
+	existing := &models.Station{Id: "460-0-1-1", Radio: "gsm", Lat: 30.752924, Lng: 103.962488}
+	located := learner.learn(observationsAround(30.732924, 103.962488), map[string]*models.StationLearning{},
+		map[string]*models.Station{existing.Id: existing})
+	if len(located) != 1 {
+		t.Fatalf("station should be located: %v", located)
+	}
+
+	station := located[0]
+	if !(station.Lat > 30.732924 && station.Lat < 30.742924) || station.Samples != 4 {
+		t.Errorf("station should be refined towards the observations: %v", station)
+	}
+}
@@ -0,0 +1,140 @@
+package services
+
+import (
+	"fmt"
+	"sort"
+	"xungewang.cn/bsp/apis"
+	"xungewang.cn/bsp/errors"
+)
+
+// names of built-in positioning algorithms
+const (
+	// spherical centroid of stations, with all stations weighing equally
+	AlgorithmCentroid = "centroid"
+	// spherical centroid of stations, with weights derived from signal strength
+	AlgorithmWeightedCentroid = "weighted-centroid"
+	// nonlinear least squares trilateration on distances estimated from signal strength
+	AlgorithmLeastSquares = "least-squares"
+)
+
+type (
+	// Locator computes the position of a device from stations it hears. Stations given are never empty,
+	// and have been rid of outliers.
+	Locator interface {
+		Locate(stations []*SignalAwareStation) (*apis.PositionResult, error)
+	}
+
+	// LocatorFunc adapts an ordinary function to Locator
+	LocatorFunc func(stations []*SignalAwareStation) (*apis.PositionResult, error)
+
+	// weigher is optionally implemented by Locators to tell how much each station weighs in positioning,
+	// which helps to explain computed positions
+	weigher interface {
+		weigh(stations []*SignalAwareStation) []float64
+	}
+
+	// built-in Locators
+	centroidLocator         struct{}
+	weightedCentroidLocator struct{ pathLoss *pathLossModels }
+	leastSquaresLocator     struct{ pathLoss *pathLossModels }
+
+	// LocatorRegistry keeps named Locator implementations, one of which serves as the default
+	LocatorRegistry struct {
+		locators         map[string]Locator
+		defaultAlgorithm string
+	}
+)
+
+func (f LocatorFunc) Locate(stations []*SignalAwareStation) (*apis.PositionResult, error) {
+	return f(stations)
+}
+
+// NewLocatorRegistry creates a registry with built-in algorithms registered, and the default algorithm set.
+// New algorithms can be registered via Register(), for example:
+//
+//	registry.Register("my-algorithm", LocatorFunc(func(stations []*SignalAwareStation) (*apis.PositionResult, error) {
+//		...
+//	}))
+func NewLocatorRegistry(pathLoss *pathLossModels, defaultAlgorithm string) *LocatorRegistry {
+	registry := &LocatorRegistry{make(map[string]Locator), defaultAlgorithm}
+
+	registry.Register(AlgorithmCentroid, &centroidLocator{})
+	registry.Register(AlgorithmWeightedCentroid, &weightedCentroidLocator{pathLoss})
+	registry.Register(AlgorithmLeastSquares, &leastSquaresLocator{pathLoss})
+
+	return registry
+}
+
+// Register adds a Locator by name, replacing the one registered under the same name if any
+func (registry *LocatorRegistry) Register(name string, locator Locator) {
+	registry.locators[name] = locator
+}
+
+// Validate checks that the default algorithm is registered
+func (registry *LocatorRegistry) Validate() error {
+	if _, ok := registry.locators[registry.defaultAlgorithm]; !ok {
+		return fmt.Errorf("unknown default algorithm '%s', should be one of %v", registry.defaultAlgorithm,
+			registry.names())
+	}
+
+	return nil
+}
+
+// find the Locator by name, with the default one returned if no name given. The name of Locator found is
+// returned as well.
+func (registry *LocatorRegistry) get(name string) (string, Locator, error) {
+	if name == "" {
+		name = registry.defaultAlgorithm
+	}
+
+	if locator, ok := registry.locators[name]; ok {
+		return name, locator, nil
+	}
+
+	return "", nil, errors.SimpleInvalidData(fmt.Sprintf("unknown algorithm '%s', should be one of %v", name,
+		registry.names()))
+}
+
+// sorted names of registered algorithms
+func (registry *LocatorRegistry) names() []string {
+	names := make([]string, 0, len(registry.locators))
+	for name := range registry.locators {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	return names
+}
+
+// centroid of stations, regardless of their signals
+func (locator *centroidLocator) Locate(stations []*SignalAwareStation) (*apis.PositionResult, error) {
+	weights := locator.weigh(stations)
+	lat, lng := sphericalCentroid(stations, weights)
+
+	return apis.NewPositionResult(lat, lng, estimateAccuracy(lat, lng, stations, weights, float64(len(stations)))), nil
+}
+
+func (locator *centroidLocator) weigh(stations []*SignalAwareStation) []float64 {
+	weights := make([]float64, len(stations))
+	for i := range weights {
+		weights[i] = 1
+	}
+
+	return weights
+}
+
+func (locator *weightedCentroidLocator) Locate(stations []*SignalAwareStation) (*apis.PositionResult, error) {
+	return triangulate(stations, locator.pathLoss), nil
+}
+
+func (locator *weightedCentroidLocator) weigh(stations []*SignalAwareStation) []float64 {
+	return inverseDistanceWeights(stations, locator.pathLoss, 1)
+}
+
+func (locator *leastSquaresLocator) Locate(stations []*SignalAwareStation) (*apis.PositionResult, error) {
+	return trilaterate(stations, locator.pathLoss), nil
+}
+
+func (locator *leastSquaresLocator) weigh(stations []*SignalAwareStation) []float64 {
+	return inverseDistanceWeights(stations, locator.pathLoss, 2)
+}
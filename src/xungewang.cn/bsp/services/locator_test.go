@@ -0,0 +1,39 @@
+package services
+
+import (
+	"testing"
+	"xungewang.cn/bsp/apis"
+	"xungewang.cn/bsp/app"
+)
+
+func TestLocatorRegistry(t *testing.T) {
+	registry := NewLocatorRegistry(NewPathLossModels(app.PathLossConfig{}), "nearest")
+	if err := registry.Validate(); err == nil {
+		t.Error("default algorithm not registered should be rejected")
+	}
+
+	// register a new algorithm, which takes the first station as the position
+	registry.Register("nearest", LocatorFunc(func(stations []*SignalAwareStation) (*apis.PositionResult, error) {
+		return apis.NewPositionResult(stations[0].Lat, stations[0].Lng, stationRange), nil
+	}))
+	if err := registry.Validate(); err != nil {
+		t.Error(err)
+	}
+
+	stations := stationsAround(30.732924, 103.962488)
+	if _, locator, err := registry.get(""); err != nil {
+		t.Error(err)
+	} else if r, _ := locator.Locate(stations); r.Lat != stations[0].Lat || r.Lng != stations[0].Lng {
+		t.Error("the default algorithm should be used if no algorithm given")
+	}
+
+	for _, algorithm := range []string{AlgorithmCentroid, AlgorithmWeightedCentroid, AlgorithmLeastSquares} {
+		if _, _, err := registry.get(algorithm); err != nil {
+			t.Errorf("built-in algorithm %s should be registered", algorithm)
+		}
+	}
+
+	if _, _, err := registry.get("fingerprint"); err == nil {
+		t.Error("unknown algorithm should be rejected")
+	}
+}
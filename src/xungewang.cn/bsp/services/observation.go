@@ -0,0 +1,78 @@
+package services
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"xungewang.cn/bsp/apis"
+	"xungewang.cn/bsp/app"
+	"xungewang.cn/bsp/models"
+	"xungewang.cn/bsp/repos"
+)
+
+// observationService should implements apis.observationService interface
+type observationService struct {
+	repo     repos.ObservationRepo
+	stations repos.PositionRepo
+	unknowns *UnknownSignalWriter
+}
+
+// Submit stores an observation for each signal of requests with one insert, which will be learned by
+// stationLearner later. Cells not located yet are recorded as unknown signals, the same way positioning does.
+func (service *observationService) Submit(ctx app.RequestScope, requests ...*apis.ObservationRequest) (*apis.ObservationResult, error) {
+	var observations []models.Observation
+	bySignal := make(map[string]apis.Signal)
+	for _, request := range requests {
+		for _, signal := range request.Signals {
+			bySignal[buildStationId(signal)] = signal
+			observations = append(observations, models.Observation{
+				StationId:  buildStationId(signal),
+				Radio:      signal.Radio,
+				Mcc:        signal.Mcc,
+				Mnc:        signal.Mnc,
+				Lac:        signal.Lac,
+				Cid:        signal.Cid,
+				Lat:        request.Lat,
+				Lng:        request.Lng,
+				Accuracy:   request.Accuracy,
+				Strength:   signal.Strength,
+				ObservedAt: *request.Time,
+			})
+		}
+	}
+
+	if err := service.repo.Create(ctx, observations); err != nil {
+		return nil, err
+	}
+	service.recordUnknowns(ctx, bySignal)
+
+	return &apis.ObservationResult{Code: 200, Accepted: len(observations)}, nil
+}
+
+// recordUnknowns records signals of cells not found as unknown signals. Observations are stored already, hence
+// failing to find stations doesn't fail the submission.
+func (service *observationService) recordUnknowns(ctx app.RequestScope, bySignal map[string]apis.Signal) {
+	stations, err := service.stations.FindStations(ctx, bySignal)
+	if err != nil {
+		log.Errorf("failed to find stations of observations: %s", err)
+		return
+	}
+
+	for _, station := range stations {
+		delete(bySignal, station.Id)
+	}
+	if len(bySignal) == 0 {
+		return
+	}
+
+	unknowns := make([]apis.Signal, 0, len(bySignal))
+	for _, signal := range bySignal {
+		unknowns = append(unknowns, signal)
+	}
+	service.unknowns.Write(unknowns)
+}
+
+// NewObservationService create an instance of observationService, with stations of cells submitted found from
+// stations, and those unknown written to unknowns
+func NewObservationService(repo repos.ObservationRepo, stations repos.PositionRepo,
+	unknowns *UnknownSignalWriter) *observationService {
+	return &observationService{repo, stations, unknowns}
+}
@@ -0,0 +1,63 @@
+package services
+
+import (
+	"testing"
+	"time"
+	"xungewang.cn/bsp/apis"
+	"xungewang.cn/bsp/app"
+	"xungewang.cn/bsp/models"
+	"xungewang.cn/bsp/repos"
+)
+
+type mockObservationRepo struct {
+	created [][]models.Observation
+}
+
+func (repo *mockObservationRepo) Create(ctx app.RequestScope, observations []models.Observation) error {
+	repo.created = append(repo.created, observations)
+	return nil
+}
+func (repo *mockObservationRepo) Learn(ctx app.RequestScope, limit int, learn repos.LearnFunc) (int, error) {
+	return 0, nil
+}
+
+func TestObservationService_Submit(t *testing.T) {
+	repo := &mockObservationRepo{}
+	stations := &mockPositionRepo{foundStations: []models.Station{{Id: "460-0-32838-60122"}}}
+	unknowns := &UnknownSignalWriter{queue: make(chan unknownSighting, 10)} // worker not started
+	service := NewObservationService(repo, stations, unknowns)
+
+	observedAt := time.Date(2017, 1, 2, 7, 4, 0, 0, time.UTC)
+	result, err := service.Submit(nil,
+		&apis.ObservationRequest{Lat: 30.732796, Lng: 103.962357, Accuracy: 10, Time: &observedAt, Signals: []apis.Signal{
+			{Radio: apis.RadioGSM, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60122", Strength: -78},
+			{Radio: apis.RadioLTE, Mcc: "460", Mnc: "0", Lac: "6334", Cid: "127502337"},
+		}},
+		&apis.ObservationRequest{Lat: 30.7, Lng: 103.9, Time: &observedAt, Signals: []apis.Signal{
+			{Radio: apis.RadioGSM, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60122", Strength: -90},
+		}})
+	if err != nil || result.Accepted != 3 {
+		t.Fatalf("unexpected result %+v, error %v", result, err)
+	}
+
+	// observations of all requests are created at once
+	if len(repo.created) != 1 || len(repo.created[0]) != 3 {
+		t.Fatalf("unexpected observations created %v", repo.created)
+	}
+	expected := models.Observation{StationId: "lte-460-0-6334-127502337", Radio: "lte", Mcc: "460", Mnc: "0",
+		Lac: "6334", Cid: "127502337", Lat: 30.732796, Lng: 103.962357, Accuracy: 10, ObservedAt: observedAt}
+	if observation := repo.created[0][1]; observation != expected {
+		t.Errorf("unexpected observation %+v, expecting %+v", observation, expected)
+	}
+	if observation := repo.created[0][2]; observation.StationId != "460-0-32838-60122" || observation.Lat != 30.7 {
+		t.Errorf("unexpected observation %+v", observation)
+	}
+
+	// stations are found at once, and the one not found is recorded as unknown
+	if stations.lookups != 1 || len(unknowns.queue) != 1 {
+		t.Fatalf("unexpected lookups %d and unknown signals queued %d", stations.lookups, len(unknowns.queue))
+	}
+	if unknown := <-unknowns.queue; unknown.signal.Cid != "127502337" {
+		t.Errorf("unexpected unknown signal %+v", unknown.signal)
+	}
+}
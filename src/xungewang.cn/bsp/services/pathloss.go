@@ -0,0 +1,148 @@
+package services
+
+import (
+	"math"
+	"xungewang.cn/bsp/app"
+)
+
+// path loss models
+const (
+	freeSpace   = "free_space"
+	okumuraHata = "okumura_hata"
+	cost231     = "cost231"
+)
+
+// propagation environments
+const (
+	urban    = "urban"
+	suburban = "suburban"
+	rural    = "rural"
+)
+
+const (
+	// frequency (in MHz) of the free space model that radio bands fall back to
+	defaultFrequency = 1000.0
+
+	// stations closer than this (in meters) are taken as being this close
+	minDistance = 1.0
+)
+
+type (
+	// pathLossModel estimates the distance to a station, which is used to weigh the station
+	pathLossModel interface {
+		// distance estimates how far (in meters) a station is away given the strength (in dBm) of its signal
+		distance(strength float64) float64
+	}
+
+	// the path loss models for each radio type
+	pathLossModels struct {
+		bands    map[string]pathLossModel
+		fallback pathLossModel
+	}
+
+	// logDistanceModel describes path loss (in dB) by 'a + b * log10(d)' with d the distance in kilometers.
+	// Free space, Okumura-Hata and COST-231 models are all of this form, with a and b depending on frequency,
+	// antenna heights and environment.
+	logDistanceModel struct {
+		txPower float64 // in dBm
+		a       float64
+		b       float64
+	}
+)
+
+// NewPathLossModels creates path loss models for radio bands as per given config
+func NewPathLossModels(config app.PathLossConfig) *pathLossModels {
+	models := &pathLossModels{
+		bands:    make(map[string]pathLossModel),
+		fallback: newFreeSpaceModel(config.TxPower, defaultFrequency),
+	}
+
+	for radio, band := range config.Bands {
+		environment := band.Environment
+		if environment == "" {
+			environment = config.Environment
+		}
+
+		switch band.Model {
+		case okumuraHata:
+			models.bands[radio] = newOkumuraHataModel(config.TxPower, band.Frequency, config.BaseHeight,
+				config.MobileHeight, environment)
+		case cost231:
+			models.bands[radio] = newCost231Model(config.TxPower, band.Frequency, config.BaseHeight,
+				config.MobileHeight, environment)
+		default:
+			models.bands[radio] = newFreeSpaceModel(config.TxPower, band.Frequency)
+		}
+	}
+
+	return models
+}
+
+// find the path loss model of given radio type
+func (models *pathLossModels) of(radio string) pathLossModel {
+	if model, ok := models.bands[radio]; ok {
+		return model
+	}
+
+	return models.fallback
+}
+
+func (model *logDistanceModel) distance(strength float64) float64 {
+	loss := model.txPower - strength
+
+	return math.Max(minDistance, 1000*math.Pow(10, (loss-model.a)/model.b))
+}
+
+// free space: 20 * log10(f) + 32.44 + 20 * log10(d)
+func newFreeSpaceModel(txPower, frequency float64) *logDistanceModel {
+	return &logDistanceModel{txPower, 20*math.Log10(frequency) + 32.44, 20}
+}
+
+// Okumura-Hata, designed for 150 - 1500 MHz:
+//
+//	urban: 69.55 + 26.16 * log10(f) - 13.82 * log10(hb) - a(hm) + (44.9 - 6.55 * log10(hb)) * log10(d)
+//	suburban: urban - 2 * (log10(f/28))^2 - 5.4
+//	rural: urban - 4.78 * (log10(f))^2 + 18.33 * log10(f) - 40.94
+func newOkumuraHataModel(txPower, frequency, baseHeight, mobileHeight float64, environment string) *logDistanceModel {
+	a := 69.55 + 26.16*math.Log10(frequency) - 13.82*math.Log10(baseHeight) -
+		mobileAntennaCorrection(frequency, mobileHeight)
+
+	switch environment {
+	case suburban:
+		a -= 2*math.Pow(math.Log10(frequency/28), 2) + 5.4
+	case rural:
+		a -= openAreaCorrection(frequency)
+	}
+
+	return &logDistanceModel{txPower, a, 44.9 - 6.55*math.Log10(baseHeight)}
+}
+
+// COST-231 extension of Hata model, designed for 1500 - 2000 MHz:
+//
+//	46.3 + 33.9 * log10(f) - 13.82 * log10(hb) - a(hm) + (44.9 - 6.55 * log10(hb)) * log10(d) + c
+//
+// where c is 3 dB for urban and 0 for suburban. The model doesn't cover rural areas, where we apply
+// the same correction as Okumura-Hata.
+func newCost231Model(txPower, frequency, baseHeight, mobileHeight float64, environment string) *logDistanceModel {
+	a := 46.3 + 33.9*math.Log10(frequency) - 13.82*math.Log10(baseHeight) -
+		mobileAntennaCorrection(frequency, mobileHeight)
+
+	switch environment {
+	case urban:
+		a += 3
+	case rural:
+		a -= openAreaCorrection(frequency)
+	}
+
+	return &logDistanceModel{txPower, a, 44.9 - 6.55*math.Log10(baseHeight)}
+}
+
+// the mobile antenna height correction a(hm) for small to medium sized cities
+func mobileAntennaCorrection(frequency, mobileHeight float64) float64 {
+	return (1.1*math.Log10(frequency)-0.7)*mobileHeight - (1.56*math.Log10(frequency) - 0.8)
+}
+
+// the correction for open (rural) areas
+func openAreaCorrection(frequency float64) float64 {
+	return 4.78*math.Pow(math.Log10(frequency), 2) - 18.33*math.Log10(frequency) + 40.94
+}
@@ -0,0 +1,61 @@
+package services
+
+import (
+	"math"
+	"testing"
+	"xungewang.cn/bsp/app"
+)
+
+func TestFreeSpaceModel_Distance(t *testing.T) {
+	// at 1000 MHz, the path loss over 1 km is 20 * log10(1000) + 32.44 = 92.44 dB
+	model := newFreeSpaceModel(43, 1000)
+	if d := model.distance(43 - 92.44); math.Abs(d-1000) > EPSILON {
+		t.Errorf("distance should be 1000 m, got %f", d)
+	}
+
+	// 20 dB less means 10 times farther
+	if d := model.distance(43 - 112.44); math.Abs(d-10000) > EPSILON {
+		t.Errorf("distance should be 10000 m, got %f", d)
+	}
+}
+
+func TestHataModels_Environment(t *testing.T) {
+	for _, newModel := range []func(float64, float64, float64, float64, string) *logDistanceModel{
+		newOkumuraHataModel, newCost231Model,
+	} {
+		urbanDistance := newModel(43, 1800, 30, 1.5, urban).distance(-90)
+		suburbanDistance := newModel(43, 1800, 30, 1.5, suburban).distance(-90)
+		ruralDistance := newModel(43, 1800, 30, 1.5, rural).distance(-90)
+
+		// signals fade faster in denser environments, so the same strength means a shorter distance
+		if !(urbanDistance < suburbanDistance && suburbanDistance < ruralDistance) {
+			t.Errorf("unexpected distances, urban: %f, suburban: %f, rural: %f",
+				urbanDistance, suburbanDistance, ruralDistance)
+		}
+	}
+}
+
+func TestPathLossModels_Of(t *testing.T) {
+	models := NewPathLossModels(app.PathLossConfig{
+		Environment:  urban,
+		TxPower:      43,
+		BaseHeight:   30,
+		MobileHeight: 1.5,
+		Bands: map[string]app.PathLossBand{
+			"lte": {Model: cost231, Frequency: 1800},
+			"gsm": {Model: okumuraHata, Frequency: 900, Environment: rural},
+		},
+	})
+
+	if models.of("lte").distance(-90) >= models.of("nr").distance(-90) {
+		t.Error("urban COST-231 should give shorter distance than free space")
+	}
+
+	if *models.of("gsm").(*logDistanceModel) != *newOkumuraHataModel(43, 900, 30, 1.5, rural) {
+		t.Error("band environment should override the global one")
+	}
+
+	if *models.of("nr").(*logDistanceModel) != *newFreeSpaceModel(43, defaultFrequency) {
+		t.Error("bands not configured should fall back to free space")
+	}
+}
@@ -2,10 +2,10 @@ package services
 
 import (
 	log "github.com/Sirupsen/logrus"
-	"strings"
 	"xungewang.cn/bsp/apis"
 	"xungewang.cn/bsp/app"
 	"xungewang.cn/bsp/errors"
+	"xungewang.cn/bsp/geocode"
 	"xungewang.cn/bsp/models"
 	"xungewang.cn/bsp/repos"
 )
@@ -13,10 +13,16 @@ import (
 // positionService should implements apis.positionService interface
 type (
 	positionService struct {
-		repo repos.PositionRepo
+		repo     repos.PositionRepo
+		locators *LocatorRegistry
+		outliers *outlierFilter
+		unknowns *UnknownSignalWriter
+		geocoder *geocode.Geocoder // nil if geocoding is not configured
 	}
 
-	signalAwareStation struct {
+	// SignalAwareStation is a station found, along with the strength of its signal received by the device, which
+	// is what Locators locate devices from
+	SignalAwareStation struct {
 		*models.Station
 
 		// strength of related signal
@@ -24,69 +30,182 @@ type (
 	}
 )
 
-func newSignalAwareStation(station *models.Station, signalStrength float64) *signalAwareStation {
-	return &signalAwareStation{station, signalStrength}
+func newSignalAwareStation(station *models.Station, signalStrength float64) *SignalAwareStation {
+	return &SignalAwareStation{station, signalStrength}
 }
 
 func (service *positionService) ComputePosition(ctx app.RequestScope, request *apis.PositionRequest) (*apis.PositionResult, error) {
-	// In order to attach additional information (e.g., signal) to stations found,
-	// we use map as the underlying data structure with station id as its key.
-	signals := make(map[string]apis.Signal)
-	for _, signal := range *request {
-		signals[buildStationId(signal)] = signal
+	algorithm, locator, err := service.locators.get(request.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	found, err := service.findStations(ctx, request.Signals)
+	if err != nil {
+		return nil, err
+	}
+
+	return service.locate(request.Signals, found, algorithm, locator, request.Explain)
+}
+
+// ComputePositions computes positions of devices in a batch, whose stations are found all at once. Devices
+// failed to be positioned (e.g., no stations matched) get their own errors, rather than failing the batch.
+func (service *positionService) ComputePositions(ctx app.RequestScope, request *apis.BatchPositionRequest) ([]*apis.BatchPositionItemResult, error) {
+	algorithm, locator, err := service.locators.get(request.Algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	var signals []apis.Signal
+	for _, item := range request.Items {
+		signals = append(signals, item.Signals...)
+	}
+	found, err := service.findStations(ctx, signals)
+	if err != nil {
+		return nil, err
+	}
+
+	results := make([]*apis.BatchPositionItemResult, len(request.Items))
+	for i, item := range request.Items {
+		position, err := service.locate(item.Signals, found, algorithm, locator, false)
+		if err != nil {
+			results[i] = apis.NewBatchPositionItemError(item.DeviceId, err)
+		} else {
+			results[i] = apis.NewBatchPositionItemResult(item.DeviceId, position)
+		}
+	}
+
+	return results, nil
+}
+
+// LocateArea computes a coarse position of a device from the areas (LAC/TAC) its signals are in, which is the
+// fallback when none of its stations are known. The smallest area found wins, and the device is placed at its
+// center, with the accuracy covering the whole area.
+func (service *positionService) LocateArea(ctx app.RequestScope, request *apis.PositionRequest) (*apis.PositionResult, error) {
+	signals := make([]apis.Signal, 0, len(request.Signals))
+	seen := make(map[string]bool, len(request.Signals))
+	for _, signal := range request.Signals {
+		id := models.BuildStationId(signal.Radio, signal.Mcc, signal.Mnc, signal.Lac, "")
+		if !seen[id] {
+			seen[id] = true
+			signals = append(signals, signal)
+		}
+	}
+
+	areas, err := service.repo.FindAreas(ctx, signals)
+	if err != nil {
+		return nil, err
 	}
 
-	stations, err := service.repo.FindStations(ctx, signals)
+	var position *apis.PositionResult
+	for _, area := range areas {
+		lat, lng, spread := areaCentroid(area)
+		accuracy := spread + stationRange
+		if position == nil || accuracy < position.Accuracy {
+			position = apis.NewPositionResult(lat, lng, accuracy)
+		}
+	}
+	if position == nil {
+		return nil, errors.NotFound("no areas matched")
+	}
+
+	return position, nil
+}
+
+// findStations finds stations of signals, keyed by station id
+func (service *positionService) findStations(ctx app.RequestScope, signals []apis.Signal) (map[string]models.Station, error) {
+	bySignal := make(map[string]apis.Signal, len(signals))
+	for _, signal := range signals {
+		bySignal[buildStationId(signal)] = signal
+	}
+
+	stations, err := service.repo.FindStations(ctx, bySignal)
 	if err != nil {
 		return nil, err
 	}
 
+	found := make(map[string]models.Station, len(stations))
+	for _, station := range stations {
+		found[station.Id] = station
+	}
+
+	return found, nil
+}
+
+// locate computes position of a device from its signals, with stations found beforehand
+func (service *positionService) locate(signals []apis.Signal, found map[string]models.Station, algorithm string,
+	locator Locator, explain bool) (*apis.PositionResult, error) {
+	// In order to attach additional information (e.g., signal) to stations found,
+	// we use map as the underlying data structure with station id as its key.
+	bySignal := make(map[string]apis.Signal, len(signals))
+	for _, signal := range signals {
+		bySignal[buildStationId(signal)] = signal
+	}
+
+	stations := make([]models.Station, 0, len(bySignal))
+	var unknowns []apis.Signal
+	for id, signal := range bySignal {
+		if station, ok := found[id]; ok {
+			stations = append(stations, station)
+		} else {
+			unknowns = append(unknowns, signal)
+		}
+	}
+
 	// not all stations requested found
-	if len(stations) != len(signals) {
-		log.Debug("not all requested signal has a corresponding station")
-		go service.recordUnknownSignals(ctx, stations, signals)
+	if len(unknowns) > 0 {
+		log.Debugf("unknown signals: %s", unknowns)
+		service.unknowns.Write(unknowns)
 		if len(stations) == 0 { // to bad the request is
 			return nil, errors.NotFound("no stations matched")
 		}
 	}
 
 	// wrap stations with corresponding signal
-	signalAwareStations := make([]*signalAwareStation, len(stations))
+	signalAwareStations := make([]*SignalAwareStation, len(stations))
 	for i, station := range stations {
-		// signals[station.Id] should always be ok
-		signalAwareStations[i] = newSignalAwareStation(&stations[i], signals[station.Id].Strength)
+		signalAwareStations[i] = newSignalAwareStation(&stations[i], bySignal[station.Id].Strength)
 	}
 
-	return doComputePosition(signalAwareStations)
+	var explanation *apis.Explanation
+	if explain {
+		explanation = explainSignals(algorithm, signals, stations)
+	}
+
+	position, err := doComputePosition(signalAwareStations, locator, service.outliers, explanation)
+	if err != nil {
+		return nil, err
+	}
+	if service.geocoder != nil {
+		position.Address = service.geocoder.Reverse(position.Lat, position.Lng)
+	}
+
+	return position, nil
 }
 
-func (service *positionService) recordUnknownSignals(ctx app.RequestScope, foundStations []models.Station, requested map[string]apis.Signal) {
-	unknowns := make([]apis.Signal, 0, len(requested))
-	for id, signal := range requested {
-		// check whether it's found
-		found := false
-		for _, station := range foundStations {
-			if station.Id == id {
-				found = true
-				break
-			}
-		}
+// start explaining the computation by telling whether each signal requested matches a station
+func explainSignals(algorithm string, signals []apis.Signal, foundStations []models.Station) *apis.Explanation {
+	found := make(map[string]bool, len(foundStations))
+	for _, station := range foundStations {
+		found[station.Id] = true
+	}
 
-		if !found {
-			unknowns = append(unknowns, signal)
-		}
+	explanation := &apis.Explanation{Algorithm: algorithm, Signals: make([]*apis.SignalExplanation, len(signals))}
+	for i, signal := range signals {
+		id := buildStationId(signal)
+		explanation.Signals[i] = &apis.SignalExplanation{Signal: signal, StationId: id, Matched: found[id]}
 	}
 
-	// persist
-	log.Debugf("persist unknown signals: %s", unknowns)
-	service.repo.RecordUnknownSignals(ctx, unknowns)
+	return explanation
 }
 
+// build id of the station that sends given signal
 func buildStationId(signal apis.Signal) string {
-	return strings.Join([]string{signal.Mnc, signal.Lac, signal.Cid}, "-")
+	return models.BuildStationId(signal.Radio, signal.Mcc, signal.Mnc, signal.Lac, signal.Cid)
 }
 
-// NewPositionService create an instance of positionService
-func NewPositionService(repo repos.PositionRepo) *positionService {
-	return &positionService{repo}
+// NewPositionService create an instance of positionService, with geocoder (optional) resolving addresses of positions
+func NewPositionService(repo repos.PositionRepo, locators *LocatorRegistry, outliers *outlierFilter,
+	unknowns *UnknownSignalWriter, geocoder *geocode.Geocoder) *positionService {
+	return &positionService{repo, locators, outliers, unknowns, geocoder}
 }
@@ -0,0 +1,159 @@
+package services
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	log "github.com/Sirupsen/logrus"
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"time"
+	"xungewang.cn/bsp/models"
+)
+
+// timeout of forwarding a position
+const forwardTimeout = 5 * time.Second
+
+type (
+	// PositionForwarder POSTs positions of trackers as JSON to a URL asynchronously, so that platforms don't have
+	// to poll the database. Positions are queued in a bounded buffer (and dropped if it's full, so that gateways
+	// never wait for the platform), and forwarded one by one by a single worker.
+	PositionForwarder struct {
+		url    string
+		client *http.Client
+		queue  chan models.DevicePosition
+
+		forwarded uint64
+		dropped   uint64
+		failed    uint64
+
+		// closed tells Forward whether the forwarder is closed, under mu which Close locks, so that no position
+		// gets queued after the worker has drained the queue
+		mu        sync.RWMutex
+		closed    bool
+		closing   chan struct{}
+		done      chan struct{}
+		closeOnce sync.Once
+	}
+
+	// PositionForwarderStats tells how PositionForwarder is doing
+	PositionForwarderStats struct {
+		// number of positions queued, and the capacity of the queue
+		QueueDepth    int `json:"queue_depth"`
+		QueueCapacity int `json:"queue_capacity"`
+
+		// number of positions forwarded, dropped for the queue being full, and failed to forward
+		Forwarded uint64 `json:"forwarded"`
+		Dropped   uint64 `json:"dropped"`
+		Failed    uint64 `json:"failed"`
+	}
+)
+
+// Forward queues the position without blocking, which is dropped if the queue is full
+func (forwarder *PositionForwarder) Forward(position *models.DevicePosition) {
+	forwarder.mu.RLock()
+	defer forwarder.mu.RUnlock()
+	if forwarder.closed {
+		atomic.AddUint64(&forwarder.dropped, 1)
+		return
+	}
+
+	select {
+	case forwarder.queue <- *position:
+	default:
+		atomic.AddUint64(&forwarder.dropped, 1)
+	}
+}
+
+// Stats returns current stats of the forwarder
+func (forwarder *PositionForwarder) Stats() PositionForwarderStats {
+	return PositionForwarderStats{
+		QueueDepth:    len(forwarder.queue),
+		QueueCapacity: cap(forwarder.queue),
+		Forwarded:     atomic.LoadUint64(&forwarder.forwarded),
+		Dropped:       atomic.LoadUint64(&forwarder.dropped),
+		Failed:        atomic.LoadUint64(&forwarder.failed),
+	}
+}
+
+// Close forwards positions pending and stops the worker. Positions forwarded afterwards are dropped.
+func (forwarder *PositionForwarder) Close() {
+	forwarder.closeOnce.Do(func() {
+		forwarder.mu.Lock()
+		forwarder.closed = true
+		close(forwarder.closing)
+		forwarder.mu.Unlock()
+		<-forwarder.done
+
+		if stats := forwarder.Stats(); stats.Dropped > 0 || stats.Failed > 0 {
+			log.Warnf("position forwarder closed: %+v", stats)
+		}
+	})
+}
+
+// run is the worker, which forwards positions in the order they're queued
+func (forwarder *PositionForwarder) run() {
+	defer close(forwarder.done)
+
+	for {
+		select {
+		case position := <-forwarder.queue:
+			forwarder.forward(&position)
+		case <-forwarder.closing:
+			// drain the queue, no more positions get in since then
+			for {
+				select {
+				case position := <-forwarder.queue:
+					forwarder.forward(&position)
+				default:
+					return
+				}
+			}
+		}
+	}
+}
+
+// forward POSTs the position, failures of which are logged and counted
+func (forwarder *PositionForwarder) forward(position *models.DevicePosition) {
+	if err := forwarder.post(position); err != nil {
+		log.Errorf("failed to forward position of %s to %s: %s", position.DeviceId, forwarder.url, err)
+		atomic.AddUint64(&forwarder.failed, 1)
+	} else {
+		atomic.AddUint64(&forwarder.forwarded, 1)
+	}
+}
+
+func (forwarder *PositionForwarder) post(position *models.DevicePosition) error {
+	body, err := json.Marshal(position)
+	if err != nil {
+		return err
+	}
+
+	response, err := forwarder.client.Post(forwarder.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	defer response.Body.Close()
+
+	if response.StatusCode >= http.StatusMultipleChoices {
+		return fmt.Errorf("unexpected status %s", response.Status)
+	}
+
+	return nil
+}
+
+// NewPositionForwarder creates an instance of PositionForwarder and starts its worker, with up to queueSize
+// positions pending
+func NewPositionForwarder(url string, queueSize int) *PositionForwarder {
+	forwarder := &PositionForwarder{
+		url:     url,
+		client:  &http.Client{Timeout: forwardTimeout},
+		queue:   make(chan models.DevicePosition, queueSize),
+		closing: make(chan struct{}),
+		done:    make(chan struct{}),
+	}
+	go forwarder.run()
+
+	return forwarder
+}
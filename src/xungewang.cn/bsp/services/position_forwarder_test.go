@@ -0,0 +1,57 @@
+package services
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"xungewang.cn/bsp/models"
+)
+
+func TestPositionForwarder_Forward(t *testing.T) {
+	// the platform hangs until released, and fails positions of device "b"
+	release := make(chan struct{})
+	var mu sync.Mutex
+	var received []string
+	platform := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		<-release
+		var position models.DevicePosition
+		if err := json.NewDecoder(r.Body).Decode(&position); err != nil || position.DeviceId == "b" {
+			w.WriteHeader(http.StatusInternalServerError)
+			return
+		}
+		mu.Lock()
+		received = append(received, position.DeviceId)
+		mu.Unlock()
+	}))
+	defer platform.Close()
+
+	forwarder := NewPositionForwarder(platform.URL, 2)
+
+	// positions are queued without waiting for the platform, the worker takes one while the queue holds up to
+	// 2 others, hence at least the last one is dropped
+	for _, id := range []string{"a", "b", "c", "d"} {
+		forwarder.Forward(&models.DevicePosition{DeviceId: id})
+	}
+	if stats := forwarder.Stats(); stats.Dropped == 0 || stats.Forwarded != 0 {
+		t.Errorf("positions not fitting in the queue should be dropped: %+v", stats)
+	}
+
+	close(release)
+	forwarder.Close()
+
+	stats := forwarder.Stats()
+	if stats.QueueDepth != 0 || stats.Forwarded+stats.Failed+stats.Dropped != 4 || stats.Failed != 1 {
+		t.Errorf("positions pending should be forwarded on close: %+v", stats)
+	}
+	if len(received) != int(stats.Forwarded) || received[0] != "a" {
+		t.Errorf("unexpected positions received: %v", received)
+	}
+
+	// positions forwarded after closed are dropped
+	forwarder.Forward(&models.DevicePosition{DeviceId: "e"})
+	if after := forwarder.Stats(); after.Dropped != stats.Dropped+1 || after.QueueDepth != 0 {
+		t.Errorf("unexpected stats after closed: %+v", after)
+	}
+}
@@ -11,35 +11,45 @@ import (
 
 type mockPositionRepo struct {
 	foundStations  []models.Station
-	unknownSignals []apis.Signal
+	foundAreas     []models.Area
+	unknownSignals []models.UnknownSignal
+	lookups        int
 }
 
 func (repo *mockPositionRepo) FindStations(ctx app.RequestScope, signals map[string]apis.Signal) ([]models.Station, error) {
+	repo.lookups++
 	return repo.foundStations, nil
 }
-func (repo *mockPositionRepo) RecordUnknownSignals(ctx app.RequestScope, signals []apis.Signal) {
-	repo.unknownSignals = signals
+func (repo *mockPositionRepo) RecordUnknownSignals(ctx app.RequestScope, signals []models.UnknownSignal) error {
+	repo.unknownSignals = append(repo.unknownSignals, signals...)
+	return nil
+}
+func (repo *mockPositionRepo) FindAreas(ctx app.RequestScope, signals []apis.Signal) ([]models.Area, error) {
+	return repo.foundAreas, nil
 }
 
 func TestPositionService_ComputePosition(t *testing.T) {
 	repo := mockPositionRepo{
 		foundStations: []models.Station{
-			{Id: "0-32838-60122", Lat: 30.732796, Lng: 103.962357},
+			{Id: "460-0-32838-60122", Lat: 30.732796, Lng: 103.962357},
 		},
 	}
-	positionService := NewPositionService(&repo)
+	unknowns := NewUnknownSignalWriter(&repo, nil, 100, 10, time.Second)
+	positionService := NewPositionService(&repo,
+		NewLocatorRegistry(NewPathLossModels(app.PathLossConfig{}), AlgorithmWeightedCentroid), NewOutlierFilter(3000, 1),
+		unknowns, nil)
 
-	var request apis.PositionRequest = []apis.Signal{
-		{Mnc: "0", Lac: "32838", Cid: "60122", Strength: -78},
-		{Mnc: "0", Lac: "32838", Cid: "60123", Strength: -79}, // won't find this
-	}
+	request := apis.PositionRequest{Signals: []apis.Signal{
+		{Radio: apis.RadioGSM, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60122", Strength: -78},
+		{Radio: apis.RadioGSM, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60123", Strength: -79}, // won't find this
+	}}
 	if r, err := positionService.ComputePosition(nil, &request); err == nil {
-		// wait for the coroutine to finish
-		time.Sleep(200 * time.Millisecond)
+		// flush unknown signals pending
+		unknowns.Close()
 
 		// check the unknown signals
 		unknown := repo.unknownSignals
-		if len(unknown) != 1 && unknown[0].Cid != "60123" {
+		if len(unknown) != 1 || unknown[0].Cid != "60123" || unknown[0].HitCount != 1 {
 			t.Errorf("unexpected unknown signal: %v", unknown)
 		}
 
@@ -52,3 +62,165 @@ func TestPositionService_ComputePosition(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestPositionService_ComputePosition_Explain(t *testing.T) {
+	repo := mockPositionRepo{
+		foundStations: []models.Station{
+			{Id: "460-0-32838-60122", Lat: 30.732796, Lng: 103.962357},
+			{Id: "460-0-32838-60123", Lat: 30.734688, Lng: 103.961433},
+			{Id: "460-0-32838-36861", Lat: 30.730850, Lng: 104.965279}, // too far away
+		},
+	}
+	unknowns := NewUnknownSignalWriter(&repo, nil, 100, 10, time.Second)
+	positionService := NewPositionService(&repo,
+		NewLocatorRegistry(NewPathLossModels(app.PathLossConfig{}), AlgorithmWeightedCentroid), NewOutlierFilter(3000, 1),
+		unknowns, nil)
+
+	request := apis.PositionRequest{Explain: true, Signals: []apis.Signal{
+		{Radio: apis.RadioGSM, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60122", Strength: -78},
+		{Radio: apis.RadioGSM, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60123", Strength: -79},
+		{Radio: apis.RadioGSM, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "36861", Strength: -80},
+		{Radio: apis.RadioGSM, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60124", Strength: -81}, // won't find this
+	}}
+	r, err := positionService.ComputePosition(nil, &request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknowns.Close() // flush unknown signals pending
+
+	explanation := r.Explanation
+	if explanation == nil || explanation.Algorithm != AlgorithmWeightedCentroid || len(explanation.Signals) != 4 {
+		t.Fatalf("unexpected explanation: %+v", explanation)
+	}
+
+	weightSum := 0.0
+	for i, signal := range explanation.Signals {
+		if signal.Matched != (i < 3) {
+			t.Errorf("signal %s should be matched: %t", signal.StationId, i < 3)
+		}
+		if (signal.EliminatedInRound == 1) != (i == 2) {
+			t.Errorf("signal %s should be eliminated in round 1: %t", signal.StationId, i == 2)
+		}
+		if (signal.Weight > 0) != (i < 2) {
+			t.Errorf("signal %s should weigh: %t", signal.StationId, i < 2)
+		}
+		weightSum += signal.Weight
+	}
+	if math.Abs(weightSum-1) > EPSILON {
+		t.Errorf("weights should sum up to 1, got %f", weightSum)
+	}
+
+	if len(explanation.Rounds) != 2 || explanation.Rounds[0].Eliminated != "460-0-32838-36861" ||
+		explanation.Rounds[1].Eliminated != "" {
+		t.Errorf("unexpected elimination rounds: %+v", explanation.Rounds)
+	}
+}
+
+func TestPositionService_ComputePositions(t *testing.T) {
+	repo := mockPositionRepo{
+		foundStations: []models.Station{
+			{Id: "460-0-32838-60122", Lat: 30.732796, Lng: 103.962357},
+			{Id: "460-0-32838-60123", Lat: 30.734688, Lng: 103.961433},
+			{Id: "lte-460-0-6334-127502337", Lat: 30.572815, Lng: 104.065735},
+		},
+	}
+	unknowns := NewUnknownSignalWriter(&repo, nil, 100, 10, time.Second)
+	positionService := NewPositionService(&repo,
+		NewLocatorRegistry(NewPathLossModels(app.PathLossConfig{}), AlgorithmWeightedCentroid), NewOutlierFilter(3000, 1),
+		unknowns, nil)
+
+	request := apis.BatchPositionRequest{Items: []apis.BatchPositionItem{
+		{DeviceId: "a", Signals: []apis.Signal{
+			{Radio: apis.RadioGSM, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60122", Strength: -78},
+			{Radio: apis.RadioGSM, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60123", Strength: -79},
+		}},
+		{DeviceId: "b", Signals: []apis.Signal{
+			{Radio: apis.RadioLTE, Mcc: "460", Mnc: "0", Lac: "6334", Cid: "127502337", Strength: -90},
+		}},
+		{DeviceId: "c", Signals: []apis.Signal{ // won't find this
+			{Radio: apis.RadioGSM, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60124", Strength: -81},
+		}},
+	}}
+	results, err := positionService.ComputePositions(nil, &request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	unknowns.Close()
+
+	if repo.lookups != 1 {
+		t.Errorf("stations should be found with one lookup, got %d", repo.lookups)
+	}
+	if len(results) != 3 {
+		t.Fatalf("3 results expected, got %d", len(results))
+	}
+
+	if a := results[0]; a.DeviceId != "a" || a.Code != 200 || a.PositionResult == nil ||
+		haversine(a.Lat, a.Lng, 30.733742, 103.961895) > 200 {
+		t.Errorf("unexpected result of a: %+v", a)
+	}
+	if b := results[1]; b.DeviceId != "b" || b.PositionResult == nil ||
+		math.Abs(b.Lat-30.572815) > EPSILON || math.Abs(b.Lng-104.065735) > EPSILON {
+		t.Errorf("unexpected result of b: %+v", b)
+	}
+	if c := results[2]; c.DeviceId != "c" || c.Code != 404 || c.Error == nil || c.PositionResult != nil {
+		t.Errorf("unexpected result of c: %+v", c)
+	}
+
+	if len(repo.unknownSignals) != 1 || repo.unknownSignals[0].Cid != "60124" {
+		t.Errorf("unexpected unknown signals: %v", repo.unknownSignals)
+	}
+}
+
+// newArea builds an area of stations located at coordinates, given as lat, lng pairs
+func newArea(radio, lac string, coordinates ...float64) models.Area {
+	area := models.Area{Radio: radio, Mcc: "460", Mnc: "0", Lac: lac}
+	for i := 0; i < len(coordinates); i += 2 {
+		area.Add(coordinates[i], coordinates[i+1])
+	}
+	return area
+}
+
+func TestPositionService_LocateArea(t *testing.T) {
+	repo := mockPositionRepo{
+		foundAreas: []models.Area{
+			newArea(apis.RadioGSM, "32838", 30.70, 103.90, 30.80, 104.00),
+			newArea(apis.RadioLTE, "6180", 30.72, 103.95, 30.74, 103.97),
+		},
+	}
+	positionService := NewPositionService(&repo,
+		NewLocatorRegistry(NewPathLossModels(app.PathLossConfig{}), AlgorithmWeightedCentroid), NewOutlierFilter(3000, 1),
+		nil, nil)
+
+	request := apis.PositionRequest{Signals: []apis.Signal{
+		{Radio: apis.RadioGSM, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60122"},
+		{Radio: apis.RadioLTE, Mcc: "460", Mnc: "0", Lac: "6180", Cid: "1"},
+	}}
+	r, err := positionService.LocateArea(nil, &request)
+	if err != nil {
+		t.Fatal(err)
+	}
+	// the smaller area wins, whose stations are as far from the center
+	if math.Abs(r.Lat-30.73) > 0.0001 || math.Abs(r.Lng-103.96) > 0.0001 {
+		t.Errorf("center of the smaller area expected, got (%f, %f)", r.Lat, r.Lng)
+	}
+	if expected := haversine(r.Lat, r.Lng, 30.74, 103.97) + stationRange; math.Abs(r.Accuracy-expected) > 1 {
+		t.Errorf("accuracy %f expected, got %f", expected, r.Accuracy)
+	}
+
+	// the center of an area across the antimeridian is near it, rather than on the other side of the earth
+	repo.foundAreas = []models.Area{newArea(apis.RadioGSM, "32838", 65.0, 179.9, 65.0, -179.9)}
+	if r, err = positionService.LocateArea(nil, &request); err != nil {
+		t.Fatal(err)
+	}
+	if math.Abs(r.Lat-65.0) > 0.001 || math.Abs(r.Lng) < 179.99 {
+		t.Errorf("center near the antimeridian expected, got (%f, %f)", r.Lat, r.Lng)
+	}
+	if expected := haversine(65.0, 180, 65.0, 179.9) + stationRange; math.Abs(r.Accuracy-expected) > 1 {
+		t.Errorf("accuracy %f expected, got %f", expected, r.Accuracy)
+	}
+
+	repo.foundAreas = nil
+	if _, err := positionService.LocateArea(nil, &request); err == nil {
+		t.Error("error expected when no areas matched")
+	}
+}
@@ -0,0 +1,102 @@
+package services
+
+import (
+	"database/sql"
+	"fmt"
+	"xungewang.cn/bsp/app"
+	"xungewang.cn/bsp/errors"
+	"xungewang.cn/bsp/models"
+	"xungewang.cn/bsp/repos"
+)
+
+type (
+	// stationService should implements apis.stationService interface
+	stationService struct {
+		repo        repos.StationRepo
+		invalidator StationInvalidator
+	}
+
+	// StationInvalidator is told of stations changed, e.g., to invalidate them from caches
+	StationInvalidator interface {
+		Invalidate(ids ...string)
+	}
+)
+
+func (service *stationService) Get(ctx app.RequestScope, id string) (*models.Station, error) {
+	return service.repo.Get(ctx, id)
+}
+
+func (service *stationService) Count(ctx app.RequestScope, filter *models.StationFilter) (int, error) {
+	return service.repo.Count(ctx, filter)
+}
+
+func (service *stationService) Query(ctx app.RequestScope, filter *models.StationFilter, offset, limit int) ([]models.Station, error) {
+	return service.repo.Query(ctx, filter, offset, limit)
+}
+
+// Create creates a station, whose id is built from its identifiers. Creating an existing station is a conflict.
+func (service *stationService) Create(ctx app.RequestScope, station *models.Station) (*models.Station, error) {
+	station.Id = models.BuildStationId(station.Radio, station.Mcc, station.Mnc, station.Lac, station.Cid)
+	station.Source = models.SourceManual
+	if err := station.Validate(); err != nil {
+		return nil, err
+	}
+
+	if _, err := service.repo.Get(ctx, station.Id); err == nil {
+		return nil, errors.Conflict(fmt.Sprintf("station %s already exists", station.Id))
+	} else if err != sql.ErrNoRows {
+		return nil, err
+	}
+
+	if err := service.repo.Create(ctx, station); err != nil {
+		return nil, err
+	}
+	service.invalidate(station.Id) // it may be cached as not found
+
+	return service.repo.Get(ctx, station.Id)
+}
+
+// Update updates a station. Only its coordinates and range can be changed, since the rest identify the station.
+func (service *stationService) Update(ctx app.RequestScope, id string, station *models.Station) (*models.Station, error) {
+	existing, err := service.repo.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	existing.Lat, existing.Lng, existing.Range, existing.Source = station.Lat, station.Lng, station.Range, models.SourceManual
+	if err := existing.Validate(); err != nil {
+		return nil, err
+	}
+
+	if err := service.repo.Update(ctx, existing); err != nil {
+		return nil, err
+	}
+	service.invalidate(id)
+
+	return service.repo.Get(ctx, id)
+}
+
+func (service *stationService) Delete(ctx app.RequestScope, id string) (*models.Station, error) {
+	station, err := service.repo.Get(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := service.repo.Delete(ctx, id); err != nil {
+		return nil, err
+	}
+	service.invalidate(id)
+
+	return station, nil
+}
+
+func (service *stationService) invalidate(id string) {
+	if service.invalidator != nil {
+		service.invalidator.Invalidate(id)
+	}
+}
+
+// NewStationService create an instance of stationService, with invalidator (optional) told of stations changed
+func NewStationService(repo repos.StationRepo, invalidator StationInvalidator) *stationService {
+	return &stationService{repo, invalidator}
+}
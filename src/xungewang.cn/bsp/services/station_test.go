@@ -0,0 +1,149 @@
+package services
+
+import (
+	"database/sql"
+	"net/http"
+	"testing"
+	"xungewang.cn/bsp/errors"
+	"xungewang.cn/bsp/models"
+)
+
+type mockStationInvalidator struct {
+	ids []string
+}
+
+func (invalidator *mockStationInvalidator) Invalidate(ids ...string) {
+	invalidator.ids = append(invalidator.ids, ids...)
+}
+
+func TestStationService_Create(t *testing.T) {
+	repo := &mockStationRepo{stations: map[string]models.Station{}}
+	invalidator := &mockStationInvalidator{}
+	service := NewStationService(repo, invalidator)
+
+	// the id is built from identifiers, ignoring the one given
+	created, err := service.Create(nil, &models.Station{Id: "ignored", Radio: "lte", Mcc: "460", Mnc: "0", Lac: "6244",
+		Cid: "84115972", Lat: 30.572815, Lng: 104.065735, Range: 1000, Samples: 5, Source: models.SourceImport})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if created.Id != "lte-460-0-6244-84115972" || created.Source != models.SourceManual || created.Lat != 30.572815 {
+		t.Errorf("unexpected station %+v", created)
+	}
+	if _, ok := repo.stations["lte-460-0-6244-84115972"]; !ok || len(invalidator.ids) != 1 || invalidator.ids[0] != created.Id {
+		t.Errorf("station not created or invalidated: %v, %v", repo.stations, invalidator.ids)
+	}
+	if created, err := service.Create(nil, &models.Station{Radio: "gsm", Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60122",
+		Lat: 30.732796, Lng: 103.962357}); err != nil || created.Id != "460-0-32838-60122" {
+		t.Errorf("unexpected GSM station %+v, error %v", created, err)
+	}
+
+	// the station exists already
+	duplicated := &models.Station{Radio: "lte", Mcc: "460", Mnc: "0", Lac: "6244", Cid: "84115972", Lat: 30.6, Lng: 104.1}
+	if _, err := service.Create(nil, duplicated); err == nil {
+		t.Error("creating existing station should fail")
+	} else if apiErr, ok := err.(*errors.APIError); !ok || apiErr.Status != http.StatusConflict {
+		t.Errorf("creating existing station should conflict, got %v", err)
+	}
+	if station := repo.stations["lte-460-0-6244-84115972"]; station.Lat != 30.572815 {
+		t.Errorf("existing station should be kept, got %+v", station)
+	}
+
+	for _, invalid := range []models.Station{
+		{Radio: "gsm", Mcc: "460", Mnc: "0", Lac: "1", Cid: "1", Lat: 91, Lng: 104},
+		{Radio: "gsm", Mcc: "460", Mnc: "0", Lac: "1", Cid: "1", Lat: -91, Lng: 104},
+		{Radio: "gsm", Mcc: "460", Mnc: "0", Lac: "1", Cid: "1", Lat: 30, Lng: 181},
+		{Radio: "gsm", Mcc: "460", Mnc: "0", Lac: "1", Cid: "1", Lat: 30, Lng: -181},
+		{Radio: "gsm", Mcc: "460", Mnc: "0", Lac: "1", Cid: "1"}, // Null Island
+		{Radio: "cdma", Mcc: "460", Mnc: "3", Lac: "1", Cid: "1", Lat: 30, Lng: 104},
+		{Radio: "gsm", Mcc: "46", Mnc: "0", Lac: "1", Cid: "1", Lat: 30, Lng: 104},
+		{Radio: "gsm", Mcc: "460", Mnc: "0", Lac: "1", Cid: "x", Lat: 30, Lng: 104},
+		{Radio: "gsm", Mcc: "460", Mnc: "0", Lac: "1", Cid: "1", Lat: 30, Lng: 104, Range: -1},
+	} {
+		station := invalid
+		if _, err := service.Create(nil, &station); err == nil {
+			t.Errorf("%+v should be invalid", invalid)
+		}
+	}
+	if len(repo.stations) != 2 || len(invalidator.ids) != 2 {
+		t.Errorf("invalid stations should not be created: %v, %v", repo.stations, invalidator.ids)
+	}
+}
+
+func TestStationService_Update(t *testing.T) {
+	existing := models.Station{Id: "460-0-32838-60122", Radio: "gsm", Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60122",
+		Lat: 30.732796, Lng: 103.962357, Samples: 5, Range: 1000, Source: models.SourceLearned}
+	repo := &mockStationRepo{stations: map[string]models.Station{existing.Id: existing}}
+	invalidator := &mockStationInvalidator{}
+	service := NewStationService(repo, invalidator)
+
+	// only coordinates and range are changed, the identifiers given are ignored
+	updated, err := service.Update(nil, existing.Id, &models.Station{Id: "other", Radio: "lte", Mcc: "310", Mnc: "260",
+		Lac: "1", Cid: "2", Lat: 30.8, Lng: 104.1, Range: 500, Samples: 100})
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := existing
+	expected.Lat, expected.Lng, expected.Range, expected.Source = 30.8, 104.1, 500, models.SourceManual
+	if *updated != expected || repo.stations[existing.Id] != expected || len(repo.stations) != 1 {
+		t.Errorf("unexpected station %+v, expecting %+v", updated, expected)
+	}
+	if len(invalidator.ids) != 1 || invalidator.ids[0] != existing.Id {
+		t.Errorf("unexpected invalidated %v", invalidator.ids)
+	}
+
+	if _, err := service.Update(nil, existing.Id, &models.Station{Lat: 30.8, Lng: 190}); err == nil {
+		t.Error("invalid coordinates should fail")
+	}
+	if repo.stations[existing.Id] != expected {
+		t.Errorf("station updated with invalid coordinates: %+v", repo.stations[existing.Id])
+	}
+	if _, err := service.Update(nil, "460-0-1-1", &models.Station{Lat: 30.8, Lng: 104.1}); err != sql.ErrNoRows {
+		t.Errorf("updating missing station should fail by sql.ErrNoRows, got %v", err)
+	}
+}
+
+func TestStationService_Delete(t *testing.T) {
+	existing := models.Station{Id: "460-0-32838-60122", Radio: "gsm", Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60122",
+		Lat: 30.732796, Lng: 103.962357}
+	repo := &mockStationRepo{stations: map[string]models.Station{existing.Id: existing}}
+	invalidator := &mockStationInvalidator{}
+	service := NewStationService(repo, invalidator)
+
+	deleted, err := service.Delete(nil, existing.Id)
+	if err != nil || *deleted != existing {
+		t.Errorf("unexpected deleted station %+v, error %v", deleted, err)
+	}
+	if len(repo.stations) != 0 || len(invalidator.ids) != 1 || invalidator.ids[0] != existing.Id {
+		t.Errorf("station not deleted or invalidated: %v, %v", repo.stations, invalidator.ids)
+	}
+	if _, err := service.Delete(nil, existing.Id); err != sql.ErrNoRows {
+		t.Errorf("deleting missing station should fail by sql.ErrNoRows, got %v", err)
+	}
+}
+
+func TestStationService_Query(t *testing.T) {
+	repo := &mockStationRepo{stations: map[string]models.Station{}}
+	for _, station := range []models.Station{
+		{Radio: "gsm", Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60122", Lat: 30.732796, Lng: 103.962357},
+		{Radio: "lte", Mcc: "460", Mnc: "0", Lac: "6244", Cid: "84115972", Lat: 30.572815, Lng: 104.065735},
+	} {
+		station.Id = models.BuildStationId(station.Radio, station.Mcc, station.Mnc, station.Lac, station.Cid)
+		repo.stations[station.Id] = station
+	}
+	service := NewStationService(repo, nil)
+
+	// filters are applied by the repo, see repos.buildStationFilter
+	filter := &models.StationFilter{Radio: "lte", Bounds: &models.Bounds{MinLat: 30, MinLng: 103, MaxLat: 31, MaxLng: 105}}
+	if total, err := service.Count(nil, filter); err != nil || total != 2 || repo.filter != filter {
+		t.Errorf("counted %d by filter %+v, error %v", total, repo.filter, err)
+	}
+	repo.filter = nil
+	stations, err := service.Query(nil, filter, 20, 10)
+	if err != nil || len(stations) != 2 || stations[0].Id != "460-0-32838-60122" {
+		t.Errorf("unexpected stations %+v, error %v", stations, err)
+	}
+	if repo.filter != filter || repo.offset != 20 || repo.limit != 10 {
+		t.Errorf("queried by filter %+v, offset %d and limit %d", repo.filter, repo.offset, repo.limit)
+	}
+}
@@ -1,42 +1,100 @@
 package services
 
 import (
+	log "github.com/Sirupsen/logrus"
 	"math"
 	"xungewang.cn/bsp/apis"
 	"xungewang.cn/bsp/errors"
+	"xungewang.cn/bsp/models"
 )
 
-// actual method to calculate the position
-func doComputePosition(stations []*signalAwareStation) (*apis.PositionResult, error) {
+// actual method to calculate the position. How it's computed is recorded in the explanation if given.
+func doComputePosition(stations []*SignalAwareStation, locator Locator, outliers *outlierFilter,
+	explanation *apis.Explanation) (*apis.PositionResult, error) {
 	// stations can diverge, find closest ones (in other words, eliminate those far away)
-	stations = findClosestStations(stations)
+	stations, rejected := outliers.findClosestStations(stations, explanation)
 	if len(stations) == 0 {
 		return nil, errors.NotFound("no suitable stations")
 	}
 
-	return triangulate(stations), nil
+	position, err := locator.Locate(stations)
+	if err != nil {
+		return nil, err
+	}
+	position.Rejected = rejected
+
+	if explanation != nil {
+		explainStations(explanation, stations, rejected, locator)
+		position.Explanation = explanation
+	}
+
+	return position, nil
 }
 
-const (
-	// standard derivation threshold to detect non-close stations
-	stdDevThreshold = 0.03
+// fill in weights and elimination rounds of stations to the explanation
+func explainStations(explanation *apis.Explanation, stations []*SignalAwareStation, rejected []*apis.RejectedStation,
+	locator Locator) {
+	weightOfStation := make(map[string]float64, len(stations))
+	if weigher, ok := locator.(weigher); ok {
+		weights, sum := weigher.weigh(stations), 0.0
+		for _, weight := range weights {
+			sum += weight
+		}
+		for i, station := range stations {
+			weightOfStation[station.Id] = weights[i] / sum
+		}
+	}
+
+	roundOfStation := make(map[string]int, len(rejected))
+	for _, station := range rejected {
+		roundOfStation[station.Id] = station.Round
+	}
 
+	for _, signal := range explanation.Signals {
+		signal.Weight = weightOfStation[signal.StationId]
+		signal.EliminatedInRound = roundOfStation[signal.StationId]
+	}
+}
+
+const (
 	// degree to radians
 	d2R = math.Pi / 180.0
+
+	// mean radius of the earth, in meters
+	earthRadius = 6371008.8
+
+	// typical coverage radius (in meters) of a single station. It's the accuracy we can achieve with only one
+	// station, and it shrinks as more stations get involved.
+	stationRange = 1000.0
 )
 
-// find stations that live close
-func findClosestStations(stations []*signalAwareStation) []*signalAwareStation {
-	for len(stations) > 1 { // compute if and only if there's more than one station
-		// by close, it means the standard derivation of stations, precisely, their latitudes and longitudes,
-		// are less than the defined threshold. To calculate standard derivation, first compute average value.
-		avgLat, avgLng := 0.0, 0.0
-		for _, station := range stations {
-			avgLat += station.Lat
-			avgLng += station.Lng
-		}
-		avgLat /= float64(len(stations))
-		avgLng /= float64(len(stations))
+// outlierFilter detects stations far away from others
+type outlierFilter struct {
+	// standard deviation (in meters) of distances from stations to their center, below which stations
+	// are considered close
+	threshold float64
+
+	// stations are never eliminated down to less than this number
+	minStations int
+}
+
+// NewOutlierFilter creates an outlierFilter
+func NewOutlierFilter(threshold float64, minStations int) *outlierFilter {
+	return &outlierFilter{threshold, minStations}
+}
+
+// find stations that live close, with rejected ones reported. Each round of elimination is recorded in
+// the explanation if given.
+func (filter *outlierFilter) findClosestStations(stations []*SignalAwareStation,
+	explanation *apis.Explanation) ([]*SignalAwareStation, []*apis.RejectedStation) {
+	var rejected []*apis.RejectedStation
+	// compute if and only if there's more than one station, and more than the minimum to keep
+	for round := 1; len(stations) > 1 && len(stations) > filter.minStations; round++ {
+		// by close, it means the standard derivation of distances from stations to their center are less than
+		// the threshold. Distances are measured in meters, so that the threshold means the same at any latitude.
+		// To calculate standard derivation, first compute the center. It's the spherical centroid rather than
+		// average latitude and longitude, which goes wrong for stations on both sides of the antimeridian.
+		avgLat, avgLng := sphericalCentroid(stations, nil)
 
 		// calculate standard derivation, and track the one far from the flock.
 		// maxDistance: the max distance from the center denoted by (avgLat, avgLng)
@@ -44,66 +102,130 @@ func findClosestStations(stations []*signalAwareStation) []*signalAwareStation {
 		// sum: sum of square of distances, used for calculating standard deviation later
 		maxDistance, maxDistanceIndex, sum := 0.0, 0, 0.0
 		for index, station := range stations {
-			distance := math.Pow(station.Lat-avgLat, 2) + math.Pow(station.Lng-avgLng, 2)
+			distance := haversine(station.Lat, station.Lng, avgLat, avgLng)
 			if distance > maxDistance {
 				maxDistance = distance
 				maxDistanceIndex = index
 			}
 
-			sum += distance
+			sum += distance * distance
 		}
 
-		if math.Sqrt(sum/float64(len(stations))) < stdDevThreshold { // stations are close enough
-			return stations
+		stdDev := math.Sqrt(sum / float64(len(stations)))
+		if stdDev < filter.threshold { // stations are close enough
+			if explanation != nil {
+				explanation.Rounds = append(explanation.Rounds,
+					&apis.EliminationRound{Round: round, Lat: avgLat, Lng: avgLng, Spread: stdDev})
+			}
+			return stations, rejected
 		}
 
 		// eliminate the station far away, and check again
+		far := stations[maxDistanceIndex]
+		log.Debugf("station %s rejected, %f m away from others", far.Id, maxDistance)
+		rejected = append(rejected,
+			&apis.RejectedStation{Id: far.Id, Lat: far.Lat, Lng: far.Lng, Distance: maxDistance, Round: round})
+		if explanation != nil {
+			explanation.Rounds = append(explanation.Rounds,
+				&apis.EliminationRound{Round: round, Lat: avgLat, Lng: avgLng, Spread: stdDev, Eliminated: far.Id})
+		}
 		stations = append(stations[0:maxDistanceIndex], stations[maxDistanceIndex+1:]...)
 	}
 
-	return stations
+	return stations, rejected
 }
 
-func triangulate(stations []*signalAwareStation) *apis.PositionResult {
-	lats := make([]float64, len(stations))
-	lngs := make([]float64, len(stations))
-	distanceWeights := make([]float64, len(stations))
-	distanceProduct, distanceSum := 1.0, 0.0
+func triangulate(stations []*SignalAwareStation, pathLoss *pathLossModels) *apis.PositionResult {
+	// the closer a station is, the more it weighs
+	distanceWeights := inverseDistanceWeights(stations, pathLoss, 1)
+	distanceSum := 0.0
+	for _, weight := range distanceWeights {
+		distanceSum += weight
+	}
+
+	lat, lng := sphericalCentroid(stations, distanceWeights)
 
+	return apis.NewPositionResult(lat, lng, estimateAccuracy(lat, lng, stations, distanceWeights, distanceSum))
+}
+
+// inverseDistanceWeights weighs stations by inverse of their distances raised to the given power. Distances are
+// estimated by path loss models of stations' radio bands.
+func inverseDistanceWeights(stations []*SignalAwareStation, pathLoss *pathLossModels, power float64) []float64 {
+	weights := make([]float64, len(stations))
 	for i, station := range stations {
-		lats[i] = station.Lat * d2R
-		lngs[i] = station.Lng * d2R
-
-		// the method to calculate distance weight written in C language is:
-		//
-		//   freq := 1000
-		//   distanceWeights[i] = math.Pow(10, ((130 + station.SignalStrength - 20 * math.Log10(freq)) / 20)
-		//
-		// The value of freq variable never changes. In that case, math.Log10(freq) will always be 3,
-		// and the whole express '((130 + station.SignalStrength - 20 * math.Log10(freq)) / 20' can be simplified as
-		// '3.5+station.SignalStrength/20'
-		distanceWeights[i] = math.Pow(10, 3.5+station.SignalStrength/20)
-
-		distanceProduct *= distanceWeights[i]
-		distanceSum += distanceWeights[i]
+		weights[i] = math.Pow(pathLoss.of(station.Radio).distance(station.SignalStrength), -power)
 	}
 
+	return weights
+}
+
+// sphericalCentroid computes the weighted centroid (in degrees) of stations on the sphere, by averaging their
+// positions in cartesian coordinates and projecting the average back onto the sphere. Stations weigh equally
+// if no weights given.
+func sphericalCentroid(stations []*SignalAwareStation, weights []float64) (lat, lng float64) {
 	x, y, z := 0.0, 0.0, 0.0
-	for i := range stations {
-		x += math.Cos(lats[i]) * math.Cos(lngs[i]) * distanceWeights[i]
-		y += math.Cos(lats[i]) * math.Sin(lngs[i]) * distanceWeights[i]
-		z += math.Sin(lats[i]) * distanceWeights[i]
+	for i, station := range stations {
+		weight := 1.0
+		if weights != nil {
+			weight = weights[i]
+		}
+
+		lat, lng := station.Lat*d2R, station.Lng*d2R
+		x += math.Cos(lat) * math.Cos(lng) * weight
+		y += math.Cos(lat) * math.Sin(lng) * weight
+		z += math.Sin(lat) * weight
 	}
 
-	x /= distanceSum
-	y /= distanceSum
-	z /= distanceSum
+	// the scale of (x, y, z) doesn't matter, no need to divide by weight sum
+	return projectOntoSphere(x, y, z)
+}
+
+// areaCentroid locates the area at the centroid of its stations on the sphere, along with the root mean square
+// distance (in meters) from the stations to it. For n unit vectors summing up to S, the mean squared chord between
+// them and the direction of S is 2(1 - |S|/n).
+func areaCentroid(area models.Area) (lat, lng, spread float64) {
+	lat, lng = projectOntoSphere(area.X, area.Y, area.Z)
+	resultant := math.Sqrt(area.X*area.X+area.Y*area.Y+area.Z*area.Z) / float64(area.Stations)
 
-	lat := math.Atan(z/math.Sqrt(x*x+y*y)) / d2R
-	lng := math.Atan(y/x) / d2R
-	if lng < 0 {
-		lng += 180
+	return lat, lng, earthRadius * math.Sqrt(2*math.Max(0, 1-resultant))
+}
+
+// projectOntoSphere returns the position (in degrees) the cartesian vector (x, y, z) points to.
+//
+// atan2 (rather than atan) takes signs of both arguments into account, which gets the quadrant right for
+// western and southern hemispheres. atan2 ranges over [-180, 180] in degrees, whose 180 is wrapped to -180
+// as other longitudes are.
+func projectOntoSphere(x, y, z float64) (lat, lng float64) {
+	return math.Atan2(z, math.Hypot(x, y)) / d2R, normalizeLng(math.Atan2(y, x) / d2R)
+}
+
+// normalizeLng wraps a longitude into [-180, 180)
+func normalizeLng(lng float64) float64 {
+	return math.Mod(math.Mod(lng+180, 360)+360, 360) - 180
+}
+
+// estimate the radius (in meters) of the uncertainty circle around the computed position (lat, lng).
+//
+// The more the stations spread (weighted by their signals) around the position, the less accurate the position is.
+// On the other hand, the more stations there are, the more confident we are. Combining both, the accuracy is
+// the weighted root mean square distance from stations to the position, plus the station range scaled down by
+// the square root of the number of stations.
+func estimateAccuracy(lat, lng float64, stations []*SignalAwareStation, weights []float64, weightSum float64) float64 {
+	spread := 0.0
+	for i, station := range stations {
+		spread += math.Pow(haversine(lat, lng, station.Lat, station.Lng), 2) * weights[i]
 	}
+	spread = math.Sqrt(spread / weightSum)
+
+	return spread + stationRange/math.Sqrt(float64(len(stations)))
+}
+
+// haversine computes the great-circle distance (in meters) between two points given in degrees
+func haversine(lat1, lng1, lat2, lng2 float64) float64 {
+	dLat := (lat2 - lat1) * d2R
+	dLng := (lng2 - lng1) * d2R
+
+	a := math.Pow(math.Sin(dLat/2), 2) + math.Cos(lat1*d2R)*math.Cos(lat2*d2R)*math.Pow(math.Sin(dLng/2), 2)
 
-	return apis.NewPositionResult(lat, lng)
+	return 2 * earthRadius * math.Asin(math.Min(1, math.Sqrt(a)))
 }
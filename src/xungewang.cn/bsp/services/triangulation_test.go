@@ -4,13 +4,14 @@ import (
 	"math"
 	"sort"
 	"testing"
+	"xungewang.cn/bsp/app"
 	"xungewang.cn/bsp/models"
 )
 
 const EPSILON = 1e-6
 
 func TestDoComputePosition_Normal(t *testing.T) {
-	stations := []*signalAwareStation{
+	stations := []*SignalAwareStation{
 		{&models.Station{Id: "0-32838-60122", Lat: 30.732796, Lng: 103.962357}, -77},
 		{&models.Station{Id: "0-32838-60123", Lat: 30.734688, Lng: 103.961433}, -83},
 		{&models.Station{Id: "0-32838-36861", Lat: 30.730850, Lng: 103.965279}, -88},
@@ -19,7 +20,8 @@ func TestDoComputePosition_Normal(t *testing.T) {
 		{&models.Station{Id: "0-32838-36863", Lat: 30.732002, Lng: 103.958771}, -97},
 	}
 
-	if r, err := doComputePosition(stations); err == nil {
+	if r, err := doComputePosition(stations, NewLocatorRegistry(NewPathLossModels(app.PathLossConfig{}),
+		AlgorithmWeightedCentroid).locators[AlgorithmWeightedCentroid], NewOutlierFilter(3000, 1), nil); err == nil {
 		if !(math.Abs(math.Dim(r.Lat, 30.732924)) < EPSILON &&
 			math.Abs(math.Dim(r.Lng, 103.962488)) < EPSILON) {
 			t.Error("lat/lng not expected")
@@ -30,14 +32,14 @@ func TestDoComputePosition_Normal(t *testing.T) {
 }
 
 func TestFindClosestStations(t *testing.T) {
-	stations := []*signalAwareStation{
+	stations := []*SignalAwareStation{
 		{&models.Station{Id: "0-32838-60122", Lat: 30.732796, Lng: 103.962357}, -77},
 		{&models.Station{Id: "0-32838-60123", Lat: 30.734688, Lng: 103.961433}, -83},
 		{&models.Station{Id: "0-32838-36861", Lat: 30.730850, Lng: 104.965279}, -88},
 		{&models.Station{Id: "0-32838-60125", Lat: 30.732283, Lng: 103.961327}, -95},
 	}
 
-	closetStations := findClosestStations(stations)
+	closetStations, rejected := NewOutlierFilter(3000, 1).findClosestStations(stations, nil)
 
 	// station with id '0-32838-36861' should be excluded as it's too far away from other stations
 	closetStationIds := make([]string, len(closetStations), len(closetStations))
@@ -50,4 +52,90 @@ func TestFindClosestStations(t *testing.T) {
 		closetStationIds[1] != "0-32838-60123" || closetStationIds[2] != "0-32838-60125" {
 		t.Error("station(0-32838-36861) should be excluded")
 	}
+	if len(rejected) != 1 || rejected[0].Id != "0-32838-36861" {
+		t.Error("station(0-32838-36861) should be reported as rejected")
+	}
+}
+
+func TestFindClosestStations_Mcc(t *testing.T) {
+	// stations sharing mnc-lac-cid in different countries are told apart by MCC
+	stations := []*SignalAwareStation{
+		{&models.Station{Id: "460-0-32838-60122", Lat: 30.732796, Lng: 103.962357}, -77},
+		{&models.Station{Id: "460-0-32838-60123", Lat: 30.734688, Lng: 103.961433}, -83},
+		{&models.Station{Id: "310-0-32838-60122", Lat: 37.774929, Lng: -122.419416}, -88},
+		{&models.Station{Id: "460-0-32838-60125", Lat: 30.732283, Lng: 103.961327}, -95},
+	}
+
+	closest, rejected := NewOutlierFilter(3000, 1).findClosestStations(stations, nil)
+	if len(closest) != 3 || len(rejected) != 1 || rejected[0].Id != "310-0-32838-60122" {
+		t.Errorf("station(310-0-32838-60122) should be rejected, got %d kept and %d rejected", len(closest), len(rejected))
+	}
+}
+
+func TestFindClosestStations_HighLatitude(t *testing.T) {
+	// 0.06 degree of longitude is only about 2.5 km at 68 degree north, stations are close enough
+	stations := []*SignalAwareStation{
+		{&models.Station{Id: "244-5-3001-1", Lat: 68.0, Lng: 24.00}, -77},
+		{&models.Station{Id: "244-5-3001-2", Lat: 68.0, Lng: 24.06}, -83},
+		{&models.Station{Id: "244-5-3001-3", Lat: 68.0, Lng: 24.03}, -88},
+	}
+
+	if closest, rejected := NewOutlierFilter(3000, 1).findClosestStations(stations, nil); len(closest) != 3 ||
+		len(rejected) != 0 {
+		t.Errorf("no station should be rejected, got %d rejected", len(rejected))
+	}
+}
+
+func TestFindClosestStations_MinStations(t *testing.T) {
+	stations := []*SignalAwareStation{
+		{&models.Station{Id: "460-0-32838-60122", Lat: 30.732796, Lng: 103.962357}, -77},
+		{&models.Station{Id: "460-0-32838-60123", Lat: 30.734688, Lng: 104.961433}, -83},
+		{&models.Station{Id: "460-0-32838-36861", Lat: 31.730850, Lng: 103.965279}, -88},
+	}
+
+	if closest, rejected := NewOutlierFilter(3000, 2).findClosestStations(stations, nil); len(closest) != 2 ||
+		len(rejected) != 1 {
+		t.Errorf("2 stations should be kept, got %d kept and %d rejected", len(closest), len(rejected))
+	}
+}
+
+func TestTriangulate_Accuracy(t *testing.T) {
+	single := []*SignalAwareStation{
+		{&models.Station{Id: "0-32838-60122", Lat: 30.732796, Lng: 103.962357}, -77},
+	}
+	if r := triangulate(single, NewPathLossModels(app.PathLossConfig{})); math.Abs(r.Accuracy-stationRange) > EPSILON {
+		t.Errorf("accuracy of single station should be %f, got %f", stationRange, r.Accuracy)
+	}
+
+	nearby := []*SignalAwareStation{
+		{&models.Station{Id: "0-32838-60122", Lat: 30.732796, Lng: 103.962357}, -77},
+		{&models.Station{Id: "0-32838-60123", Lat: 30.734688, Lng: 103.961433}, -83},
+		{&models.Station{Id: "0-32838-36861", Lat: 30.730850, Lng: 103.965279}, -88},
+		{&models.Station{Id: "0-32838-60125", Lat: 30.732283, Lng: 103.961327}, -95},
+	}
+	nearbyAccuracy := triangulate(nearby, NewPathLossModels(app.PathLossConfig{})).Accuracy
+	if nearbyAccuracy >= stationRange {
+		t.Errorf("more stations close to each other should improve accuracy, got %f", nearbyAccuracy)
+	}
+
+	spread := []*SignalAwareStation{
+		{&models.Station{Id: "0-32838-60122", Lat: 30.732796, Lng: 103.962357}, -77},
+		{&models.Station{Id: "0-32838-60123", Lat: 30.744688, Lng: 103.951433}, -83},
+		{&models.Station{Id: "0-32838-36861", Lat: 30.720850, Lng: 103.975279}, -88},
+		{&models.Station{Id: "0-32838-60125", Lat: 30.742283, Lng: 103.971327}, -95},
+	}
+	if spreadAccuracy := triangulate(spread, NewPathLossModels(app.PathLossConfig{})).Accuracy; spreadAccuracy <= nearbyAccuracy {
+		t.Errorf("stations spreading wider should worsen accuracy, got %f (vs %f)", spreadAccuracy, nearbyAccuracy)
+	}
+}
+
+func TestHaversine(t *testing.T) {
+	// one degree of latitude is about 111.2 km
+	if d := haversine(30, 104, 31, 104); math.Abs(d-111195) > 10 {
+		t.Errorf("unexpected distance: %f", d)
+	}
+
+	if d := haversine(30.732796, 103.962357, 30.732796, 103.962357); d != 0 {
+		t.Errorf("distance to itself should be 0, got %f", d)
+	}
 }
@@ -0,0 +1,128 @@
+package services
+
+import (
+	"math"
+	"xungewang.cn/bsp/apis"
+)
+
+const (
+	// maximum iterations of Levenberg-Marquardt algorithm
+	maxIterations = 100
+
+	// iteration stops once the position moves less than this (in meters)
+	convergenceThreshold = 0.01
+
+	// initial damping factor of Levenberg-Marquardt algorithm, and how it gets adjusted per iteration
+	initialDamping = 1e-3
+	dampingFactor  = 10.0
+	maxDamping     = 1e10
+)
+
+// trilaterate finds the position whose distances to stations best match distances estimated from their signals,
+// by solving the weighted nonlinear least squares problem with Levenberg-Marquardt algorithm. Unlike triangulate,
+// the position is not confined within stations, which matters for devices at the edge of coverage.
+//
+// The problem is solved on a local plane tangent to the earth at the weighted centroid of stations, which is also
+// where iteration starts. The centroid is returned as is if there're less than 2 stations, or the algorithm fails
+// to converge.
+func trilaterate(stations []*SignalAwareStation, pathLoss *pathLossModels) *apis.PositionResult {
+	centroid := triangulate(stations, pathLoss)
+	if len(stations) < 2 {
+		return centroid
+	}
+
+	// project stations onto the plane, with the centroid as its origin
+	plane := newLocalPlane(centroid.Lat, centroid.Lng)
+	xs := make([]float64, len(stations))
+	ys := make([]float64, len(stations))
+	distances := make([]float64, len(stations))
+	// errors of estimated distances grow with distances, so far stations weigh less
+	weights := inverseDistanceWeights(stations, pathLoss, 2)
+	weightSum := 0.0
+	for i, station := range stations {
+		xs[i], ys[i] = plane.project(station.Lat, station.Lng)
+		distances[i] = pathLoss.of(station.Radio).distance(station.SignalStrength)
+		weightSum += weights[i]
+	}
+
+	// cost is the weighted sum of squared residuals, where a residual is the difference between
+	// distance of (x, y) to a station and the estimated one.
+	cost := func(x, y float64) float64 {
+		sum := 0.0
+		for i := range stations {
+			residual := math.Hypot(x-xs[i], y-ys[i]) - distances[i]
+			sum += weights[i] * residual * residual
+		}
+		return sum
+	}
+
+	x, y, damping := 0.0, 0.0, initialDamping
+	current, converged := cost(x, y), false
+	for iteration := 0; iteration < maxIterations && !converged && damping < maxDamping; iteration++ {
+		// accumulate normal equations (J^T W J) * step = -J^T W r, where J is the jacobian of residuals
+		jxx, jxy, jyy, gx, gy := 0.0, 0.0, 0.0, 0.0, 0.0
+		for i := range stations {
+			dx, dy := x-xs[i], y-ys[i]
+			r := math.Hypot(dx, dy)
+			if r < minDistance { // jacobian is undefined right at the station
+				continue
+			}
+
+			ux, uy, residual := dx/r, dy/r, r-distances[i]
+			jxx += weights[i] * ux * ux
+			jxy += weights[i] * ux * uy
+			jyy += weights[i] * uy * uy
+			gx += weights[i] * ux * residual
+			gy += weights[i] * uy * residual
+		}
+
+		// damped 2x2 system solved by Cramer's rule
+		axx, ayy := jxx*(1+damping), jyy*(1+damping)
+		determinant := axx*ayy - jxy*jxy
+		if determinant == 0 {
+			damping *= dampingFactor
+			continue
+		}
+		stepX := (-gx*ayy + gy*jxy) / determinant
+		stepY := (-gy*axx + gx*jxy) / determinant
+
+		if next := cost(x+stepX, y+stepY); next < current { // accept the step and trust the model more
+			x, y, current = x+stepX, y+stepY, next
+			damping /= dampingFactor
+			converged = math.Hypot(stepX, stepY) < convergenceThreshold
+		} else { // reject the step and move more like gradient descent
+			damping *= dampingFactor
+		}
+	}
+
+	if !converged && damping < maxDamping {
+		return centroid
+	}
+
+	lat, lng := plane.unproject(x, y)
+
+	// the less residuals are, the more accurate the position is. Like triangulate, more stations bring more
+	// confidence, as estimated distances always carry errors.
+	return apis.NewPositionResult(lat, lng, math.Sqrt(current/weightSum)+stationRange/math.Sqrt(float64(len(stations))))
+}
+
+// localPlane is an equirectangular projection around an origin, which is accurate enough within tens of kilometers
+type localPlane struct {
+	lat, lng float64 // origin, in degrees
+	cosLat   float64
+}
+
+func newLocalPlane(lat, lng float64) *localPlane {
+	return &localPlane{lat, lng, math.Cos(lat * d2R)}
+}
+
+// project converts (lat, lng) to coordinates (in meters) on the plane, x pointing east and y north.
+// Longitude difference is wrapped, so that points across the antimeridian stay close on the plane.
+func (plane *localPlane) project(lat, lng float64) (x, y float64) {
+	return normalizeLng(lng-plane.lng) * d2R * earthRadius * plane.cosLat, (lat - plane.lat) * d2R * earthRadius
+}
+
+// unproject converts coordinates on the plane back to (lat, lng)
+func (plane *localPlane) unproject(x, y float64) (lat, lng float64) {
+	return plane.lat + y/earthRadius/d2R, normalizeLng(plane.lng + x/(earthRadius*plane.cosLat)/d2R)
+}
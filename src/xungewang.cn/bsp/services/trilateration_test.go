@@ -0,0 +1,62 @@
+package services
+
+import (
+	"math"
+	"testing"
+	"xungewang.cn/bsp/app"
+	"xungewang.cn/bsp/models"
+)
+
+// build a station at (lat, lng) whose signal strength matches exactly the distance to (targetLat, targetLng)
+func newStationHeardAt(id string, lat, lng, targetLat, targetLng float64) *SignalAwareStation {
+	// free space model at 1000 MHz with 0 dBm tx power: strength = -(92.44 + 20 * log10(d in km))
+	strength := -(92.44 + 20*math.Log10(haversine(lat, lng, targetLat, targetLng)/1000))
+	return &SignalAwareStation{&models.Station{Id: id, Lat: lat, Lng: lng}, strength}
+}
+
+func TestTrilaterate_OutsideStations(t *testing.T) {
+	// device sits to the north east of all stations
+	targetLat, targetLng := 30.745, 103.975
+	stations := []*SignalAwareStation{
+		newStationHeardAt("460-0-32838-60122", 30.732796, 103.962357, targetLat, targetLng),
+		newStationHeardAt("460-0-32838-60123", 30.734688, 103.961433, targetLat, targetLng),
+		newStationHeardAt("460-0-32838-36861", 30.730850, 103.965279, targetLat, targetLng),
+		newStationHeardAt("460-0-32838-60125", 30.732283, 103.961327, targetLat, targetLng),
+	}
+	pathLoss := NewPathLossModels(app.PathLossConfig{})
+
+	r := trilaterate(stations, pathLoss)
+	if d := haversine(r.Lat, r.Lng, targetLat, targetLng); d > 1 {
+		t.Errorf("position should be within 1 m to the target, got %f m away", d)
+	}
+
+	// the centroid is trapped within stations
+	c := triangulate(stations, pathLoss)
+	if d := haversine(c.Lat, c.Lng, targetLat, targetLng); d < 1000 {
+		t.Errorf("centroid is expected to be far from the target, got %f m away", d)
+	}
+}
+
+func TestTrilaterate_SingleStation(t *testing.T) {
+	stations := []*SignalAwareStation{
+		{&models.Station{Id: "460-0-32838-60122", Lat: 30.732796, Lng: 103.962357}, -77},
+	}
+
+	r := trilaterate(stations, NewPathLossModels(app.PathLossConfig{}))
+	if math.Abs(r.Lat-30.732796) > EPSILON || math.Abs(r.Lng-103.962357) > EPSILON {
+		t.Errorf("single station should be the position, got %s", r)
+	}
+}
+
+func TestLocalPlane(t *testing.T) {
+	plane := newLocalPlane(30.732796, 103.962357)
+
+	x, y := plane.project(30.734688, 103.961433)
+	if d := math.Hypot(x, y) - haversine(30.732796, 103.962357, 30.734688, 103.961433); math.Abs(d) > 0.1 {
+		t.Errorf("projected distance differs %f m from the great circle distance", d)
+	}
+
+	if lat, lng := plane.unproject(x, y); math.Abs(lat-30.734688) > EPSILON || math.Abs(lng-103.961433) > EPSILON {
+		t.Errorf("unexpected unprojected position (%f, %f)", lat, lng)
+	}
+}
@@ -0,0 +1,26 @@
+package services
+
+import (
+	"xungewang.cn/bsp/app"
+	"xungewang.cn/bsp/models"
+	"xungewang.cn/bsp/repos"
+)
+
+// unknownSignalService should implements apis.unknownSignalService interface
+type unknownSignalService struct {
+	repo repos.UnknownSignalRepo
+}
+
+func (service *unknownSignalService) Count(ctx app.RequestScope, filter *models.UnknownSignalFilter) (int, error) {
+	return service.repo.Count(ctx, filter)
+}
+
+// QueryTop queries the most frequently seen unknown signals, which tells stations to source first
+func (service *unknownSignalService) QueryTop(ctx app.RequestScope, filter *models.UnknownSignalFilter, offset, limit int) ([]models.UnknownSignal, error) {
+	return service.repo.QueryTop(ctx, filter, offset, limit)
+}
+
+// NewUnknownSignalService create an instance of unknownSignalService
+func NewUnknownSignalService(repo repos.UnknownSignalRepo) *unknownSignalService {
+	return &unknownSignalService{repo}
+}
@@ -0,0 +1,73 @@
+package services
+
+import (
+	"testing"
+	"time"
+	"xungewang.cn/bsp/apis"
+	"xungewang.cn/bsp/app"
+	"xungewang.cn/bsp/models"
+)
+
+// mockUnknownSignalRepo returns signals as they are, with the filter and page recorded. Filtering and ordering are
+// left to repos.
+type mockUnknownSignalRepo struct {
+	signals       []models.UnknownSignal
+	filter        *models.UnknownSignalFilter
+	offset, limit int
+}
+
+func (repo *mockUnknownSignalRepo) Count(ctx app.RequestScope, filter *models.UnknownSignalFilter) (int, error) {
+	repo.filter = filter
+	return len(repo.signals), nil
+}
+func (repo *mockUnknownSignalRepo) QueryTop(ctx app.RequestScope, filter *models.UnknownSignalFilter, offset, limit int) ([]models.UnknownSignal, error) {
+	repo.filter, repo.offset, repo.limit = filter, offset, limit
+	return repo.signals, nil
+}
+
+func TestUnknownSignalService_QueryTop(t *testing.T) {
+	repo := &mockUnknownSignalRepo{signals: []models.UnknownSignal{{Id: "460-0-32838-60122", HitCount: 4},
+		{Id: "lte-460-0-6334-127502337", HitCount: 3}}}
+	service := NewUnknownSignalService(repo)
+
+	since := time.Date(2017, 1, 2, 0, 0, 0, 0, time.UTC)
+	filter := &models.UnknownSignalFilter{Radio: "gsm", Mcc: "460", Since: &since}
+	if total, err := service.Count(nil, filter); err != nil || total != 2 || repo.filter != filter {
+		t.Errorf("counted %d by filter %+v, error %v", total, repo.filter, err)
+	}
+	repo.filter = nil
+	signals, err := service.QueryTop(nil, filter, 20, 10)
+	if err != nil || len(signals) != 2 || signals[0].Id != "460-0-32838-60122" {
+		t.Errorf("unexpected signals %+v, error %v", signals, err)
+	}
+	if repo.filter != filter || repo.offset != 20 || repo.limit != 10 {
+		t.Errorf("queried by filter %+v, offset %d and limit %d", repo.filter, repo.offset, repo.limit)
+	}
+}
+
+func TestAggregateUnknownSignals(t *testing.T) {
+	day := time.Date(2017, 1, 1, 0, 0, 0, 0, time.UTC)
+	gsm := apis.Signal{Radio: apis.RadioGSM, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60122", Strength: -90,
+		HasStrength: true}
+	missing, strongest := gsm, gsm
+	missing.Strength, missing.HasStrength = 0, false
+	strongest.Strength = 0 // 0 dBm given
+	lte := apis.Signal{Radio: apis.RadioLTE, Mcc: "460", Mnc: "0", Lac: "6334", Cid: "127502337"}
+
+	unknowns := aggregateUnknownSignals([]unknownSighting{
+		{gsm, day.Add(2 * time.Hour)}, {missing, day}, {lte, day}, {strongest, day.Add(time.Hour)},
+		{lte, day.AddDate(0, 0, 1)},
+	})
+	if len(unknowns) != 2 {
+		t.Fatalf("sightings should be aggregated by cell, got %+v", unknowns)
+	}
+
+	if signal := unknowns[0]; signal.Id != "460-0-32838-60122" || signal.HitCount != 3 || !signal.FirstSeen.Equal(day) ||
+		!signal.LastSeen.Equal(day.Add(2*time.Hour)) || signal.MaxStrength == nil || *signal.MaxStrength != 0 {
+		t.Errorf("unexpected aggregated signal %+v", signal)
+	}
+	if signal := unknowns[1]; signal.Id != "lte-460-0-6334-127502337" || signal.HitCount != 2 ||
+		!signal.LastSeen.Equal(day.AddDate(0, 0, 1)) || signal.MaxStrength != nil {
+		t.Errorf("unexpected aggregated signal %+v", signal)
+	}
+}
@@ -0,0 +1,202 @@
+package services
+
+import (
+	log "github.com/Sirupsen/logrus"
+	"sync"
+	"sync/atomic"
+	"time"
+	"xungewang.cn/bsp/apis"
+	"xungewang.cn/bsp/app"
+	"xungewang.cn/bsp/models"
+	"xungewang.cn/bsp/repos"
+)
+
+type (
+	// UnknownSignalWriter persists unknown signals asynchronously. Signals are queued in a bounded buffer (and
+	// dropped if it's full, so that requests never wait), and written by a single worker in batches, which are
+	// aggregated by cell and upserted with one statement.
+	UnknownSignalWriter struct {
+		repo      repos.PositionRepo
+		ctx       app.RequestScope
+		queue     chan unknownSighting
+		batchSize int
+		interval  time.Duration
+
+		written uint64
+		dropped uint64
+		failed  uint64
+
+		// closed tells Write whether the writer is closed, under mu which Close locks, so that no signal gets
+		// queued after the worker has drained the queue
+		mu        sync.RWMutex
+		closed    bool
+		closing   chan struct{}
+		done      chan struct{}
+		closeOnce sync.Once
+	}
+
+	unknownSighting struct {
+		signal apis.Signal
+		seenAt time.Time
+	}
+
+	// UnknownSignalWriterStats tells how UnknownSignalWriter is doing
+	UnknownSignalWriterStats struct {
+		// number of signals queued, and the capacity of the queue
+		QueueDepth    int `json:"queue_depth"`
+		QueueCapacity int `json:"queue_capacity"`
+
+		// number of signals written, dropped for the queue being full, and failed to write
+		Written uint64 `json:"written"`
+		Dropped uint64 `json:"dropped"`
+		Failed  uint64 `json:"failed"`
+	}
+)
+
+// Write queues signals without blocking, those not fitting in the queue get dropped
+func (writer *UnknownSignalWriter) Write(signals []apis.Signal) {
+	writer.mu.RLock()
+	defer writer.mu.RUnlock()
+	if writer.closed {
+		atomic.AddUint64(&writer.dropped, uint64(len(signals)))
+		return
+	}
+
+	seenAt := time.Now()
+	for _, signal := range signals {
+		select {
+		case writer.queue <- unknownSighting{signal, seenAt}:
+		default:
+			atomic.AddUint64(&writer.dropped, 1)
+		}
+	}
+}
+
+// Stats returns current stats of the writer
+func (writer *UnknownSignalWriter) Stats() UnknownSignalWriterStats {
+	return UnknownSignalWriterStats{
+		QueueDepth:    len(writer.queue),
+		QueueCapacity: cap(writer.queue),
+		Written:       atomic.LoadUint64(&writer.written),
+		Dropped:       atomic.LoadUint64(&writer.dropped),
+		Failed:        atomic.LoadUint64(&writer.failed),
+	}
+}
+
+// Close writes signals pending and stops the worker. Signals written afterwards are dropped.
+func (writer *UnknownSignalWriter) Close() {
+	writer.closeOnce.Do(func() {
+		writer.mu.Lock()
+		writer.closed = true
+		close(writer.closing)
+		writer.mu.Unlock()
+		<-writer.done
+
+		if stats := writer.Stats(); stats.Dropped > 0 || stats.Failed > 0 {
+			log.Warnf("unknown signal writer closed: %+v", stats)
+		}
+	})
+}
+
+// run is the worker, which writes signals once a batch is full or every interval
+func (writer *UnknownSignalWriter) run() {
+	defer close(writer.done)
+
+	ticker := time.NewTicker(writer.interval)
+	defer ticker.Stop()
+
+	batch := make([]unknownSighting, 0, writer.batchSize)
+	for {
+		select {
+		case sighting := <-writer.queue:
+			if batch = append(batch, sighting); len(batch) >= writer.batchSize {
+				batch = writer.flush(batch)
+			}
+		case <-ticker.C:
+			batch = writer.flush(batch)
+		case <-writer.closing:
+			// drain the queue, no more signals get in since then
+			for {
+				select {
+				case sighting := <-writer.queue:
+					if batch = append(batch, sighting); len(batch) >= writer.batchSize {
+						batch = writer.flush(batch)
+					}
+				default:
+					writer.flush(batch)
+					return
+				}
+			}
+		}
+	}
+}
+
+// flush writes the batch, and returns it emptied for reuse
+func (writer *UnknownSignalWriter) flush(batch []unknownSighting) []unknownSighting {
+	if len(batch) == 0 {
+		return batch
+	}
+
+	unknowns := aggregateUnknownSignals(batch)
+	log.Debugf("persist %d unknown signals of %d cells", len(batch), len(unknowns))
+	if err := writer.repo.RecordUnknownSignals(writer.ctx, unknowns); err != nil {
+		log.Errorf("failed to persist %d unknown signals: %s", len(batch), err)
+		atomic.AddUint64(&writer.failed, uint64(len(batch)))
+	} else {
+		atomic.AddUint64(&writer.written, uint64(len(batch)))
+	}
+
+	return batch[:0]
+}
+
+// aggregateUnknownSignals aggregates sightings by cell
+func aggregateUnknownSignals(sightings []unknownSighting) []models.UnknownSignal {
+	indexes := make(map[string]int)
+	unknowns := make([]models.UnknownSignal, 0, len(sightings))
+	for _, sighting := range sightings {
+		signal := sighting.signal
+		id := buildStationId(signal)
+
+		index, ok := indexes[id]
+		if !ok {
+			index = len(unknowns)
+			indexes[id] = index
+			unknowns = append(unknowns, models.UnknownSignal{
+				Id: id, Radio: signal.Radio, Mcc: signal.Mcc, Mnc: signal.Mnc, Lac: signal.Lac, Cid: signal.Cid,
+				FirstSeen: sighting.seenAt, LastSeen: sighting.seenAt,
+			})
+		}
+
+		unknown := &unknowns[index]
+		unknown.HitCount++
+		if sighting.seenAt.Before(unknown.FirstSeen) {
+			unknown.FirstSeen = sighting.seenAt
+		}
+		if sighting.seenAt.After(unknown.LastSeen) {
+			unknown.LastSeen = sighting.seenAt
+		}
+		if strength := signal.Strength; signal.HasStrength &&
+			(unknown.MaxStrength == nil || strength > *unknown.MaxStrength) {
+			unknown.MaxStrength = &strength
+		}
+	}
+
+	return unknowns
+}
+
+// NewUnknownSignalWriter creates an instance of UnknownSignalWriter and starts its worker. Up to queueSize signals
+// can be pending, which are written batchSize at a time, or every interval.
+func NewUnknownSignalWriter(repo repos.PositionRepo, ctx app.RequestScope, queueSize, batchSize int, interval time.Duration) *UnknownSignalWriter {
+	writer := &UnknownSignalWriter{
+		repo:      repo,
+		ctx:       ctx,
+		queue:     make(chan unknownSighting, queueSize),
+		batchSize: batchSize,
+		interval:  interval,
+		closing:   make(chan struct{}),
+		done:      make(chan struct{}),
+	}
+	go writer.run()
+
+	return writer
+}
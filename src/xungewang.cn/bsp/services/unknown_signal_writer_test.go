@@ -0,0 +1,95 @@
+package services
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+	"xungewang.cn/bsp/apis"
+)
+
+func TestUnknownSignalWriter_Write(t *testing.T) {
+	repo := &mockPositionRepo{}
+	writer := NewUnknownSignalWriter(repo, nil, 100, 3, time.Hour)
+
+	writer.Write([]apis.Signal{
+		{Radio: apis.RadioGSM, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60122", Strength: -78, HasStrength: true},
+		{Radio: apis.RadioLTE, Mcc: "460", Mnc: "0", Lac: "6334", Cid: "127502337"},
+	})
+	writer.Write([]apis.Signal{
+		{Radio: apis.RadioGSM, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60122", Strength: -90, HasStrength: true},
+		{Radio: apis.RadioGSM, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60122", Strength: -70, HasStrength: true},
+	})
+	writer.Close()
+
+	// a batch of 3 signals gets written once it's full, while the last one is written on close
+	stats := writer.Stats()
+	if stats.Written != 4 || stats.Dropped != 0 || stats.QueueDepth != 0 || len(repo.unknownSignals) != 3 {
+		t.Fatalf("unexpected stats %+v, signals written %v", stats, repo.unknownSignals)
+	}
+
+	first, lte := repo.unknownSignals[0], repo.unknownSignals[1]
+	if first.Id != "460-0-32838-60122" || first.HitCount != 2 || first.MaxStrength == nil || *first.MaxStrength != -78 {
+		t.Errorf("signals of the same cell should be aggregated: %+v", first)
+	}
+	if lte.Id != "lte-460-0-6334-127502337" || lte.HitCount != 1 || lte.MaxStrength != nil {
+		t.Errorf("unexpected lte signal: %+v", lte)
+	}
+	if last := repo.unknownSignals[2]; last.HitCount != 1 || *last.MaxStrength != -70 {
+		t.Errorf("unexpected last signal: %+v", last)
+	}
+
+	// signals written after closed are dropped
+	writer.Write([]apis.Signal{{Radio: apis.RadioGSM, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60122"}})
+	if stats := writer.Stats(); stats.Dropped != 1 || stats.QueueDepth != 0 {
+		t.Errorf("unexpected stats after closed: %+v", stats)
+	}
+}
+
+func TestUnknownSignalWriter_Write_QueueFull(t *testing.T) {
+	repo := &mockPositionRepo{}
+	writer := &UnknownSignalWriter{repo: repo, queue: make(chan unknownSighting, 2), batchSize: 10} // worker not started
+
+	writer.Write([]apis.Signal{
+		{Radio: apis.RadioGSM, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60122"},
+		{Radio: apis.RadioGSM, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60123"},
+		{Radio: apis.RadioGSM, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60124"},
+	})
+	if stats := writer.Stats(); stats.QueueDepth != 2 || stats.QueueCapacity != 2 || stats.Dropped != 1 {
+		t.Errorf("signals not fitting in the queue should be dropped: %+v", stats)
+	}
+}
+
+func TestUnknownSignalWriter_WriteWhileClosing(t *testing.T) {
+	repo := &mockPositionRepo{}
+	writer := NewUnknownSignalWriter(repo, nil, 1000, 10, time.Hour)
+
+	// signals keep being written until the writer is closed, each of which is either written or dropped, none
+	// is left in the queue
+	var total uint64
+	closed := make(chan struct{})
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for {
+				select {
+				case <-closed:
+					return
+				default:
+				}
+				writer.Write([]apis.Signal{{Radio: apis.RadioGSM, Mcc: "460", Mnc: "0", Lac: "32838", Cid: "60122"}})
+				atomic.AddUint64(&total, 1)
+			}
+		}()
+	}
+	time.Sleep(10 * time.Millisecond)
+	writer.Close()
+	close(closed)
+	wg.Wait()
+
+	if stats := writer.Stats(); stats.Written+stats.Dropped != total || stats.QueueDepth != 0 {
+		t.Errorf("signals lost of %d written: %+v", total, stats)
+	}
+}